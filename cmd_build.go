@@ -50,7 +50,7 @@ func cmdBuild() *cli.Command {
 
 			config, _ := loadConfig(cmd)
 
-			ibds := searchImageBuildDir(config.Dir, "archive")
+			ibds := searchImageBuildDirs(config.imageRoots(), "archive")
 			ibds.makeMap()
 			deps := ibds.Dependencies()
 
@@ -69,7 +69,11 @@ func cmdBuild() *cli.Command {
 				}
 				images = append(images, img)
 			}
-			solved, _ := checkDependency(images, deps)
+			solved, _, err := checkDependency(images, deps)
+			if err != nil {
+				logDependencyError(err)
+				os.Exit(1)
+			}
 
 			eimages := getExistImages(config.DockerBin)
 
@@ -48,7 +48,6 @@ func cmdClean() *cli.Command {
 
 			config, _ := loadConfig(cmd)
 			docker_bin := config.DockerBin
-			dir := config.Dir
 
 			var flags []string
 			if docker_bin != "docker" {
@@ -56,7 +55,7 @@ func cmdClean() *cli.Command {
 			}
 			flags = append(flags, cmd.StringSlice("flag")...)
 
-			ibds := searchImageBuildDir(dir, "archive")
+			ibds := searchImageBuildDirs(config.imageRoots(), "archive")
 			ibds.makeMap()
 
 			inputs := checkImageNamesInput(cmd, ibds) // load input image names from -l and args
@@ -3,9 +3,11 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/urfave/cli/v3"
@@ -19,16 +21,24 @@ func cmdConfig() *cli.Command {
 			cmdConfigShow(),
 			cmdConfigWrite(),
 			cmdConfigRemove(),
+			cmdConfigGet(),
+			cmdConfigSet(),
+			cmdConfigUnset(),
+			cmdConfigValidate(),
 		},
 	}
 }
 
 var (
 	DESCRIPTION_CONFIG_SHOW = `Show gdocker configuration.
-	This command reads the gdocker configuration file and displays its contents.
+	This command reads the gdocker configuration files and displays the merged contents.
+	The configuration is merged field by field, in order: the global configuration,
+	the project configuration, GDOCKER_* environment variables (e.g. GDOCKER_DIR),
+	and then command line flags. Use "--origin" to see which layer supplied each value.
 
 	Examples)
-	#> gdocker config show`
+	#> gdocker config show
+	#> gdocker config show --origin`
 )
 
 func cmdConfigShow() *cli.Command {
@@ -41,6 +51,7 @@ func cmdConfigShow() *cli.Command {
 		Description:        DESCRIPTION_CONFIG_SHOW,
 		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
 		Flags: []cli.Flag{
+			FLAG_ORIGIN,
 			FLAG_CONFIG_DEFAULT,
 			FLAG_SHOW_ABSPATH,
 			FLAG_VERBOSE,
@@ -50,27 +61,67 @@ func cmdConfigShow() *cli.Command {
 			slog.SetDefault(logger)
 
 			c, file := loadConfig(cmd)
+			if cmd.Bool("origin") {
+				printConfigOrigin(c)
+				return nil
+			}
+			for _, f := range c.files[:max(len(c.files)-1, 0)] {
+				fmt.Printf("config file (merged)  : '%s'\n", anonymizeConfigFile(f, c.ShowAbspath))
+			}
 			fmt.Printf(`config file           : '%s'
 Docker binary         : '%s'
 Docker image directory: '%s'
 Default architecture  : '%s'
 Show absolute path    :  %v
 Project tag           : '%s'
-`, anonymizeConfigFile(file, c.ShowAbspath), c.DockerBin, anonymizeWd(c.Dir, c.ShowAbspath), c.DefaultArch, c.ShowAbspath, c.ProjectTag)
+Local run log         :  %v
+Hardened run          :  %v
+Lock mode             : '%s'
+`, anonymizeConfigFile(file, c.ShowAbspath), c.DockerBin, anonymizeWd(c.Dir, c.ShowAbspath), c.DefaultArch, c.ShowAbspath, c.ProjectTag, c.LocalRunLog, c.Hardened, c.lockMode())
+			for _, root := range c.Roots {
+				fmt.Printf("Image root            : '%s' (name: '%s', arch: '%s')\n", anonymizeWd(root.Dir, c.ShowAbspath), root.Name, root.Arch)
+			}
 
 			return nil
 		},
 	}
 }
 
+var (
+	FLAG_ORIGIN = &cli.BoolFlag{
+		Name:  "origin",
+		Usage: "show which layer supplied each value",
+		Value: false,
+	}
+)
+
+// printConfigOrigin prints each value of the configuration with the layer supplied it.
+func printConfigOrigin(c Config) {
+	for _, file := range c.files {
+		fmt.Printf("# config file: '%s'\n", anonymizeConfigFile(file, c.ShowAbspath))
+	}
+	var records [][]string
+	for _, cf := range configFields() {
+		origin := c.Origin(cf.Key)
+		if slices.Contains(c.files, origin) {
+			origin = anonymizeConfigFile(origin, c.ShowAbspath)
+		}
+		records = append(records, []string{cf.Key, c.valueString(cf), origin})
+	}
+	writeCSV([]string{"Key", "Value", "Origin"}, records, os.Stdout)
+}
+
 var (
 	DESCRIPTION_CONFIG_WRITE = `Create and update gdocker configuration.
 	If options are specified (e.g. --dir), the configuration will be updated.
 	If no configuration file exists, a new one will be created with
-	the provided (+ default) options.
+	the provided (+ default) options. A project configuration (any file other than
+	the global one) only holds the provided options, and the others are taken
+	from the global configuration.
 
 	Examples)
-	#> gdocker config write`
+	#> gdocker config write
+	#> gdocker config write --config ./gdocker_conf.json --proj-tag awesome`
 )
 
 func cmdConfigWrite() *cli.Command {
@@ -88,6 +139,9 @@ func cmdConfigWrite() *cli.Command {
 			FLAG_ARCH,
 			FLAG_SHOW_ABSPATH,
 			FLAG_PROJ_TAG,
+			FLAG_LOCAL_RUN_LOG,
+			FLAG_HARDENED,
+			FLAG_LOCK_MODE,
 			FLAG_CONFIG_DEFAULT,
 			FLAG_VERBOSE,
 			FLAG_DRYRUN,
@@ -159,3 +213,221 @@ func cmdConfigRemove() *cli.Command {
 		},
 	}
 }
+
+var (
+	FLAG_GLOBAL = &cli.BoolFlag{
+		Name:  "global",
+		Usage: "update the global configuration file instead of the last one found",
+		Value: false,
+	}
+)
+
+var (
+	DESCRIPTION_CONFIG_GET = `Print a value of the merged gdocker configuration.
+	KEY is a JSON key of the configuration file (e.g. dir, stock_dir, project_tag).
+
+	Examples)
+	#> gdocker config get dir
+	#> gdocker config get hardened`
+
+	DESCRIPTION_CONFIG_SET = `Set a value in a gdocker configuration file.
+	KEY is a JSON key of the configuration file, and VALUE is parsed as its type.
+	Relative paths are resolved from the working directory.
+	The last configuration file found is updated (the project configuration if any),
+	or the global one with "--global".
+
+	Examples)
+	#> gdocker config set project_tag awesome
+	#> gdocker config set --global stock_dir ./stock`
+
+	DESCRIPTION_CONFIG_UNSET = `Remove a value from a gdocker configuration file.
+	The value falls back to the lower layers (or the default value).
+	The file is selected in the same way as "gdocker config set".
+
+	Examples)
+	#> gdocker config unset stock_dir
+	#> gdocker config unset --global hardened`
+
+	DESCRIPTION_CONFIG_VALIDATE = `Validate gdocker configuration files.
+	Each configuration file is checked for unknown keys, values of wrong types and
+	unsupported schema versions, and the merged configuration is checked for paths
+	and the docker binary which do not exist. All errors are reported, and
+	the exit status is 1 if any error is found.
+
+	Examples)
+	#> gdocker config validate`
+)
+
+func cmdConfigGet() *cli.Command {
+	return &cli.Command{
+		Name:               "get",
+		Usage:              "print a configuration value",
+		UsageText:          ``,
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          "[options] <key>",
+		Description:        DESCRIPTION_CONFIG_GET,
+		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
+		Flags: []cli.Flag{
+			FLAG_CONFIG_DEFAULT,
+			FLAG_VERBOSE,
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger("config get", getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
+
+			if cmd.NArg() != 1 {
+				slog.Error("please specify a key.")
+				os.Exit(1)
+			}
+			cf, err := lookupConfigField(cmd.Args().First())
+			if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+			c, _ := loadConfig(cmd)
+			fmt.Println(c.valueString(cf))
+			return nil
+		},
+	}
+}
+
+func cmdConfigSet() *cli.Command {
+	return &cli.Command{
+		Name:               "set",
+		Usage:              "set a configuration value",
+		UsageText:          ``,
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          "[options] <key> <value>",
+		Description:        DESCRIPTION_CONFIG_SET,
+		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
+		Flags: []cli.Flag{
+			FLAG_GLOBAL,
+			FLAG_CONFIG_DEFAULT,
+			FLAG_VERBOSE,
+			FLAG_DRYRUN,
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger("config set", getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
+
+			if cmd.NArg() != 2 {
+				slog.Error("please specify a key and a value.")
+				os.Exit(1)
+			}
+			file, m := readTargetConfigMap(cmd)
+			if err := setConfigValue(m, cmd.Args().Get(0), cmd.Args().Get(1)); err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+			writeConfigMap(file, m, cmd.Bool("dry-run"))
+			return nil
+		},
+	}
+}
+
+func cmdConfigUnset() *cli.Command {
+	return &cli.Command{
+		Name:               "unset",
+		Usage:              "remove a configuration value",
+		UsageText:          ``,
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          "[options] <key>",
+		Description:        DESCRIPTION_CONFIG_UNSET,
+		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
+		Flags: []cli.Flag{
+			FLAG_GLOBAL,
+			FLAG_CONFIG_DEFAULT,
+			FLAG_VERBOSE,
+			FLAG_DRYRUN,
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger("config unset", getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
+
+			if cmd.NArg() != 1 {
+				slog.Error("please specify a key.")
+				os.Exit(1)
+			}
+			key := cmd.Args().First()
+			// unknown keys can be removed (e.g. keys left by older versions)
+			file, m := readTargetConfigMap(cmd)
+			if _, ok := m[key]; !ok {
+				if _, err := lookupConfigField(key); err != nil {
+					slog.Error(err.Error())
+					os.Exit(1)
+				}
+				slog.Warn(fmt.Sprintf("`%s` is not set in '%s'", key, anonymizeConfigFile(file, false)))
+				return nil
+			}
+			delete(m, key)
+			writeConfigMap(file, m, cmd.Bool("dry-run"))
+			return nil
+		},
+	}
+}
+
+// readTargetConfigMap returns the configuration file to be updated by `config set/unset`,
+// and its raw content.
+func readTargetConfigMap(cmd *cli.Command) (string, map[string]json.RawMessage) {
+	files := cmd.StringSlice("config")
+	if cmd.Bool("global") {
+		files = []string{getGlobalConfigFile()}
+	}
+	file := searchConfigFiles(files)
+	m, err := readConfigMap(file)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	return file, m
+}
+
+func cmdConfigValidate() *cli.Command {
+	return &cli.Command{
+		Name:               "validate",
+		Usage:              "validate configuration files",
+		UsageText:          ``,
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          "[options]",
+		Description:        DESCRIPTION_CONFIG_VALIDATE,
+		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
+		Flags: []cli.Flag{
+			FLAG_CONFIG_DEFAULT,
+			FLAG_VERBOSE,
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger("config validate", getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
+
+			var errs []error
+			for _, file := range cmd.StringSlice("config") {
+				if !isFile(file) {
+					continue
+				}
+				m, err := readConfigMap(file)
+				if err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				errs = append(errs, checkConfigKeys(file, m)...)
+			}
+			if len(errs) == 0 {
+				c, _, err := readConfigLayers(cmd.StringSlice("config"))
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					errs = append(errs, c.validate()...)
+				}
+			}
+
+			for _, err := range errs {
+				fmt.Println(err.Error())
+			}
+			if len(errs) > 0 {
+				os.Exit(1)
+			}
+			fmt.Println("configuration is valid")
+			return nil
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/urfave/cli/v3"
+)
+
+var (
+	// flag for dashboard command
+	FLAG_DASHBOARD_ADDR = &cli.StringFlag{
+		Name:  "addr",
+		Value: "localhost:8080",
+		Usage: "`ADDRESS` (host:port) of the dashboard. the host must be a loopback address",
+	}
+)
+
+var (
+	ARGS_USAGE_DASHBOARD  = "[options]"
+	DESCRIPTION_DASHBOARD = `Serves a local dashboard of the image tree.
+	The dependency graph of all images is colored by the build status:
+	built, stale (the Dockerfile, Makefile or resources are newer than the image,
+	or an image it depends on is newer), not-built, external-present or
+	external-missing (external base images pulled or not). Clicking an image shows
+	the Dockerfile, Makefile resources, labels and size.
+	Build and clean jobs are queued and run one by one. Their logs are streamed.
+	The dashboard binds to localhost only and works without internet access.
+
+	Examples)
+	#> gdocker dashboard
+	#> gdocker dashboard --addr 127.0.0.1:8000
+
+	JSON API)
+	GET  /api/graph          the graph with the build status
+	GET  /api/nodes/{id}     the detail of an image (name:tag)
+	GET  /api/jobs           all jobs
+	POST /api/jobs           queue a job (Content-Type: application/json)
+	                         {"action": "build" or "clean", "images": ["name:tag", ...]}
+	GET  /api/jobs/{id}      a job (state: queued, running, done or failed)
+	GET  /api/jobs/{id}/log  the log of a job, streamed until the job is finished
+
+	#> curl -H 'Content-Type: application/json' -d '{"action":"build","images":["samtools_x:1.17"]}' localhost:8080/api/jobs
+	#> curl -N localhost:8080/api/jobs/1/log`
+)
+
+func cmdDashboard() *cli.Command {
+	return &cli.Command{
+		Name:               "dashboard",
+		Usage:              "serve a local dashboard to inspect, build and clean images",
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          ARGS_USAGE_DASHBOARD,
+		Description:        DESCRIPTION_DASHBOARD,
+		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
+		Flags: []cli.Flag{
+			FLAG_DIRECTORY,
+			FLAG_DASHBOARD_ADDR,
+			FLAG_DOCKER_BIN,
+			FLAG_CONFIG_DEFAULT,
+			FLAG_VERBOSE,
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger("dashboard", getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
+
+			config, _ := loadConfig(cmd)
+
+			addr := cmd.String("addr")
+			if err := checkLoopbackAddr(addr); err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+
+			// jobs run gdocker with the same configuration
+			var args []string
+			for _, file := range cmd.StringSlice("config") {
+				args = append(args, "--config", file)
+			}
+			for _, name := range []string{"dir", "docker-bin"} {
+				if cmd.IsSet(name) {
+					args = append(args, "--"+name, cmd.String(name))
+				}
+			}
+
+			d := newDashboard(config, args)
+			if err := d.serve(addr, d.handler()); err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+			return nil
+		},
+	}
+}
@@ -21,9 +21,12 @@ func cmdDev() *cli.Command {
 		Commands: []*cli.Command{
 			cmdDevInit(),
 			cmdDevMakeImageDir(),
+			cmdDevNew(),
 			cmdDevSave(),
 			cmdCopyDockerfileStocks(),
 			cmdDockerfile(),
+			cmdDevLint(),
+			cmdDevFmt(),
 		},
 	}
 }
@@ -43,38 +46,38 @@ var (
 func ubuntuDockerfile(platform, tag, tz string) *Dockerfile {
 	d := NewDockerfile(true)
 	d.AddStage(Stage{})
-	d.LastStage().AddInstruction(&FROM_INST{platform, "ubuntu", tag, ""})
+	d.LastStage().AddInstruction(&FROM_INST{platform: platform, image: "ubuntu", tag: tag})
 	d.LastStage().AddInstruction(&BLANK{})
-	d.LastStage().AddInstruction(&ENV_INST{[]string{"TZ"}, []string{tz}})
-	d.LastStage().AddInstruction(&VOLUMNE_INST{[]string{"/data", "/config", "/share"}})
+	d.LastStage().AddInstruction(&ENV_INST{keys: []string{"TZ"}, values: []string{tz}})
+	d.LastStage().AddInstruction(&VOLUMNE_INST{volumes: []string{"/data", "/config", "/share"}})
 	d.LastStage().AddInstruction(&COPY_INST{
-		"shell",
-		[]string{},
-		[]string{"docker_prompt.sh"},
-		"/config/docker_prompt.sh",
+		form:    "shell",
+		options: []string{},
+		src:     []string{"docker_prompt.sh"},
+		dest:    "/config/docker_prompt.sh",
 	})
 	d.LastStage().AddInstruction(&COPY_INST{
-		"shell",
-		[]string{"--chmod=777"},
-		[]string{"entrypoint.sh"},
-		"/usr/local/bin/entrypoint.sh",
+		form:    "shell",
+		options: []string{"--chmod=777"},
+		src:     []string{"entrypoint.sh"},
+		dest:    "/usr/local/bin/entrypoint.sh",
 	})
 	d.LastStage().AddInstruction(&COPY_INST{
-		"shell",
-		[]string{},
-		[]string{"cache/rush"},
-		"/usr/local/bin/rush",
+		form:    "shell",
+		options: []string{},
+		src:     []string{"cache/rush"},
+		dest:    "/usr/local/bin/rush",
 	})
 	d.LastStage().AddInstruction(&APT_INSTALL{
-		"shell",
-		[]string{
+		form: "shell",
+		command: []string{
 			"gosu", "\\",
 			"zstd", "\\",
 			"tzdata", "\\",
 			"ca-certificates", "\\",
 			"openssl", "\\",
 		},
-		"&&",
+		operator: "&&",
 	})
 	return &d
 }
@@ -395,6 +398,157 @@ func cmdDevMakeImageDir() *cli.Command {
 	}
 }
 
+var (
+	DESCRIPTION_DEV_NEW = `Render an image directory from a recipe.
+	This command creates {DIR}/{ARCH}/{NAME} with the Makefile (and its resources),
+	reproduce.sh and the tag directory (Dockerfile and other files) from a parameterised template.
+	Parameters of the recipe are set by --set, and the others are their defaults.
+	Built-in recipes are embedded in gdocker, and recipes in the directory of the configuration
+	key "recipe_dir" are also available (they take precedence over the built-in ones of the same name).
+	Use --list to see the recipes and their parameters.
+
+	A recipe is a directory named after the recipe containing:
+	  recipe.yaml      description, params ({name, usage, default, required}) and
+	                   resources ({name, commands}) of the Makefile
+	  Dockerfile.yaml  the Dockerfile in the spec format of 'gdocker dev dockerfile --spec'
+	  ...              other files put into the tag directory
+	All of them are templates ({{< .Name >}}) of Name, Tag, Arch, BaseImage (the image of
+	'gdocker dev init'), GdockerVersion and the parameters (e.g. {{< .pkg >}}).
+	{{< list .pkg >}} writes a comma separated value as a list.
+
+	Examples)
+	#> gdocker dev new --list
+	#> gdocker dev new --recipe conda-tool --name samtools --tag 1.17 --set pkg=samtools=1.17
+	#> gdocker dev new --recipe pip-tool --name multiqc --tag 1.21 -n`
+)
+
+func cmdDevNew() *cli.Command {
+	return &cli.Command{
+		Name:               "new",
+		Usage:              "render an image directory from a recipe",
+		UsageText:          ``,
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          "[options]",
+		Description:        DESCRIPTION_DEV_NEW,
+		Flags: []cli.Flag{
+			FLAG_RECIPE,
+			FLAG_RECIPE_SET,
+			FLAG_RECIPE_LIST,
+			&cli.StringFlag{Name: "name", Usage: "image name"},
+			&cli.StringFlag{Name: "tag", Aliases: []string{"t"}, Usage: "image tag"},
+			FLAG_DIRECTORY,
+			FLAG_ARCH,
+			FLAG_SHOW_ABSPATH,
+			FLAG_CONFIG_DEFAULT,
+			FLAG_VERBOSE,
+			FLAG_DRYRUN,
+		},
+		DisableSliceFlagSeparator: true,
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger("dev new", getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
+
+			config, _ := loadConfig(cmd)
+			dir := config.Dir
+			arch := config.DefaultArch
+
+			recipes, err := loadRecipes(config.RecipeDir)
+			if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+
+			if cmd.Bool("list") {
+				for _, recipe := range recipes {
+					origin := recipe.origin
+					if origin != "builtin" {
+						origin = anonymizeWd(origin, config.ShowAbspath)
+					}
+					fmt.Printf("%s (%s)\n    %s\n", recipe.Name, origin, recipe.Description)
+					for _, param := range recipe.Params {
+						var value string
+						switch {
+						case param.Required:
+							value = " (required)"
+						case param.Default != "":
+							value = fmt.Sprintf(" (default: %s)", param.Default)
+						}
+						fmt.Printf("    %-10s %s%s\n", param.Name, param.Usage, value)
+					}
+				}
+				return nil
+			}
+
+			name, tag := cmd.String("name"), cmd.String("tag")
+			if !cmd.IsSet("recipe") || name == "" || tag == "" {
+				slog.Error("--recipe, --name and --tag are required")
+				os.Exit(1)
+			}
+			recipe, ok := lookupRecipe(recipes, cmd.String("recipe"))
+			if !ok {
+				var names []string
+				for _, r := range recipes {
+					names = append(names, r.Name)
+				}
+				slog.Error(fmt.Sprintf("no recipe '%s' (one of %s)", cmd.String("recipe"), strings.Join(names, ", ")))
+				os.Exit(1)
+			}
+
+			image_dir := filepath.Join(dir, arch, name)
+			if isFile(filepath.Join(image_dir, "Makefile")) {
+				slog.Error(fmt.Sprintf("image directory '%s' already exists", anonymizeWd(image_dir, config.ShowAbspath)))
+				os.Exit(1)
+			}
+
+			data, err := recipe.templateData(name, tag, arch, cmd.StringSlice("set"))
+			if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+			resources, err := recipe.renderResources(data)
+			if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+			files, err := recipe.renderTagFiles(data)
+			if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+
+			box := cmd.Bool("dry-run")
+			slog.Info(fmt.Sprintf("rendering recipe '%s' into '%s'", recipe.Name, anonymizeWd(image_dir, config.ShowAbspath)))
+
+			// Makefile
+			tm := NewTemplates(TMPL_MAKEFILE, map[string]any{"GdockerVersion": APP_VERSION, "Name": name, "Tags": []string{tag}})
+			oldver := dataMakeOldVer{Tag: tag}
+			for _, resource := range resources {
+				tm.AddTemplate(TEMPLATE_RESOURCE, resource)
+				oldver.Resources = append(oldver.Resources, filepath.Join(tag, "$(DIR_OUT)", resource.Resource))
+			}
+			tm.AddTemplate(TEMPLATE_OLDVER, oldver)
+			if !box {
+				mkDirAll(filepath.Join(image_dir, tag))
+			}
+			tm.writeTemplates(filepath.Join(image_dir, "Makefile"), box)
+
+			// Dockerfile and the other files
+			for _, f := range files {
+				writeRecipeFile(filepath.Join(image_dir, tag, f.path), f, box)
+			}
+
+			// reproduce.sh
+			args := []string{"gdocker", "dev", "new", "--arch", arch, "--recipe", recipe.Name, "--name", shellQuote(name), "--tag", shellQuote(tag)}
+			for _, set := range cmd.StringSlice("set") {
+				args = append(args, "\\\n\t--set", shellQuote(set))
+			}
+			writeRecipeFile(filepath.Join(image_dir, "reproduce.sh"), recipeFile{content: []byte(strings.Join(args, " ")), perm: 0644}, box)
+
+			return nil
+		},
+	}
+}
+
 var (
 	DESCRIPTION_DEV_SAVE = `Helps to save pre-existing Dockerfiles into specified directory.
 	This command copies Dockerfiles from the image building directories to the
@@ -563,30 +717,68 @@ var (
 		Usage: `specify instruction
 type        | (upper) short
             | (lower) full  { (): choice, []: optional, ...: plural }
- FROM       | from:<image>[:<tag>:<alias>]
-            | FROM:<platform>:<image>:<tag>:<alias>
- RUN        | run:[<sep>]:<command>[<sep><command>...][:<op>]
-            | RUN:<form>:[<sep>]:<command>[<sep><command>...][:<op>]
- ENTRYPOINT | ep:[<sep>]:<command>[<sep><command>...]
-            | ENTRYPOINT:<form>:[<sep>]:<command>[<sep><command>...]
- COPY       | cp:[<sep>]:<src>[<sep><src>...]:<dest>[:<option>,<option>]
-            | COPY:<form>:<sep>:<option...>:<src...>:<dest>
- WORKDIR    | wd:<directory>
-            | WORKDIR:<directory>
- ENV        | env:<key=value>[:<key=value>...]
-            | ENV:<key=value>[:<key=value>...]
- VOLUME     | vol:<volume>[:<volume>...]
-            | VOLUME:<volume>[:<volume>...]
- LABEL      | lab:<key=value>[:<key=value>...]
-            | LABEL:<key=value>[:<key=value>...]
+ FROM        | from:<image>[:<tag>:<alias>]
+             | FROM:<platform>:<image>:<tag>:<alias>
+ ARG         | arg:<name>[=<default>][:<name>[=<default>]...]
+             | ARG:<name>[=<default>][:<name>[=<default>]...]
+ RUN         | run:[<sep>]:<command>[<sep><command>...][:<op>[:<option>[<sep><option>...]]]
+             | RUN:<form>:[<sep>]:<command>[<sep><command>...][:<op>[:<option>[<sep><option>...]]]
+ CMD         | cmd:[<sep>]:<command>[<sep><command>...]
+             | CMD:<form>:[<sep>]:<command>[<sep><command>...]
+ ENTRYPOINT  | ep:[<sep>]:<command>[<sep><command>...]
+             | ENTRYPOINT:<form>:[<sep>]:<command>[<sep><command>...]
+ COPY        | cp:[<sep>]:<src>[<sep><src>...]:<dest>[:<option>,<option>]
+             | COPY:<form>:<sep>:<option...>:<src...>:<dest>
+ ADD         | add:[<sep>]:<src>[<sep><src>...]:<dest>[:<option>,<option>]
+             | ADD:<form>:<sep>:<option...>:<src...>:<dest>
+ WORKDIR     | wd:<directory>
+             | WORKDIR:<directory>
+ ENV         | env:<key=value>[:<key=value>...]
+             | ENV:<key=value>[:<key=value>...]
+ VOLUME      | vol:<volume>[:<volume>...]
+             | VOLUME:<volume>[:<volume>...]
+ LABEL       | lab:<key=value>[:<key=value>...]
+             | LABEL:<key=value>[:<key=value>...]
+ USER        | user:<user>[:<group>]
+             | USER:<user>[:<group>]
+ EXPOSE      | expose:<port>[/<protocol>][:<port>[/<protocol>]...]
+             | EXPOSE:<port>[/<protocol>][:<port>[/<protocol>]...]
+ SHELL       | shell:[<sep>]:<executable>[<sep><parameter>...]
+             | SHELL:[<sep>]:<executable>[<sep><parameter>...]
+ HEALTHCHECK | hc:[<sep>]:<command>[<sep><command>...][:<option>,<option>]  or  hc:none
+             | HEALTHCHECK:<form>:<sep>:<option...>:<command...>  or  HEALTHCHECK:none
+ STOPSIGNAL  | stop:<signal>
+             | STOPSIGNAL:<signal>
+ ONBUILD     | onbuild:<type>:<instruction>  (<type>: any type above but FROM and ONBUILD)
+             | ONBUILD:<type>:<instruction>
 other
- apt install | apt:<pkg>[,<pkg>...]
- blank line  | bl:
- comment     | cm:<comment>
- switch      | switch:[<sep>]
- switchonce  | switchonce:[<sep>]
+ apt install  | apt:<pkg>[,<pkg>...][:cache]  (cache: use the apt cache mounts)
+ pip install  | pip:<pkg>[=<version>][,<pkg>...][:<option>,<option>]  (index=<url>, extra-index=<url>)
+ conda        | conda:<pkg>[=<version>][,<pkg>...][:<option>,<option>]  (channel=<channel>)
+ micromamba   | micromamba:<pkg>[=<version>][,<pkg>...][:<option>,<option>]  (channel=<channel>)
+ R packages   | r:<pkg>[=<version>][,<pkg>...][:<option>,<option>]  (repos=<url>)
+              |   (pinned versions are installed by remotes, which is installed first if missing)
+ apk add      | apk:<pkg>[=<version>][,<pkg>...][:<option>,<option>]  (repository=<url>)
+ dnf install  | dnf:<pkg>[=<version>][,<pkg>...][:<option>,<option>]  (repo=<repo id>)
+ blank line   | bl:
+ comment      | cm:<comment>
+ heredoc      | heredoc:[<sep>]:<line>[<sep><line>...]  (body of the last RUN)
+ switch       | switch:[<sep>]
+ switchonce   | switchonce:[<sep>]
 `,
-		Required: true,
+	}
+	FLAG_DF_MODE = &cli.StringFlag{
+		Name:  "mode",
+		Usage: "create a new Dockerfile or edit the existing one (`MODE`: create, append, insert-after, replace, remove or apt)",
+		Value: "create",
+	}
+	FLAG_DF_MATCH = &cli.StringFlag{
+		Name:  "match",
+		Usage: "select instructions to edit by the keyword and/or a regular expression (`SELECTOR`: <KEYWORD>[~<regexp>] or ~<regexp>)",
+	}
+	FLAG_DF_SPEC = &cli.StringFlag{
+		Name:  "spec",
+		Usage: "a YAML or JSON file (`FILE`) describing the stages and instructions (added before --inst)",
 	}
 )
 
@@ -596,11 +788,53 @@ func cmdDockerfile() *cli.Command {
 		Usage:              "test",
 		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
 		ArgsUsage:          "[options]",
-		Description: `Compose a Dockerfile from instruction strings.
+		Description: `Compose a Dockerfile from instruction strings, or edit the existing one.
 	This command parses one or more --inst values and appends the corresponding instructions to a Dockerfile.
 	The output path is derived from --dir, --arch, --name, and --tag as {DIR}/{ARCH}/{NAME}/{TAG}/Dockerfile.
 	Use --dry-run to preview the result without writing a file. You can temporarily change the
 	argument separator by using the pseudo-instructions 'switch' and 'switchonce'.
+	RUN can have options (e.g. --mount=type=cache,target=/root/.cache, --network=none)
+	after the operator, and the pseudo-instruction 'heredoc' gives the last RUN a heredoc.
+	RUNs with different options or heredocs are not merged.
+	Packages of pip, conda, micromamba, r, apk and dnf are pinned as <pkg>=<version>
+	(written in the syntax of each manager), and the installs of the same manager and options
+	in a stage are merged into the first one as apt.
+	Values of ENV, LABEL and ARG are quoted as needed ($NAME and ${NAME} are expanded
+	by the builder, and \$ is a literal $). COPY and ADD of paths with spaces are written
+	in the exec form, and so is a shell form with line breaks (run by /bin/sh -c).
+
+	With --mode other than create, the existing Dockerfile is parsed and edited instead of
+	being overwritten, so hand edits are kept (--dry-run shows the changes as a unified diff):
+	  append        add the --inst instructions at the end of the last stage
+	                (before the footer of gdocker: LABEL com.gdocker.version, WORKDIR and ENTRYPOINT)
+	  insert-after  add the --inst instructions after each instruction of --match
+	  replace       replace each instruction of --match with the --inst instructions
+	  remove        remove each instruction of --match
+	  apt           add the packages of the --inst apt instructions to an existing apt install
+	                (the first one in the final stage, or the first one of --match)
+	--match selects instructions by the keyword and/or a regular expression of the text
+	(e.g. COPY, RUN~apt-get, ~entrypoint.sh). FROM can not be added, replaced or removed.
+
+	Instead of (or in addition to) --inst, the stages and instructions can be described
+	in a spec file with --spec (JSON if the extension is ".json", otherwise YAML).
+	Each instruction is a mapping of a single key, and strings need no separators:
+
+	  args: [VERSION=1.17]
+	  stages:
+	    - from: ubuntu_a:22.04
+	      instructions:
+	        - apt: [curl, bzip2]
+	        - run: |
+	            curl -fsSL https://example.com/foo.tar.bz2 | tar xj &&
+	            make -C foo install
+	        - copy: [entrypoint.sh, /usr/local/bin/]
+	        - env: {LANG: C.UTF-8}
+
+	Keys of the instructions are comment, blank, arg, run, cmd, entrypoint, copy, add,
+	workdir, env, volume, label, user, expose, shell, healthcheck, stopsignal, onbuild, apt,
+	pip, conda, micromamba, r, apk and dnf. The options of the package managers are keys
+	(e.g. - micromamba: {packages: [samtools=1.17], channel: [conda-forge, bioconda]}).
+	A string of run/cmd/entrypoint is the shell form, and a list is the exec form.
 
 	Examples)
 	# Mix full and shorthand forms
@@ -609,10 +843,30 @@ func cmdDockerfile() *cli.Command {
 	#>   --inst from:ubuntu
 	#>   --inst switchonce:# \
 	#>   --inst run:#download,https://example.com/foo.exe
-	#>   --inst vol:/foo:/bar`,
+	#>   --inst vol:/foo:/bar
+	# RUN options and a heredoc (RUN --network=none python3 <<EOF)
+	#> gdocker dev dockerfile --name foo --tag bar \
+	#>   --inst from:ubuntu \
+	#>   --inst apt:python3:cache \
+	#>   --inst 'run:;:python3:&&:--network=none' \
+	#>   --inst 'heredoc:;:import sys;print(sys.version)'
+	# Packages of the other managers with a pinned version and channels
+	#> gdocker dev dockerfile --name foo --tag bar \
+	#>   --inst from:mambaorg/micromamba:1.5.8 \
+	#>   --inst micromamba:samtools=1.17,bcftools:channel=conda-forge,channel=bioconda \
+	#>   --inst pip:pysam
+	# Describe the instructions in a file
+	#> gdocker dev dockerfile --name foo --tag bar --spec foo.yaml
+	# Edit the existing Dockerfile
+	#> gdocker dev dockerfile --name foo --tag bar --mode apt --inst apt:htop,jq -n
+	#> gdocker dev dockerfile --name foo --tag bar --mode insert-after --match 'RUN~apt-get' --inst wd:/opt
+	#> gdocker dev dockerfile --name foo --tag bar --mode remove --match '~^# TODO'`,
 		Before: setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
 		Flags: []cli.Flag{
 			FLAG_DF_INST,
+			FLAG_DF_SPEC,
+			FLAG_DF_MODE,
+			FLAG_DF_MATCH,
 			FLAG_DIRECTORY,
 			FLAG_ARCH,
 			FLAG_NAME,
@@ -632,114 +886,488 @@ func cmdDockerfile() *cli.Command {
 			name := cmd.String("name")
 			tag := cmd.String("tag")
 
+			outf := filepath.Join(dir, arch, name, tag, "Dockerfile")
+			outf = anonymizeWd(outf, config.ShowAbspath)
+
+			mode := cmd.String("mode")
+			if !slices.Contains(DOCKERFILE_MODES, mode) {
+				slog.Error(fmt.Sprintf("invalid mode '%s' (one of %s)", mode, strings.Join(DOCKERFILE_MODES, ", ")))
+				os.Exit(1)
+			}
+			if mode != "create" {
+				editDockerfile(cmd, outf, mode)
+				return nil
+			}
+
 			df := NewDockerfile(true)
 
-			for _, v := range cmd.StringSlice("inst") {
-				inst_type, rest, ok := strings.Cut(v, ":")
-				if !ok {
-					slog.Error(fmt.Sprintf("no instruction type found, '%s'", v))
+			if !cmd.IsSet("spec") && !cmd.IsSet("inst") {
+				slog.Error("either --spec or --inst is required")
+				os.Exit(1)
+			}
+			if cmd.IsSet("spec") {
+				spec, err := readDockerfileSpec(cmd.String("spec"))
+				if err != nil {
+					slog.Error(err.Error())
 					os.Exit(1)
 				}
+				if err := spec.AddTo(&df); err != nil {
+					slog.Error(fmt.Sprintf("%s: %s", cmd.String("spec"), err.Error()))
+					os.Exit(1)
+				}
+			}
 
-				// switch is not an instruction.
-				// it can be used to change the global separater to parse instruction correctlly.
-				// (e.g. to parse URL like https://example.com)
-				switch inst_type {
-				case "switch":
-					if rest == "" {
-						ARG_SEPARATER = ":"
-					} else {
-						ARG_SEPARATER = rest
-					}
-					continue
-				case "switchonce":
-					ONCE = true
-					OLD_ARG_SEPARATER = ARG_SEPARATER
-					if rest == "" {
-						ARG_SEPARATER = ":"
-					} else {
-						ARG_SEPARATER = rest
-					}
-					continue
+			parseInstFlags(cmd.StringSlice("inst"), df.AddInstruction, func() InstructionBuilder {
+				if df.numStages == 0 || len(df.LastStage().Instructions) == 0 {
+					return nil
 				}
+				return df.LastStage().Instructions[len(df.LastStage().Instructions)-1]
+			})
 
-				// assign instruction
-				var inst InstructionBuilder
-				short := false
-				switch inst_type {
-				// use full parser
-				case "FROM":
-					inst = &FROM_INST{}
-				case "RUN":
-					inst = &RUN_INST{}
-				case "ENTRYPOINT":
-					inst = &ENTRYPOINT_INST{}
-				case "COPY":
-					inst = &COPY_INST{}
-				case "WORKDIR":
-					inst = &WORKDIR_INST{}
-				case "ENV":
-					inst = &ENV_INST{}
-				case "VOLUME":
-					inst = &VOLUMNE_INST{}
-				case "LABEL":
-					inst = &LABEL_INST{}
-				// use short-hand parser
-				case "lab":
-					inst = &LABEL_INST{}
-					short = true
-				case "from":
-					inst = &FROM_INST{}
-					short = true
-				case "run":
-					inst = &RUN_INST{}
-					short = true
-				case "ep":
-					inst = &ENTRYPOINT_INST{}
-					short = true
-				case "cp":
-					inst = &COPY_INST{}
-					short = true
-				case "wd":
-					inst = &WORKDIR_INST{}
-					short = true
-				case "env":
-					inst = &ENV_INST{}
-					short = true
-				case "vol":
-					inst = &VOLUMNE_INST{}
-					short = true
-				case "cm":
-					inst = &COMMENT{}
-				case "bl":
-					inst = &BLANK{}
-				case "apt":
-					inst = &APT_INSTALL{}
-				// undefined instruction
-				default:
-					slog.Error(fmt.Sprintf("invalid type '%s'", inst_type))
-					os.Exit(1)
+			df.WriteTo(outf, cmd.Bool("dry-run"))
+
+			return nil
+		},
+	}
+}
+
+// parseInstFlags parses the values of --inst and adds the instructions in order.
+// last returns the last instruction added (for the pseudo-instruction heredoc).
+func parseInstFlags(values []string, add func(InstructionBuilder), last func() InstructionBuilder) {
+	for _, v := range values {
+		inst_type, rest, ok := strings.Cut(v, ":")
+		if !ok {
+			slog.Error(fmt.Sprintf("no instruction type found, '%s'", v))
+			os.Exit(1)
+		}
+
+		// switch is not an instruction.
+		// it can be used to change the global separater to parse instruction correctlly.
+		// (e.g. to parse URL like https://example.com)
+		switch inst_type {
+		case "switch":
+			if rest == "" {
+				ARG_SEPARATER = ":"
+			} else {
+				ARG_SEPARATER = rest
+			}
+			continue
+		case "switchonce":
+			ONCE = true
+			OLD_ARG_SEPARATER = ARG_SEPARATER
+			if rest == "" {
+				ARG_SEPARATER = ":"
+			} else {
+				ARG_SEPARATER = rest
+			}
+			continue
+		case "heredoc":
+			// heredoc is not an instruction either. it sets the body of the heredoc to the last RUN.
+			run, _ := last().(*RUN_INST)
+			if run == nil {
+				slog.Error("heredoc must follow RUN")
+				os.Exit(1)
+			}
+			sep, body, err := separatorCheck(rest)
+			if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+			run.heredoc = strings.Join(strings.Split(body, sep), "\n")
+			if ONCE {
+				ARG_SEPARATER = OLD_ARG_SEPARATER
+				ONCE = false
+			}
+			continue
+		}
+
+		// assign instruction
+		inst, short, ok := newInstructionBuilder(inst_type)
+		if !ok {
+			// undefined instruction
+			slog.Error(fmt.Sprintf("invalid type '%s'", inst_type))
+			os.Exit(1)
+		}
+
+		// parse instruction
+		parse := inst.Parse
+		if short {
+			parse = inst.ParseShort
+		}
+		if err := parse(rest); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		if ONCE {
+			ARG_SEPARATER = OLD_ARG_SEPARATER
+			ONCE = false
+		}
+
+		add(inst)
+	}
+}
+
+// editDockerfile edits the existing Dockerfile by the mode (see DOCKERFILE_MODES).
+// The file is parsed, so the instructions not edited are kept as they are written.
+// With --dry-run, the changes are shown as a unified diff.
+func editDockerfile(cmd *cli.Command, file string, mode string) {
+	if cmd.IsSet("spec") {
+		slog.Error(fmt.Sprintf("--spec can not be used with --mode %s", mode))
+		os.Exit(1)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	df, err := readDockerfile(file)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	var insts []InstructionBuilder
+	parseInstFlags(cmd.StringSlice("inst"), func(inst InstructionBuilder) {
+		if _, isFrom := inst.(*FROM_INST); isFrom {
+			slog.Error(fmt.Sprintf("FROM can not be added with --mode %s", mode))
+			os.Exit(1)
+		}
+		insts = append(insts, inst)
+	}, func() InstructionBuilder {
+		if len(insts) == 0 {
+			return nil
+		}
+		return insts[len(insts)-1]
+	})
+	switch {
+	case mode == "remove" && len(insts) > 0:
+		slog.Error("--inst can not be used with --mode remove")
+		os.Exit(1)
+	case mode != "remove" && len(insts) == 0:
+		slog.Error(fmt.Sprintf("--inst is required with --mode %s", mode))
+		os.Exit(1)
+	}
+
+	var matcher *instructionMatcher
+	if cmd.IsSet("match") {
+		m, err := parseInstructionMatcher(cmd.String("match"))
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		matcher = &m
+	}
+	switch mode {
+	case "insert-after", "replace", "remove":
+		if matcher == nil {
+			slog.Error(fmt.Sprintf("--match is required with --mode %s", mode))
+			os.Exit(1)
+		}
+	case "append":
+		if matcher != nil {
+			slog.Error("--match can not be used with --mode append")
+			os.Exit(1)
+		}
+	}
+
+	switch mode {
+	case "append":
+		df.Append(insts)
+	case "insert-after", "replace", "remove":
+		n, err := df.EditMatches(*matcher, func(inst InstructionBuilder) []InstructionBuilder {
+			switch mode {
+			case "insert-after":
+				return append([]InstructionBuilder{inst}, insts...)
+			case "replace":
+				return insts
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		if n == 0 {
+			slog.Error(fmt.Sprintf("no instruction matches '%s'", cmd.String("match")))
+			os.Exit(1)
+		}
+		slog.Info(fmt.Sprintf("%d instruction(s) matched '%s'", n, cmd.String("match")))
+	case "apt":
+		var pkgs []string
+		for _, inst := range insts {
+			apt, ok := inst.(*APT_INSTALL)
+			if !ok {
+				slog.Error("only apt instructions can be given with --mode apt")
+				os.Exit(1)
+			}
+			for _, pkg := range apt.command {
+				if pkg != "\\" {
+					pkgs = append(pkgs, pkg)
 				}
+			}
+		}
+		added, err := df.AddAptPackages(pkgs, matcher)
+		if err != nil {
+			slog.Error(fmt.Sprintf("%s: %s", file, err.Error()))
+			os.Exit(1)
+		}
+		if len(added) == 0 {
+			slog.Info("all packages are already installed")
+		}
+	}
 
-				// parse instruction
-				if short {
-					inst.ParseShort(rest)
-				} else {
-					inst.Parse(rest)
+	edited := df.Bytes()
+	if cmd.Bool("dry-run") {
+		fmt.Print(unifiedDiff("a/"+file, "b/"+file, string(b), string(edited)))
+		return
+	}
+	if string(edited) == string(b) {
+		slog.Info(fmt.Sprintf("no changes in '%s'", file))
+		return
+	}
+	if err := os.WriteFile(file, edited, 0644); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+var (
+	ARGS_USAGE_DEV_LINT  = "[options] [image names...]"
+	DESCRIPTION_DEV_LINT = `Check the Dockerfiles of the image build directories.
+	This command runs the rules below over the Dockerfile of every tag of the images
+	(all images if no image is specified; "name" checks all tags, "name:tag" one tag),
+	and prints the problems as "{Dockerfile}:{line}: {rule ID} {message}".
+	Rules can be disabled by IDs or names with the configuration key "lint_disable".
+
+	Exit status is 0 if no problem is found, 1 if any problem is found,
+	and 2 if any Dockerfile could not be read.
+
+	Rules)
+	GD001 apt-lists      apt-get install without removing /var/lib/apt/lists/* in the same RUN
+	GD002 latest-parent  FROM a floating parent image (no tag or "latest")
+	GD003 gdocker-label  the final stage has no com.gdocker.version label
+	GD004 copy-source    a COPY/ADD source is not found in the build context
+	GD005 add-url        ADD of a remote URL
+	GD006 entrypoint     the ENTRYPOINT differs from the preset
+
+	Examples)
+	#> gdocker dev lint
+	#> gdocker dev lint ubuntu_a samtools_x:1.17
+	#> gdocker config set lint_disable '["GD002", "add-url"]'`
+)
+
+func cmdDevLint() *cli.Command {
+	return &cli.Command{
+		Name:               "lint",
+		Usage:              "check Dockerfiles of the images",
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          ARGS_USAGE_DEV_LINT,
+		Description:        DESCRIPTION_DEV_LINT,
+		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
+		Flags: []cli.Flag{
+			FLAG_DIRECTORY,
+			FLAG_SHOW_ABSPATH,
+			FLAG_CONFIG_DEFAULT,
+			FLAG_VERBOSE,
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger("dev lint", getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
+
+			config, _ := loadConfig(cmd)
+			for _, rule := range config.LintDisable {
+				if _, ok := lookupLintRule(rule); !ok {
+					slog.Warn(fmt.Sprintf("unknown rule '%s' in lint_disable", rule))
 				}
-				if ONCE {
-					ARG_SEPARATER = OLD_ARG_SEPARATER
-					ONCE = false
+			}
+
+			ibds := searchImageBuildDirs(config.imageRoots(), "archive")
+			ibds.makeMap()
+
+			order, selected, err := selectImageTags(cmd.Args().Slice(), ibds)
+			if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+
+			status := 0
+			for _, i := range order {
+				ibd := ibds.ibds[i]
+				for _, tag := range selected[i] {
+					file := filepath.Join(ibd.Directory(), tag, "Dockerfile")
+					d, err := readDockerfile(file)
+					if err != nil {
+						slog.Error(strings.Replace(err.Error(), file, anonymizeWd(file, config.ShowAbspath), 1))
+						status = 2
+						continue
+					}
+					var resources []string
+					for _, res := range readMakeResources(filepath.Join(ibd.Directory(), "Makefile"), tag) {
+						resources = append(resources, res.Name)
+					}
+					t := lintTarget{
+						file:      anonymizeWd(file, config.ShowAbspath),
+						context:   filepath.Join(ibd.Directory(), tag),
+						tag:       tag,
+						d:         d,
+						resources: resources,
+					}
+					for _, f := range lintDockerfile(t, config.LintDisable) {
+						fmt.Println(f)
+						status = max(status, 1)
+					}
 				}
+			}
+			if status != 0 {
+				os.Exit(status)
+			}
+			return nil
+		},
+	}
+}
 
-				// add instruction to the last stage
-				df.AddInstruction(inst)
+// selectImageTags returns the image build directories selected by the arguments in order,
+// and their tags ("name" selects all tags, "name:tag" one tag and "name:latest" the latest tag).
+// All images are selected if no argument is given.
+func selectImageTags(args []string, ibds ImageBuildDirs) ([]int, map[int][]string, error) {
+	selected := make(map[int][]string)
+	var order []int
+	selectTags := func(i int, tags ...string) {
+		if _, ok := selected[i]; !ok {
+			order = append(order, i)
+		}
+		for _, tag := range tags {
+			if !slices.Contains(selected[i], tag) {
+				selected[i] = append(selected[i], tag)
 			}
+		}
+	}
+	if len(args) == 0 {
+		for i, ibd := range ibds.ibds {
+			selectTags(i, ibd.dirTags...)
+		}
+	}
+	for _, arg := range args {
+		img, err := NewDockerImage(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		i, ok := ibds.mapName[img.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is not found", arg)
+		}
+		ibd := ibds.ibds[i]
+		switch {
+		case !strings.Contains(arg, ":"):
+			selectTags(i, ibd.dirTags...)
+		case img.Tag == "latest":
+			selectTags(i, ibd.dirTags[ibd.tagLatest])
+		case slices.Contains(ibd.dirTags, img.Tag):
+			selectTags(i, img.Tag)
+		default:
+			return nil, nil, fmt.Errorf("%s is not found", arg)
+		}
+	}
+	return order, selected, nil
+}
 
-			outf := filepath.Join(dir, arch, name, tag, "Dockerfile")
-			outf = anonymizeWd(outf, config.ShowAbspath)
-			df.WriteTo(outf, cmd.Bool("dry-run"))
+var (
+	ARGS_USAGE_DEV_FMT  = "[options] [image names...]"
+	DESCRIPTION_DEV_FMT = `Format the Dockerfiles of the image build directories.
+	This command rewrites the Dockerfile of every tag of the images
+	(all images if no image is specified; "name" formats all tags, "name:tag" one tag)
+	in the style of the Dockerfiles written by gdocker:
+	  - instruction keywords in upper case
+	  - continued lines indented by a tab and a space, with " \" at the end
+	  - packages of apt-get and pip install sorted and deduplicated
+	    (when written a package per line or in a line, without quotes)
+	  - no consecutive blank lines, and a blank line before each stage
+	Comments, heredocs and the lines in quoted strings are kept as they are.
+
+	With --check, the files are not written, and the differences are printed.
+	Exit status is 0 if all files are formatted, 1 if any file is not formatted (--check),
+	and 2 if any Dockerfile could not be read.
+
+	Examples)
+	#> gdocker dev fmt
+	#> gdocker dev fmt --check samtools_x:1.17
+	#> gdocker dev fmt -n ubuntu_a`
+)
+
+func cmdDevFmt() *cli.Command {
+	return &cli.Command{
+		Name:               "fmt",
+		Usage:              "format Dockerfiles of the images",
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          ARGS_USAGE_DEV_FMT,
+		Description:        DESCRIPTION_DEV_FMT,
+		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
+		Flags: []cli.Flag{
+			FLAG_DIRECTORY,
+			FLAG_SHOW_ABSPATH,
+			FLAG_CONFIG_DEFAULT,
+			FLAG_VERBOSE,
+			FLAG_DRYRUN,
+			&cli.BoolFlag{
+				Name:  "check",
+				Value: false,
+				Usage: "print the differences and exit with 1 if not formatted",
+			},
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger("dev fmt", getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
 
+			config, _ := loadConfig(cmd)
+			ibds := searchImageBuildDirs(config.imageRoots(), "archive")
+			ibds.makeMap()
+
+			order, selected, err := selectImageTags(cmd.Args().Slice(), ibds)
+			if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+
+			status := 0
+			for _, i := range order {
+				ibd := ibds.ibds[i]
+				for _, tag := range selected[i] {
+					file := filepath.Join(ibd.Directory(), tag, "Dockerfile")
+					name := anonymizeWd(file, config.ShowAbspath)
+					b, err := os.ReadFile(file)
+					if err != nil {
+						slog.Error(err.Error())
+						status = 2
+						continue
+					}
+					d, err := readDockerfile(file)
+					if err != nil {
+						slog.Error(strings.Replace(err.Error(), file, name, 1))
+						status = 2
+						continue
+					}
+					formatted := FormatDockerfile(&d)
+					if string(formatted) == string(b) {
+						slog.Debug(fmt.Sprintf("'%s' is formatted", name))
+						continue
+					}
+					if cmd.Bool("check") || cmd.Bool("dry-run") {
+						fmt.Print(unifiedDiff("a/"+name, "b/"+name, string(b), string(formatted)))
+						if cmd.Bool("check") {
+							status = max(status, 1)
+						}
+						continue
+					}
+					if err := os.WriteFile(file, formatted, 0644); err != nil {
+						slog.Error(err.Error())
+						os.Exit(1)
+					}
+					fmt.Println(name)
+				}
+			}
+			if status != 0 {
+				os.Exit(status)
+			}
 			return nil
 		},
 	}
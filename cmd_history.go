@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+var (
+	// flags for history command
+	FLAG_HISTORY_IMAGE = &cli.StringFlag{
+		Name:    "image",
+		Aliases: []string{"i"},
+		Usage:   "show runs of the image (`NAME`, NAME:TAG or image ID prefix)",
+	}
+	FLAG_HISTORY_CWD = &cli.StringFlag{
+		Name:    "cwd",
+		Aliases: []string{"c"},
+		Usage:   "show runs in the directory `DIR` and its sub directories",
+	}
+	FLAG_HISTORY_LOCAL = &cli.BoolFlag{
+		Name:    "local",
+		Aliases: []string{"l"},
+		Value:   false,
+		Usage:   "read './.gdocker-runs.jsonl' instead of the global run log",
+	}
+	FLAG_HISTORY_JSON = &cli.BoolFlag{
+		Name:    "json",
+		Aliases: []string{"j"},
+		Value:   false,
+		Usage:   "print records as JSONL",
+	}
+)
+
+var (
+	ARGS_USAGE_HISTORY  = "[options]"
+	DESCRIPTION_HISTORY = `Shows the provenance log of containers run by gdocker.
+	Every "run"/"wdrun" invocation is recorded with timestamp, working directory,
+	image ID, labels, full docker arguments, exit code and duration.
+	The log is kept in the gdocker state directory, and also in
+	"./.gdocker-runs.jsonl" when "local_run_log" is enabled in the configuration.
+	The output is provided in TSV format, or in JSONL with "--json".
+
+	Examples)
+	#> gdocker history
+	#> gdocker history --image samtools_x:1.17
+	#> gdocker history --cwd . --json`
+)
+
+func cmdHistory() *cli.Command {
+	return &cli.Command{
+		Name:               "history",
+		Usage:              "show provenance log of container runs",
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          ARGS_USAGE_HISTORY,
+		Description:        DESCRIPTION_HISTORY,
+		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
+		Flags: []cli.Flag{
+			FLAG_HISTORY_IMAGE,
+			FLAG_HISTORY_CWD,
+			FLAG_HISTORY_LOCAL,
+			FLAG_HISTORY_JSON,
+			FLAG_SHOW_ABSPATH,
+			FLAG_CONFIG_DEFAULT,
+			FLAG_VERBOSE,
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger("history", getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
+
+			config, _ := loadConfig(cmd)
+
+			file := getRunLogFile()
+			if cmd.Bool("local") {
+				file = filepath.Join(getWd(), RUN_LOG_LOCAL_FILE)
+			}
+			rrs, err := readRunRecords(file)
+			if errors.Is(err, fs.ErrNotExist) {
+				slog.Warn(fmt.Sprintf("no run log found at '%s'", anonymizeWd(file, config.ShowAbspath)))
+				return nil
+			} else if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+
+			var dir string
+			if cmd.IsSet("cwd") {
+				dir, err = filepath.Abs(cmd.String("cwd"))
+				if err != nil {
+					slog.Error(err.Error())
+					os.Exit(1)
+				}
+			}
+
+			var records [][]string
+			for _, rr := range rrs {
+				if !rr.matchImage(cmd.String("image")) || !rr.matchDir(dir) {
+					continue
+				}
+				if cmd.Bool("json") {
+					b, err := json.Marshal(rr)
+					if err != nil {
+						slog.Error(err.Error())
+						os.Exit(1)
+					}
+					fmt.Println(string(b))
+					continue
+				}
+				cwd := anonymizeWd(rr.Cwd, config.ShowAbspath)
+				records = append(records, []string{
+					rr.Timestamp.Format("2006-01-02 15:04:05"),
+					rr.Command,
+					rr.Image,
+					shortImageID(rr.ImageID),
+					strconv.Itoa(rr.ExitCode),
+					strconv.FormatFloat(rr.Duration, 'f', 1, 64),
+					cwd,
+					strings.Join(rr.Argv, " "),
+				})
+			}
+
+			if !cmd.Bool("json") {
+				writeCSV(
+					[]string{"Timestamp", "Command", "Image", "ImageID", "ExitCode", "Duration", "Cwd", "Argv"},
+					records,
+					os.Stdout,
+				)
+			}
+			return nil
+		},
+	}
+}
+
+// Return the short form (12 characters) of an image ID.
+func shortImageID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -5,12 +5,14 @@ import (
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/exec"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/urfave/cli/v3"
 )
@@ -37,6 +39,8 @@ var (
 	This command lists Docker images that have already been built, showing their
 	build status and associated directories. It supports filtering to display only
 	built images or those with a build directory. The output is provided in TSV format.
+	The "Root" column shows the image root directory (see "roots" in the configuration)
+	where the build directory was found.
 
 	Examples)
 	#> gdocker images --dir docker_images/arm
@@ -69,9 +73,8 @@ func cmdImages() *cli.Command {
 
 			config, _ := loadConfig(cmd)
 			docker_bin := config.DockerBin
-			dir := config.Dir
 
-			ibds := searchImageBuildDir(dir, "archive")
+			ibds := searchImageBuildDirs(config.imageRoots(), "archive")
 			ibds.makeMap()
 
 			iis := getImageInfo(docker_bin)
@@ -96,6 +99,10 @@ func cmdImages() *cli.Command {
 				}
 			}
 
+			for i := range records {
+				records[i] = append(records[i], ibds.RootOf(records[i][0]))
+			}
+
 			if cmd.Bool("built-only") {
 				var filtered [][]string
 				for _, record := range records {
@@ -125,7 +132,7 @@ func cmdImages() *cli.Command {
 			}
 
 			writeCSV(
-				[]string{"ImageName", "Built", "Exist", "Version", "BuildDir"},
+				[]string{"ImageName", "Built", "Exist", "Version", "BuildDir", "Root"},
 				records,
 				os.Stdout,
 			)
@@ -136,9 +143,12 @@ func cmdImages() *cli.Command {
 }
 
 type ImageInfo struct {
-	Hash   string            `json:"hash"`
-	Names  []string          `json:"name"`
-	Labels map[string]string `json:"label"`
+	Hash    string            `json:"hash"`
+	Names   []string          `json:"name"`
+	Digests []string          `json:"digest"`
+	Labels  map[string]string `json:"label"`
+	Created time.Time         `json:"created"`
+	Size    int64             `json:"size"`
 }
 
 func (ii *ImageInfo) ToRecord() [][]string {
@@ -164,6 +174,25 @@ func (ii *ImageInfo) buildDir() string {
 	return ""
 }
 
+// isForeignImage reports whether the image is not built by gdocker.
+// Images built by gdocker always have the "com.gdocker.version" label (it may be empty before v0.0.6),
+// so images without labels (e.g. not found locally) are foreign.
+func isForeignImage(labels map[string]string) bool {
+	_, ok := labels["com.gdocker.version"]
+	return !ok
+}
+
+// pullImage pulls the image. The progress of docker is written to stderr.
+func pullImage(docker_path string, image string) error {
+	c := exec.Command(docker_path, "pull", image)
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("could not pull image '%s': %w", image, err)
+	}
+	return nil
+}
+
 func getMapExistImageNames(iis []ImageInfo) map[string]int {
 	m := make(map[string]int)
 	for _, ii := range iis {
@@ -174,6 +203,19 @@ func getMapExistImageNames(iis []ImageInfo) map[string]int {
 	return m
 }
 
+const FORMAT_IMAGE_INFO = "{ \"hash\" : {{json .Id}},  \"name\" : {{json .RepoTags}}, \"digest\" : {{json .RepoDigests}}, \"label\" : {{json .Config.Labels}}, \"created\" : {{json .Created}}, \"size\" : {{json .Size}} }"
+
+// inspectImage returns the ImageInfo of a single image (name:tag or ID).
+func inspectImage(docker_path string, image string) (ImageInfo, error) {
+	var ii ImageInfo
+	out, err := exec.Command(docker_path, "image", "inspect", "--format", FORMAT_IMAGE_INFO, image).Output()
+	if err != nil {
+		return ii, fmt.Errorf("could not inspect image '%s': %w", image, err)
+	}
+	err = json.Unmarshal(bytes.TrimSpace(out), &ii)
+	return ii, err
+}
+
 func getImageInfo(docker_path string) []ImageInfo {
 	out, err := exec.Command(docker_path, "images", "--filter", "dangling=false", "--format", "{{.ID}}").Output()
 	if err != nil {
@@ -187,7 +229,7 @@ func getImageInfo(docker_path string) []ImageInfo {
 		return e == ""
 	})
 	images = slices.Compact(images)
-	out, err = exec.Command(docker_path, append([]string{"image", "inspect", "--format", "{ \"hash\" : {{json .Id}},  \"name\" : {{json .RepoTags}}, \"label\" : {{json .Config.Labels}} }"}, images...)...).Output()
+	out, err = exec.Command(docker_path, append([]string{"image", "inspect", "--format", FORMAT_IMAGE_INFO}, images...)...).Output()
 	if err != nil {
 		slog.Error(err.Error())
 		os.Exit(1)
@@ -213,11 +255,20 @@ type ExistImages map[string]struct{}
 
 // Get built docker images information
 func getExistImages(docker_path string) ExistImages {
-	out, err := exec.Command(docker_path, "images", "--format", "{{.Repository}}:{{.Tag}}").Output()
+	exists, err := queryExistImages(docker_path)
 	if err != nil {
 		slog.Error(err.Error())
 		os.Exit(1)
 	}
+	return exists
+}
+
+// queryExistImages returns the local images, or the error of docker.
+func queryExistImages(docker_path string) (ExistImages, error) {
+	out, err := exec.Command(docker_path, "images", "--format", "{{.Repository}}:{{.Tag}}").Output()
+	if err != nil {
+		return nil, err
+	}
 
 	var images []DockerImage
 	for _, v := range strings.Split(string(out), "\n") {
@@ -233,7 +284,7 @@ func getExistImages(docker_path string) ExistImages {
 	for _, eimg := range images {
 		exists[eimg.String()] = struct{}{}
 	}
-	return exists
+	return exists, nil
 }
 
 func (e ExistImages) checkExist(image DockerImage) bool {
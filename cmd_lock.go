@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/urfave/cli/v3"
+)
+
+var (
+	ARGS_USAGE_LOCK  = "[options] [image names...]"
+	DESCRIPTION_LOCK = `Pins the images used by a project in "gdocker.lock".
+	The lock file records the image ID (and repository digests if any) of each image,
+	so "run"/"wdrun" can refuse (or warn) when a local image was rebuilt or retagged.
+	The nearest "gdocker.lock" from the working directory is updated. If none is found,
+	a new one is created next to the project configuration (or in the working directory).
+	Without image names, the images already locked and the images run in the project
+	(according to "gdocker history") are locked.
+
+	Examples)
+	#> gdocker lock
+	#> gdocker lock samtools_x:1.17 ubuntu_a:22.04
+	#> gdocker lock verify`
+
+	ARGS_USAGE_LOCK_VERIFY  = "[options]"
+	DESCRIPTION_LOCK_VERIFY = `Checks all images in "gdocker.lock" against local images.
+	The output is provided in TSV format, and the exit status is 1
+	if any image is changed or missing.
+
+	Examples)
+	#> gdocker lock verify`
+)
+
+func cmdLock() *cli.Command {
+	return &cli.Command{
+		Name:               "lock",
+		Usage:              "pin image IDs used by the project",
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          ARGS_USAGE_LOCK,
+		Description:        DESCRIPTION_LOCK,
+		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
+		Flags: []cli.Flag{
+			FLAG_LIST,
+			FLAG_DOCKER_BIN,
+			FLAG_CONFIG_DEFAULT,
+			FLAG_VERBOSE,
+			FLAG_DRYRUN,
+		},
+		Commands: []*cli.Command{
+			cmdLockVerify(),
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger("lock", getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
+
+			config, _ := loadConfig(cmd)
+			docker_bin := config.DockerBin
+
+			file, exist := findLockFile(getWd())
+			if !exist {
+				// findProjectConfigFile returns a relative path when no project configuration is found
+				file, _ = filepath.Abs(filepath.Join(filepath.Dir(findProjectConfigFile(getWd())), LOCK_FILE))
+			}
+			lf, err := readLockFile(file)
+			if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+
+			var inputs []string
+			if cmd.NArg() > 0 || cmd.IsSet("list") {
+				ibds := searchImageBuildDirs(config.imageRoots(), "archive")
+				ibds.makeMap()
+				inputs = checkImageNamesInput(cmd, ibds) // load input image names from -l and args
+			} else {
+				for _, li := range lf.Images {
+					inputs = append(inputs, li.Image)
+				}
+				for _, image := range lockedImagesFromHistory(filepath.Dir(file)) {
+					if !slices.Contains(inputs, image) {
+						inputs = append(inputs, image)
+					}
+				}
+			}
+			if len(inputs) == 0 {
+				slog.Warn("no image to lock. please specify image names.")
+				return nil
+			}
+
+			var records [][]string
+			for _, input := range inputs {
+				li, err := lockImage(docker_bin, input)
+				if err != nil {
+					slog.Warn(fmt.Sprintf("%s. skipped.", err.Error()))
+					continue
+				}
+				change := "added"
+				if old, ok := lf.lookup(li.Image); ok {
+					change = "updated"
+					if old.ID == li.ID {
+						change = "unchanged"
+					}
+				}
+				lf.set(li)
+				records = append(records, []string{li.Image, shortImageID(li.ID), change})
+			}
+
+			if err := lf.write(cmd.Bool("dry-run")); err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+			if !cmd.Bool("dry-run") {
+				writeCSV([]string{"Image", "ImageID", "Change"}, records, os.Stdout)
+			}
+			return nil
+		},
+	}
+}
+
+func cmdLockVerify() *cli.Command {
+	return &cli.Command{
+		Name:               "verify",
+		Usage:              "check all images in the lock file",
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          ARGS_USAGE_LOCK_VERIFY,
+		Description:        DESCRIPTION_LOCK_VERIFY,
+		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
+		Flags: []cli.Flag{
+			FLAG_DOCKER_BIN,
+			FLAG_CONFIG_DEFAULT,
+			FLAG_VERBOSE,
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger("lock verify", getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
+
+			config, _ := loadConfig(cmd)
+
+			file, exist := findLockFile(getWd())
+			if !exist {
+				slog.Error(fmt.Sprintf("'%s' is not found", LOCK_FILE))
+				os.Exit(1)
+			}
+			lf, err := readLockFile(file)
+			if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+
+			ok := true
+			var records [][]string
+			for _, li := range lf.Images {
+				status, local := li.verify(config.DockerBin)
+				if status != LOCK_STATUS_OK {
+					ok = false
+				}
+				records = append(records, []string{li.Image, shortImageID(li.ID), shortImageID(local), status})
+			}
+			writeCSV([]string{"Image", "Locked", "Local", "Status"}, records, os.Stdout)
+
+			if !ok {
+				os.Exit(1)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+var (
+	// flags for ps, stop and rm commands
+	FLAG_ALL_CONTAINERS = &cli.BoolFlag{
+		Name:    "all",
+		Aliases: []string{"a"},
+		Value:   false,
+		Usage:   "select all containers started by gdocker",
+	}
+	FLAG_SELECT_CWD = &cli.StringFlag{
+		Name:    "cwd",
+		Aliases: []string{"c"},
+		Usage:   "select containers started in the directory `DIR` and its sub directories",
+	}
+	FLAG_SELECT_IMAGE = &cli.StringFlag{
+		Name:    "image",
+		Aliases: []string{"i"},
+		Usage:   "select containers of the image (`NAME` or NAME:TAG)",
+	}
+	FLAG_SELECT_COMMAND = &cli.StringFlag{
+		Name:  "command",
+		Usage: "select containers started by the gdocker command `CMD` (run, wdrun or shell)",
+	}
+	FLAG_SELECT_STATE = &cli.StringFlag{
+		Name:    "state",
+		Aliases: []string{"s"},
+		Usage:   "select containers in the `STATE` (e.g. running, exited)",
+	}
+	FLAG_FORCE = &cli.BoolFlag{
+		Name:    "force",
+		Aliases: []string{"f"},
+		Value:   false,
+		Usage:   "force the removal of running containers",
+	}
+)
+
+var (
+	ARGS_USAGE_PS  = "[options] [containers...]"
+	DESCRIPTION_PS = `Lists containers started by gdocker.
+	Containers started by "run", "wdrun" and "shell" are labeled with
+	"com.gdocker.run", so they can be listed apart from other containers on the host.
+	Containers can be selected by names/IDs and by the selector options.
+	The output is provided in TSV format.
+
+	Examples)
+	#> gdocker ps
+	#> gdocker ps --cwd . --state running`
+
+	ARGS_USAGE_STOP  = "[options] [containers...]"
+	DESCRIPTION_STOP = `Stops containers started by gdocker.
+	Containers are selected in the same way as "gdocker ps".
+	At least one container name or selector option is required.
+
+	Examples)
+	#> gdocker stop --cwd .
+	#> gdocker stop --image samtools_x -n`
+
+	ARGS_USAGE_RM  = "[options] [containers...]"
+	DESCRIPTION_RM = `Removes containers started by gdocker.
+	Containers are selected in the same way as "gdocker ps".
+	At least one container name or selector option is required.
+
+	Examples)
+	#> gdocker rm --state exited
+	#> gdocker rm --force --command shell`
+)
+
+func cmdPs() *cli.Command {
+	return &cli.Command{
+		Name:               "ps",
+		Usage:              "list containers started by gdocker",
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          ARGS_USAGE_PS,
+		Description:        DESCRIPTION_PS,
+		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
+		Flags: []cli.Flag{
+			FLAG_SELECT_CWD,
+			FLAG_SELECT_IMAGE,
+			FLAG_SELECT_COMMAND,
+			FLAG_SELECT_STATE,
+			FLAG_DOCKER_BIN,
+			FLAG_SHOW_ABSPATH,
+			FLAG_CONFIG_DEFAULT,
+			FLAG_VERBOSE,
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger("ps", getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
+
+			config, _ := loadConfig(cmd)
+
+			var records [][]string
+			for _, ci := range selectContainers(cmd, config.DockerBin) {
+				records = append(records, []string{
+					ci.ID,
+					ci.Name,
+					ci.Image,
+					ci.Command,
+					ci.State,
+					ci.Status,
+					ci.Age,
+					anonymizeWd(ci.Cwd, config.ShowAbspath),
+					anonymizeWd(ci.BuildDir, config.ShowAbspath),
+				})
+			}
+			writeCSV(
+				[]string{"ContainerID", "Name", "Image", "Command", "State", "Status", "Age", "Cwd", "BuildDir"},
+				records,
+				os.Stdout,
+			)
+			return nil
+		},
+	}
+}
+
+func cmdStop() *cli.Command {
+	return cmdContainerAction("stop", "stop containers started by gdocker", ARGS_USAGE_STOP, DESCRIPTION_STOP)
+}
+
+func cmdRm() *cli.Command {
+	return cmdContainerAction("rm", "remove containers started by gdocker", ARGS_USAGE_RM, DESCRIPTION_RM)
+}
+
+// cmdContainerAction returns a command which runs `docker {name}` for the selected containers.
+func cmdContainerAction(name, usage, args_usage, description string) *cli.Command {
+	flags := []cli.Flag{
+		FLAG_ALL_CONTAINERS,
+		FLAG_SELECT_CWD,
+		FLAG_SELECT_IMAGE,
+		FLAG_SELECT_COMMAND,
+		FLAG_SELECT_STATE,
+	}
+	if name == "rm" {
+		flags = append(flags, FLAG_FORCE)
+	}
+	flags = append(flags, FLAG_DOCKER_BIN, FLAG_CONFIG_DEFAULT, FLAG_VERBOSE, FLAG_DRYRUN)
+
+	return &cli.Command{
+		Name:               name,
+		Usage:              usage,
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          args_usage,
+		Description:        description,
+		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
+		Flags:              flags,
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger(name, getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
+
+			config, _ := loadConfig(cmd)
+
+			selected := cmd.NArg() > 0 || cmd.Bool("all")
+			for _, f := range []string{"cwd", "image", "command", "state"} {
+				selected = selected || cmd.IsSet(f)
+			}
+			if !selected {
+				slog.Error("please specify containers or selector options (use --all to select all).")
+				os.Exit(1)
+			}
+
+			cis := selectContainers(cmd, config.DockerBin)
+			if len(cis) == 0 {
+				slog.Warn("no container is selected.")
+				return nil
+			}
+
+			args := []string{name}
+			if cmd.Bool("force") {
+				args = append(args, "--force")
+			}
+			for _, ci := range cis {
+				args = append(args, ci.ID)
+			}
+			fmt.Println(config.DockerBin, strings.Join(args, " "))
+			if !cmd.Bool("dry-run") {
+				execCommand(getWd(), config.DockerBin, args)
+			}
+			return nil
+		},
+	}
+}
+
+// ContainerInfo represents a container started by gdocker.
+type ContainerInfo struct {
+	ID       string
+	Name     string
+	Image    string
+	Command  string
+	State    string
+	Status   string
+	Age      string
+	Cwd      string
+	BuildDir string
+}
+
+const FORMAT_CONTAINER_INFO = `{{.ID}}\t{{.Names}}\t{{.Image}}\t{{.Label "com.gdocker.run"}}\t{{.State}}\t{{.Status}}\t{{.RunningFor}}\t{{.Label "com.gdocker.run.cwd"}}\t{{.Label "com.gdocker.run.build-dir"}}`
+
+// getContainers returns all containers (including stopped ones) labeled by gdocker.
+func getContainers(docker_path string) []ContainerInfo {
+	out, err := exec.Command(docker_path, "ps", "-a", "--no-trunc", "--filter", "label=com.gdocker.run", "--format", FORMAT_CONTAINER_INFO).Output()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	var cis []ContainerInfo
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 9 {
+			continue
+		}
+		cis = append(cis, ContainerInfo{
+			ID:       shortImageID(fields[0]),
+			Name:     fields[1],
+			Image:    fields[2],
+			Command:  fields[3],
+			State:    fields[4],
+			Status:   fields[5],
+			Age:      fields[6],
+			Cwd:      fields[7],
+			BuildDir: fields[8],
+		})
+	}
+	return cis
+}
+
+// selectContainers returns gdocker containers matched to the arguments and the selector options.
+func selectContainers(cmd *cli.Command, docker_path string) []ContainerInfo {
+	var dir string
+	if cmd.IsSet("cwd") {
+		var err error
+		dir, err = filepath.Abs(cmd.String("cwd"))
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	var image DockerImage
+	if cmd.IsSet("image") {
+		var err error
+		image, err = NewDockerImage(cmd.String("image"))
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	names := cmd.Args().Slice()
+	var cis []ContainerInfo
+	for _, ci := range getContainers(docker_path) {
+		if len(names) > 0 && !slices.ContainsFunc(names, func(n string) bool {
+			return n == ci.Name || strings.HasPrefix(ci.ID, n)
+		}) {
+			continue
+		}
+		if dir != "" && !(&RunRecord{Cwd: ci.Cwd}).matchDir(dir) {
+			continue
+		}
+		if image.Name != "" {
+			img, _ := NewDockerImage(ci.Image)
+			if img.Name != image.Name || (strings.Contains(cmd.String("image"), ":") && img.Tag != image.Tag) {
+				continue
+			}
+		}
+		if cmd.IsSet("command") && ci.Command != cmd.String("command") {
+			continue
+		}
+		if cmd.IsSet("state") && ci.State != cmd.String("state") {
+			continue
+		}
+		cis = append(cis, ci)
+	}
+	return cis
+}
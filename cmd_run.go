@@ -27,14 +27,30 @@ var (
   - mount current working directory to /data (-v {PWD}:/data) (only "wdrun")
   - current User/Group ID (through "LOCAL_UID" and "LOCAL_GID")
   - disable showing startup message (through "ECHO_IDS")
-
-When you use "--verbose" or "--docker-bin" option, you have to write " --- " before
+  - run as the current user ("--user" and "HOME=/tmp") if the image is not built
+    by gdocker (i.e. the image has no "com.gdocker.version" label). the image is
+    pulled first if not found locally
+  - record the run into the run log (see "gdocker history")
+  - label the container with "com.gdocker.run" (see "gdocker ps")
+
+With "--hardened" (or "hardened" in the configuration), the container runs offline
+and unprivileged: "--network none", "--read-only" with a tmpfs on /tmp,
+"--cap-drop ALL" and "--security-opt no-new-privileges". The container starts as
+the current user, so the entrypoint of the image should support running without
+root (see the entrypoint.sh created by "gdocker dev init").
+
+If "gdocker.lock" is found in the working directory (or its parents) and the image
+does not match the locked image ID, the run is refused (see "gdocker lock").
+Set "--lock-mode warn" (or "lock_mode" in the configuration) to run with a warning.
+
+When you use "--verbose", "--docker-bin", "--hardened" or "--lock-mode" option, you have to write " --- " before
 arguments. If you pass the "-it" to arguments, you can use interactive mode.
 
 Examples)
 #> gdocker run ubuntu_a uname -a
 #> gdocker run --verbose 0 --- ubuntu_a uname -a
-#> gdocker wdrun -it ubuntu_a bash`
+#> gdocker wdrun -it ubuntu_a bash
+#> gdocker wdrun --hardened --- ubuntu_a make`
 )
 
 func cmdRun() *cli.Command {
@@ -44,39 +60,15 @@ func cmdRun() *cli.Command {
 		Usage:           "docker run with uid and gid",
 		Flags: []cli.Flag{
 			FLAG_DOCKER_BIN,
+			FLAG_HARDENED,
+			FLAG_LOCK_MODE,
 			FLAG_CONFIG_DEFAULT,
 			FLAG_VERBOSE,
 		},
 		ArgsUsage:   ARGS_USAGE_RUN,
 		Description: DESCRIPTION_RUN,
 
-		Action: func(ctx context.Context, cmd *cli.Command) error {
-			var ca cmdArgs
-			ca.wd.Skip = true
-
-			args, isHelp, config, lev := parseRunArgs(cmd.Args().Slice())
-			docker_path := config.DockerBin
-			if isHelp {
-				cli.HelpPrinter(os.Stdout, cli.SubcommandHelpTemplate, cmd)
-				return nil
-			}
-			cmdargs := ca.buildCmdArgs(args)
-			logger := getLogger("run", lev)
-			slog.SetDefault(logger)
-
-			if slices.Index(cmdargs, "-it") == -1 {
-				slog.Info(fmt.Sprintf("command is '%s %s'", docker_path, strings.Join(cmdargs, " ")))
-				execCommand(getWd(), docker_path, cmdargs)
-			} else {
-				subcmd := exec.Command(docker_path, cmdargs...)
-				err := startPty(subcmd)
-				if err != nil {
-					slog.Error(err.Error())
-					os.Exit(1)
-				}
-			}
-			return nil
-		},
+		Action: runAction("run", true),
 	}
 }
 
@@ -87,41 +79,83 @@ func cmdRunWorkingDirectory() *cli.Command {
 		Usage:           "docker run with uid, gid and working directory",
 		Flags: []cli.Flag{
 			FLAG_DOCKER_BIN,
+			FLAG_HARDENED,
+			FLAG_LOCK_MODE,
 			FLAG_CONFIG_DEFAULT,
 			FLAG_VERBOSE,
 		},
 		ArgsUsage:   ARGS_USAGE_RUN,
 		Description: DESCRIPTION_RUN,
 
-		Action: func(ctx context.Context, cmd *cli.Command) error {
-			var ca cmdArgs
+		Action: runAction("wdrun", false),
+	}
+}
 
-			args, isHelp, config, lev := parseRunArgs(cmd.Args().Slice())
-			docker_path := config.DockerBin
-			if isHelp {
-				cli.HelpPrinter(os.Stdout, cli.SubcommandHelpTemplate, cmd)
-				return nil
-			}
-			cmdargs := ca.buildCmdArgs(args)
-			logger := getLogger("wdrun", lev)
-			slog.SetDefault(logger)
-
-			if slices.Index(cmdargs, "-it") == -1 {
-				slog.Info(fmt.Sprintf("command is '%s %s'", docker_path, strings.Join(cmdargs, " ")))
-				execCommand(getWd(), docker_path, cmdargs)
-			} else {
-				subcmd := exec.Command(docker_path, cmdargs...)
-				err := startPty(subcmd)
-				if err != nil {
-					slog.Error(err.Error())
-					os.Exit(1)
-				}
-			}
+// runAction returns the action shared by `run` and `wdrun`.
+// If skip_wd is true, the working directory is not mounted.
+// Every run is recorded in the run log (see `gdocker history`).
+func runAction(name string, skip_wd bool) cli.ActionFunc {
+	return func(ctx context.Context, cmd *cli.Command) error {
+		var ca cmdArgs
+		ca.wd.Skip = skip_wd
+
+		args, isHelp, config, lev := parseRunArgs(cmd.Args().Slice())
+		docker_path := config.DockerBin
+		if isHelp {
+			cli.HelpPrinter(os.Stdout, cli.SubcommandHelpTemplate, cmd)
 			return nil
-		},
+		}
+		logger := getLogger(name, lev)
+		slog.SetDefault(logger)
+
+		rr := newRunRecord(name, docker_path, append([]string{"run"}, args...))
+		rr.Hardened = config.Hardened
+		checkLock(rr, config.lockMode())
+
+		// the image is pulled before the run to know whether it is built by gdocker
+		if rr.Image != "" && rr.ImageID == "" {
+			if err := pullImage(docker_path, rr.Image); err != nil {
+				slog.Warn(err.Error())
+			}
+			rr.resolveImage(docker_path)
+		}
+
+		ca.hardened = config.Hardened
+		ca.foreign = isForeignImage(rr.Labels)
+		if ca.foreign {
+			slog.Info(fmt.Sprintf("'%s' is not built by gdocker. run as the current user.", rr.Image))
+		}
+		cmdargs := ca.buildCmdArgs(args)
+		cmdargs = addRunLabels(cmdargs, name, rr.Labels["com.gdocker.build-dir"])
+		rr.Argv = append([]string{docker_path}, cmdargs...)
+		code := runDocker(docker_path, cmdargs)
+		rr.finish(docker_path, code)
+		rr.save(config.LocalRunLog)
+
+		if code != 0 {
+			slog.Error(fmt.Sprintf("exit status %d", code))
+			os.Exit(code)
+		}
+		return nil
 	}
 }
 
+// runDocker runs docker with the arguments and returns the exit code.
+// If "-it" is passed, the command is run with a pty.
+func runDocker(docker_path string, cmdargs []string) int {
+	if slices.Index(cmdargs, "-it") == -1 {
+		slog.Info(fmt.Sprintf("command is '%s %s'", docker_path, strings.Join(cmdargs, " ")))
+		return runCommand(getWd(), docker_path, cmdargs)
+	}
+	subcmd := exec.Command(docker_path, cmdargs...)
+	err := startPty(subcmd)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	return exitCode(subcmd.Wait())
+}
+
 func parseRunArgs(args []string) ([]string, bool, Config, slog.Level) {
 	lev := slog.LevelWarn
 
@@ -129,7 +163,7 @@ func parseRunArgs(args []string) ([]string, bool, Config, slog.Level) {
 		return []string{}, true, Config{}, lev
 	}
 
-	config, err := readConfig(searchConfigFiles(FLAG_CONFIG_DEFAULT.Value))
+	config, err := readRunConfig(FLAG_CONFIG_DEFAULT.Value)
 	if err != nil {
 		slog.Error(err.Error())
 		os.Exit(1)
@@ -155,7 +189,7 @@ func parseRunArgs(args []string) ([]string, bool, Config, slog.Level) {
 			}
 		}
 		if len(config_files) != 0 {
-			config, err = readConfig(searchConfigFiles(config_files))
+			config, err = readRunConfig(config_files)
 			if err != nil {
 				slog.Error(err.Error())
 				os.Exit(1)
@@ -167,11 +201,31 @@ func parseRunArgs(args []string) ([]string, bool, Config, slog.Level) {
 				config.updateDockerBin(gdargs[i_bin+1])
 			}
 		}
+
+		if slices.Contains(gdargs, "--hardened") {
+			config.updateHardened(true)
+		}
+
+		if i_lock := slices.Index(gdargs, "--lock-mode"); i_lock != -1 {
+			if i_lock+1 < len(gdargs) {
+				config.updateLockMode(gdargs[i_lock+1])
+			}
+		}
 		return args, false, config, lev
 	}
 	return args, false, config, lev
 }
 
+// readRunConfig reads and merges the configuration layers for run and wdrun.
+// Unlike other commands, at least one configuration file is required.
+func readRunConfig(files []string) (Config, error) {
+	config, read, err := readConfigLayers(files)
+	if err == nil && len(read) == 0 {
+		err = fmt.Errorf("no configuration file found in %v", files)
+	}
+	return config, err
+}
+
 // Working directoryのパスを返す
 func getWd() string {
 	wd, err := os.Getwd()
@@ -206,9 +260,13 @@ func (m *argMember) Set(v string) {
 
 // 自動設定したいdocker runのコマンドライン引数群を表す
 type cmdArgs struct {
+	rm  argMember
 	wd  argMember
 	uid argMember
 	gid argMember
+
+	hardened bool // offline and unprivileged mode
+	foreign  bool // the image does not have the gdocker entrypoint
 }
 
 // cmdArgsの設定すべき引数を自動設定し、コマンドライン引数の文字列のスライスを返す
@@ -225,7 +283,10 @@ func (ca *cmdArgs) buildCmdArgs(cmds []string) []string {
 		ca.gid.Set(fmt.Sprintf("LOCAL_GID=%s", gid))
 	}
 
-	args := []string{"run", "--rm"}
+	args := []string{"run"}
+	if !ca.rm.Skip {
+		args = append(args, "--rm")
+	}
 	if !ca.wd.Skip {
 		args = append(args, "-v", ca.wd.Value)
 	}
@@ -238,10 +299,55 @@ func (ca *cmdArgs) buildCmdArgs(cmds []string) []string {
 
 	args = append(args, []string{"-e", "ECHO_IDS=0"}...)
 
+	// offline and unprivileged mode.
+	// the container runs as the current user from the start, so that the entrypoint
+	// does not need to modify /etc nor switch the user with gosu.
+	if ca.hardened {
+		args = append(args,
+			"--network", "none",
+			"--read-only",
+			"--tmpfs", "/tmp:rw,exec,mode=1777",
+			"--cap-drop", "ALL",
+			"--security-opt", "no-new-privileges",
+			"--label", "com.gdocker.run.hardened=true",
+		)
+	}
+
+	// run as the current user with a writable HOME, because LOCAL_UID and LOCAL_GID are
+	// only used by the gdocker entrypoint. skipped when the user is specified explicitly.
+	if (ca.hardened || ca.foreign) && !hasUserArg(cmds) {
+		args = append(args, "--user", fmt.Sprintf("%s:%s", uid, gid), "-e", "HOME=/tmp")
+	}
+
 	args = append(args, cmds...)
 	return args
 }
 
+// hasUserArg reports whether the user of the container is specified in the docker run arguments.
+func hasUserArg(args []string) bool {
+	end := len(args)
+	if i, ok := findImageArg(append([]string{"run"}, args...)); ok {
+		end = i - 1
+	}
+	return slices.ContainsFunc(args[:end], func(arg string) bool {
+		return arg == "-u" || arg == "--user" || strings.HasPrefix(arg, "--user=")
+	})
+}
+
+// addRunLabels inserts labels to identify containers started by gdocker into the docker run arguments.
+// The arguments should start with "run".
+func addRunLabels(args []string, name string, build_dir string) []string {
+	labels := []string{
+		"--label", fmt.Sprintf("com.gdocker.run=%s", name),
+		"--label", fmt.Sprintf("com.gdocker.run.cwd=%s", getWd()),
+		"--label", fmt.Sprintf("com.gdocker.run.command=%s", strings.Join(append([]string{APP_NAME}, os.Args[1:]...), " ")),
+	}
+	if build_dir != "" {
+		labels = append(labels, "--label", fmt.Sprintf("com.gdocker.run.build-dir=%s", build_dir))
+	}
+	return slices.Insert(args, 1, labels...)
+}
+
 func startPty(cmd *exec.Cmd) error {
 	// Start the command with a pty.
 	ptmx, err := pty.Start(cmd)
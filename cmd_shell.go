@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	SHELL_CONTAINER_PREFIX = "gdocker-shell-"
+	SHELL_VOLUME_PREFIX    = "gdocker-home-"
+	SHELL_HOME             = "/home/user"
+
+	// wait until the gdocker entrypoint creates the user
+	SHELL_WAIT_USER = `for i in $(seq 100); do id -u user >/dev/null 2>&1 && [ -s %[1]s/.bashrc ] && break; sleep 0.1; done; `
+	// give the home volume to the user
+	SHELL_SETUP_HOME = `chown -R %[2]s:%[3]s %[1]s`
+)
+
+var (
+	// flags for shell command
+	FLAG_SHELL_NAME = &cli.StringFlag{
+		Name:  "name",
+		Usage: "`NAME` of the persistent container (default: the image name)",
+	}
+	FLAG_SHELL_LIST = &cli.BoolFlag{
+		Name:    "list",
+		Aliases: []string{"l"},
+		Value:   false,
+		Usage:   "list persistent containers",
+	}
+	FLAG_SHELL_STOP = &cli.BoolFlag{
+		Name:  "stop",
+		Value: false,
+		Usage: "stop the persistent container specified by --name",
+	}
+)
+
+var (
+	ARGS_USAGE_SHELL  = "[options] <image> [-- command...]"
+	DESCRIPTION_SHELL = `Starts or reuses a long-lived container and executes a shell in it.
+	Unlike "run"/"wdrun", the container is not removed on exit, so shell history
+	and installed scratch tools are kept. The container is started with the same
+	conventions as "wdrun" (User/Group ID and working directory on /data), and
+	the home directory (/home/user) is kept in the docker volume "gdocker-home-{NAME}".
+	The working directory is mounted when the container is created.
+
+	Examples)
+	#> gdocker shell --name foo ubuntu_a
+	#> gdocker shell --name foo ubuntu_a -- python3
+	#> gdocker shell --list
+	#> gdocker shell --stop --name foo`
+)
+
+func cmdShell() *cli.Command {
+	return &cli.Command{
+		Name:               "shell",
+		Usage:              "exec a shell in a persistent container",
+		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
+		ArgsUsage:          ARGS_USAGE_SHELL,
+		Description:        DESCRIPTION_SHELL,
+		Before:             setSubCommandHelpTemplate(TMPL_SUBCOMMAND_HELP),
+		Flags: []cli.Flag{
+			FLAG_SHELL_NAME,
+			FLAG_SHELL_LIST,
+			FLAG_SHELL_STOP,
+			FLAG_DOCKER_BIN,
+			FLAG_CONFIG_DEFAULT,
+			FLAG_VERBOSE,
+		},
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			logger := getLogger("shell", getLogLevel(cmd.Int64("verbose")))
+			slog.SetDefault(logger)
+
+			config, _ := loadConfig(cmd)
+			docker_bin := config.DockerBin
+
+			if cmd.Bool("list") {
+				execCommand(getWd(), docker_bin, []string{
+					"ps", "-a",
+					"--filter", "label=com.gdocker.shell",
+					"--format", `table {{.Label "com.gdocker.shell"}}\t{{.Names}}\t{{.Image}}\t{{.Status}}\t{{.Label "com.gdocker.shell.cwd"}}`,
+				})
+				return nil
+			}
+
+			name := cmd.String("name")
+			if cmd.Bool("stop") {
+				if name == "" {
+					slog.Error("please specify the container by --name")
+					os.Exit(1)
+				}
+				execCommand(getWd(), docker_bin, []string{"stop", SHELL_CONTAINER_PREFIX + name})
+				return nil
+			}
+
+			if cmd.NArg() == 0 {
+				slog.Error("please specify image name.")
+				os.Exit(1)
+			}
+			image := cmd.Args().First()
+			command := cmd.Args().Tail()
+			if len(command) == 0 {
+				command = []string{"bash"}
+			}
+			if name == "" {
+				name = shellName(image)
+			}
+			container := SHELL_CONTAINER_PREFIX + name
+
+			state, cimage, cwd, exist := inspectShellContainer(docker_bin, container)
+			switch {
+			case !exist:
+				slog.Info(fmt.Sprintf("create container '%s' from '%s'", container, image))
+				var build_dir string
+				var ca cmdArgs
+				ca.rm.Skip = true
+				ii, err := inspectImage(docker_bin, image)
+				if err != nil {
+					// the image is pulled before the run to know whether it is built by gdocker
+					if err := pullImage(docker_bin, image); err != nil {
+						slog.Warn(err.Error())
+					}
+					ii, _ = inspectImage(docker_bin, image)
+				}
+				build_dir = ii.buildDir()
+				ca.foreign = isForeignImage(ii.Labels)
+				args := ca.buildCmdArgs([]string{
+					"-d",
+					"--name", container,
+					"--label", fmt.Sprintf("com.gdocker.shell=%s", name),
+					"--label", fmt.Sprintf("com.gdocker.shell.cwd=%s", getWd()),
+					"-v", fmt.Sprintf("%s%s:%s", SHELL_VOLUME_PREFIX, name, SHELL_HOME),
+					image, "sleep", "infinity",
+				})
+				args = addRunLabels(args, "shell", build_dir)
+				slog.Info(fmt.Sprintf("command is '%s %s'", docker_bin, strings.Join(args, " ")))
+				execCommand(getWd(), docker_bin, args)
+
+				uid, gid := getIds()
+				setup := SHELL_SETUP_HOME
+				if !ca.foreign {
+					setup = SHELL_WAIT_USER + setup
+				}
+				execCommand(getWd(), docker_bin, []string{
+					"exec", "-u", "0", container, "sh", "-c", fmt.Sprintf(setup, SHELL_HOME, uid, gid),
+				})
+			case state != "running":
+				slog.Info(fmt.Sprintf("start container '%s'", container))
+				execCommand(getWd(), docker_bin, []string{"start", container})
+			}
+			if exist {
+				if cimage != image {
+					slog.Warn(fmt.Sprintf("container '%s' was created from '%s', not '%s'", container, cimage, image))
+				}
+				if cwd != getWd() {
+					slog.Warn(fmt.Sprintf("/data is mounted from '%s'", anonymizeWd(cwd, config.ShowAbspath)))
+				}
+			}
+
+			uid, gid := getIds()
+			args := []string{
+				"exec", "-it",
+				"-u", fmt.Sprintf("%s:%s", uid, gid),
+				"-e", fmt.Sprintf("HOME=%s", SHELL_HOME),
+				"-w", "/data",
+				container,
+			}
+			args = append(args, command...)
+			slog.Info(fmt.Sprintf("command is '%s %s'", docker_bin, strings.Join(args, " ")))
+			subcmd := exec.Command(docker_bin, args...)
+			if err := startPty(subcmd); err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+			if code := exitCode(subcmd.Wait()); code != 0 {
+				os.Exit(code)
+			}
+			return nil
+		},
+	}
+}
+
+// Return a container name derived from the image name (e.g. samtools_x:1.17 -> samtools_x-1.17)
+func shellName(image string) string {
+	return regexp.MustCompile(`[^a-zA-Z0-9_.-]`).ReplaceAllString(image, "-")
+}
+
+// inspectShellContainer returns the state, the image and the mounted working directory of the container.
+// exist is false when the container is not found.
+func inspectShellContainer(docker_path string, container string) (state, image, cwd string, exist bool) {
+	out, err := exec.Command(
+		docker_path, "container", "inspect",
+		"--format", `{{.State.Status}};{{.Config.Image}};{{index .Config.Labels "com.gdocker.shell.cwd"}}`,
+		container,
+	).Output()
+	if err != nil {
+		return "", "", "", false
+	}
+	fields := strings.SplitN(strings.TrimSpace(string(out)), ";", 3)
+	if len(fields) != 3 {
+		return "", "", "", false
+	}
+	return fields[0], fields[1], fields[2], true
+}
@@ -1,17 +1,41 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"html/template"
-	"log"
 	"log/slog"
-	"net/http"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/urfave/cli/v3"
 )
 
+var (
+	// flag for showdeps command
+	FLAG_SHOWDEPS_FORMAT = &cli.StringFlag{
+		Name:    "format",
+		Aliases: []string{"f"},
+		Value:   FORMAT_MERMAID,
+		Usage:   "output `FORMAT` (mermaid, dot, plantuml, json or yaml)",
+	}
+	FLAG_SHOWDEPS_ADDR = &cli.StringFlag{
+		Name:  "addr",
+		Value: "localhost:8080",
+		Usage: "`ADDRESS` (host:port) of the web page served by --web",
+	}
+	FLAG_SHOWDEPS_OUT = &cli.StringFlag{
+		Name:  "out",
+		Usage: "write a standalone HTML page of the graph to `FILE`",
+	}
+	FLAG_MISSING_ONLY = &cli.BoolFlag{
+		Name:  "missing-only",
+		Usage: "show only images which are not built (or pulled) yet, and the images they depend on",
+		Value: false,
+	}
+)
+
 var (
 	ARGS_USAGE_SHOWDEPS  = "[options] [image names...]"
 	DESCRIPTION_SHOWDEPS = `Checks and shows dependencies between images.
@@ -21,41 +45,52 @@ var (
 
 	Examples)
 	#> gdocker showdeps
-	#> gdocker showdeps --gfm`
+	#> gdocker showdeps --gfm
+	#> gdocker showdeps --format dot | dot -Tsvg > deps.svg
+	#> gdocker showdeps --format json
+
+	Other than mermaid, the graph is also written in Graphviz DOT, PlantUML,
+	or a JSON/YAML document of nodes and edges. Nodes carry the class
+	(root, latest, latest-image or old), the build status and the build directory.
+
+	"--web" serves the graph at "--addr" without internet access (the graph is drawn
+	as SVG by gdocker, in the same styles as the Mermaid flowchart, which is also shown
+	in the page). The page is updated when Dockerfiles or Makefiles in the
+	image roots are changed. "--out" writes the graph as a standalone HTML page.
+
+	#> gdocker showdeps --web --addr 0.0.0.0:8000 -a
+	#> gdocker showdeps --out deps.html -a
+
+	When several image roots are configured, each image is annotated
+	with the name of the root it came from (e.g. "samtools_x:1.17 @shared").
+
+	Each image has a build status, queried from local images: built or not-built
+	for images with a build directory, and external-present or external-missing
+	for external base images (e.g. "ubuntu:22.04" has been pulled or not).
+	In the Mermaid flowchart, they are the classes built, notbuilt, extpresent and extmissing.
+	The build status is left out if docker is not available, except for
+	"--missing-only", which collapses the graph to the images which still need work.
+
+	#> gdocker showdeps -a --missing-only`
 )
 
 var (
 	TMPL_MERMAID = `{{< if .GFM >}}` + "```mermaid" + `{{< end >}}
 flowchart TD
-
-    classDef root fill:#8BA7D5,color:#000000
-    classDef latest fill:#E38692,color:#000000
-    classDef latestimg fill:#F6D580,color:#000000
-    classDef old fill:#81D674,color:#000000
+{{< range .ClassDefs >}}
+    {{< . >}}{{< end >}}
 {{< range .Deps >}}
     {{< . >}}{{< end >}}
+{{< range .Classes >}}
+    {{< . >}}{{< end >}}
 {{< if .GFM >}}` + "```" + `{{< end >}}
-`
-	TMPL_MERMAID_WEB = `
-<script type="module">import mermaid from 'https://cdn.jsdelivr.net/npm/mermaid@11/dist/mermaid.esm.min.mjs'</script>
-
-<pre class="mermaid">
-	flowchart TD
-
-    classDef root fill:#8BA7D5,color:#000000
-    classDef latest fill:#E38692,color:#000000
-    classDef latestimg fill:#F6D580,color:#000000
-    classDef old fill:#81D674,color:#000000
-	{{ range . }}
-    {{ . }}{{ end }}
-</pre>
 `
 )
 
 func cmdShowDeps() *cli.Command {
 	return &cli.Command{
 		Name:               "showdeps",
-		Usage:              "show docker image dependencies as mermaid flowchart",
+		Usage:              "show docker image dependencies as mermaid flowchart (or other formats)",
 		CustomHelpTemplate: TMPL_SUBCOMMAND_HELP,
 		ArgsUsage:          ARGS_USAGE_SHOWDEPS,
 		Description:        DESCRIPTION_SHOWDEPS,
@@ -65,8 +100,13 @@ func cmdShowDeps() *cli.Command {
 			FLAG_LIST,
 			FLAG_ALL,
 			FLAG_ALL_LATEST,
+			FLAG_SHOWDEPS_FORMAT,
 			FLAG_GFM,
 			FLAG_WEB,
+			FLAG_SHOWDEPS_ADDR,
+			FLAG_SHOWDEPS_OUT,
+			FLAG_MISSING_ONLY,
+			FLAG_DOCKER_BIN,
 			FLAG_CONFIG_DEFAULT,
 			FLAG_VERBOSE,
 		},
@@ -75,62 +115,155 @@ func cmdShowDeps() *cli.Command {
 			slog.SetDefault(logger)
 
 			config, _ := loadConfig(cmd)
-			dir := config.Dir
 
-			ibds := searchImageBuildDir(dir, "archive")
-			ibds.makeMap()
-			deps := ibds.Dependencies()
-
-			inputs := checkImageNamesInput(cmd, ibds) // load input image names from -l and args
+			format := cmd.String("format")
+			if !slices.Contains(SHOWDEPS_FORMATS, format) {
+				slog.Error(fmt.Sprintf("unknown format '%s' (one of %s)", format, strings.Join(SHOWDEPS_FORMATS, ", ")))
+				os.Exit(1)
+			}
 
-			var images []DockerImage
-			for _, input := range inputs {
-				img, err := NewDockerImage(input)
+			solve := func() ([]Dependency, ImageBuildDirs, ExistImages, error) {
+				deps_sub, ibds, err := solveShowDeps(cmd, config)
 				if err != nil {
-					slog.Error(err.Error())
-					os.Exit(1)
+					return nil, ibds, nil, err
 				}
-				if _, ok := ibds.mapNameTag[img.String()]; !ok {
-					slog.Warn(fmt.Sprintf("%v is not found. skipped.", img))
-					continue
+				eimages, err := showDepsExistImages(cmd, config)
+				if err != nil {
+					return nil, ibds, nil, err
 				}
-				images = append(images, img)
+				if cmd.Bool("missing-only") {
+					deps_sub = filterMissingOnly(deps_sub, ibds, eimages)
+				}
+				return deps_sub, ibds, eimages, nil
+			}
+			deps_sub, ibds, eimages, err := solve()
+			if err != nil {
+				logDependencyError(err)
+				os.Exit(1)
 			}
-			solved, roots := checkDependency(images, deps)
-
-			var deps_sub []Dependency
-			for _, img := range solved {
-				for _, dep := range deps {
-					if img.String() == dep.From.String() {
-						if _, ok := roots[dep.To.String()]; ok {
-							dep.To.IsRoot = true
-						}
-						deps_sub = append(deps_sub, dep)
-					}
+			// the graph is reloaded by --web, where errors are shown instead of exiting
+			load := func() (DepGraph, error) {
+				deps_sub, ibds, eimages, err := solve()
+				if err != nil {
+					return DepGraph{}, err
 				}
+				return newDepGraph(deps_sub, ibds, eimages), nil
 			}
 
-			type tmplData struct {
-				GFM  bool
-				Deps []Dependency
+			if cmd.IsSet("out") {
+				if err := writeShowDepsPage(cmd.String("out"), newDepGraph(deps_sub, ibds, eimages)); err != nil {
+					slog.Error(err.Error())
+					os.Exit(1)
+				}
+				slog.Info(fmt.Sprintf("write '%s'", cmd.String("out")))
+				if !cmd.Bool("web") {
+					return nil
+				}
 			}
 
-			tmpl := NewTemplates(TMPL_MERMAID, tmplData{
-				cmd.Bool("gfm"),
-				deps_sub,
-			})
-			tmpl.writeTemplates("stdout", false)
+			if format != FORMAT_MERMAID {
+				g := newDepGraph(deps_sub, ibds, eimages)
+				if err := writeDepGraph(os.Stdout, g, format); err != nil {
+					slog.Error(err.Error())
+					os.Exit(1)
+				}
+			} else {
+				os.Stdout.WriteString(mermaidFlowchart(deps_sub, ibds, eimages, cmd.Bool("gfm")))
+			}
 
 			if cmd.Bool("web") {
-				http.HandleFunc("/", viewHandler(deps_sub))
-				slog.Warn("Server started url http://localhost:8080/")
-				log.Fatal(http.ListenAndServe("localhost:8080", nil))
+				s := newDepGraphServer(load, config.imageRoots())
+				if err := s.serve(cmd.String("addr"), s.handler()); err != nil {
+					slog.Error(err.Error())
+					os.Exit(1)
+				}
 			}
 			return nil
 		},
 	}
 }
 
+// mermaidFlowchart returns the dependencies as the Mermaid flowchart (fenced for GFM if gfm is true).
+func mermaidFlowchart(deps []Dependency, ibds ImageBuildDirs, eimages ExistImages, gfm bool) string {
+	var buf bytes.Buffer
+	appendBuffer(&buf, TMPL_MERMAID, struct {
+		GFM       bool
+		ClassDefs []string
+		Deps      []Dependency
+		Classes   []string
+	}{gfm, mermaidClassDefs(), deps, mermaidStatusClasses(deps, ibds, eimages)})
+	return buf.String()
+}
+
+// showDepsExistImages returns the local images for the build status. The graph is written
+// from Dockerfiles only, so the status is left out (nil) if docker is not available,
+// except for --missing-only which needs it.
+func showDepsExistImages(cmd *cli.Command, config Config) (ExistImages, error) {
+	eimages, err := queryExistImages(config.DockerBin)
+	if err != nil {
+		if cmd.Bool("missing-only") {
+			return nil, fmt.Errorf("--missing-only needs the local images: %w", err)
+		}
+		slog.Warn(fmt.Sprintf("the build status is not shown (%s: %s)", config.DockerBin, err))
+		return nil, nil
+	}
+	return eimages, nil
+}
+
+// solveShowDeps returns the dependencies of the images specified by the arguments,
+// and the image build directories searched.
+func solveShowDeps(cmd *cli.Command, config Config) ([]Dependency, ImageBuildDirs, error) {
+	ibds := searchImageBuildDirs(config.imageRoots(), "archive")
+	ibds.makeMap()
+
+	inputs := checkImageNamesInput(cmd, ibds) // load input image names from -l and args
+	deps, err := solveDeps(config, ibds, inputs)
+	return deps, ibds, err
+}
+
+// solveDeps returns the dependencies of the images (name:tag) in the image build directories.
+// The error is returned when an image name is invalid or the dependencies have cycles.
+func solveDeps(config Config, ibds ImageBuildDirs, inputs []string) ([]Dependency, error) {
+	deps := ibds.Dependencies()
+
+	var images []DockerImage
+	for _, input := range inputs {
+		img, err := NewDockerImage(input)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := ibds.mapNameTag[img.String()]; !ok {
+			slog.Warn(fmt.Sprintf("%v is not found. skipped.", img))
+			continue
+		}
+		images = append(images, img)
+	}
+	solved, roots, err := checkDependency(images, deps)
+	if err != nil {
+		return nil, err
+	}
+
+	// annotate images with the image root directory only when several roots are used
+	annotate := len(config.imageRoots()) > 1
+
+	var deps_sub []Dependency
+	for _, img := range solved {
+		for _, dep := range deps {
+			if img.String() == dep.From.String() {
+				if _, ok := roots[dep.To.String()]; ok {
+					dep.To.IsRoot = true
+				}
+				if annotate {
+					dep.From.Root = ibds.RootOf(dep.From.String())
+					dep.To.Root = ibds.RootOf(dep.To.String())
+				}
+				deps_sub = append(deps_sub, dep)
+			}
+		}
+	}
+	return deps_sub, nil
+}
+
 func (dep Dependency) String() string {
 	if dep.From.Tag == "latest" {
 		dep.To.IsLatest = true
@@ -139,30 +272,18 @@ func (dep Dependency) String() string {
 }
 
 func printNode(di DockerImage) string {
+	label := di.String()
+	if di.Root != "" {
+		label = fmt.Sprintf("%s @%s", label, di.Root)
+	}
 	if di.IsRoot {
-		return fmt.Sprintf(`%s[["%s [root]"]]:::root`, di.String(), di.String())
+		return fmt.Sprintf(`%s[["%s [root]"]]:::root`, di.String(), label)
 	}
 	if di.Tag == "latest" {
-		return fmt.Sprintf(`%s("%s"):::latest`, di.String(), di.String())
+		return fmt.Sprintf(`%s("%s"):::latest`, di.String(), label)
 	}
 	if di.IsLatest {
-		return fmt.Sprintf(`%s("%s"):::latestimg`, di.String(), di.String())
-	}
-	return fmt.Sprintf(`%s("%s"):::old`, di.String(), di.String())
-}
-
-func viewHandler(deps any) func(w http.ResponseWriter, r *http.Request) {
-	f := func(w http.ResponseWriter, r *http.Request) {
-		tmpl, err := template.New("mermaid").Parse(TMPL_MERMAID_WEB)
-		if err != nil {
-			http.Error(w, "template parse error", http.StatusInternalServerError)
-			return
-		}
-
-		if err := tmpl.Execute(w, deps); err != nil {
-			http.Error(w, "template execute error", http.StatusInternalServerError)
-			return
-		}
+		return fmt.Sprintf(`%s("%s"):::latestimg`, di.String(), label)
 	}
-	return f
+	return fmt.Sprintf(`%s("%s"):::old`, di.String(), label)
 }
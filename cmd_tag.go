@@ -53,7 +53,6 @@ func cmdTag() *cli.Command {
 
 			config, _ := loadConfig(cmd)
 			docker_bin := config.DockerBin
-			dir := config.Dir
 			projtag := config.ProjectTag
 
 			if projtag == "latest" {
@@ -61,7 +60,7 @@ func cmdTag() *cli.Command {
 				return nil
 			}
 
-			ibds := searchImageBuildDir(dir, "archive")
+			ibds := searchImageBuildDirs(config.imageRoots(), "archive")
 			ibds.makeMap()
 
 			// load input image names from -l and args
@@ -2,12 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/urfave/cli/v3"
@@ -15,13 +19,210 @@ import (
 
 // Config holds the configuration for the gdocker.
 // It contains the path to the Docker binary and the directory where Docker images are stored.
+// The configuration is merged field by field from layers (see loadConfig).
+// The `flag` tag is the name of the command line flag to overwrite the field,
+// the `conf:"path"` tag marks paths which are relative to the configuration file,
+// the `conf:"meta"` tag marks fields which are not configuration values,
+// and each field can also be overwritten by the environment variable GDOCKER_{JSON KEY}.
 type Config struct {
-	DockerBin   string `json:"docker_bin"`
-	Dir         string `json:"dir"`
-	DefaultArch string `json:"arch"`
-	StockDir    string `json:"stock_dir,omitempty"` // Optional field for stock directory
-	ShowAbspath bool   `json:"show_abspath,omitempty"`
-	ProjectTag  string `json:"project_tag,omitempty"`
+	DockerBin   string   `json:"docker_bin,omitempty" flag:"docker-bin"`
+	Dir         string   `json:"dir,omitempty" flag:"dir" conf:"path"`
+	DefaultArch string   `json:"arch,omitempty" flag:"arch"`
+	StockDir    string   `json:"stock_dir,omitempty" flag:"stock" conf:"path"` // Optional field for stock directory
+	ShowAbspath bool     `json:"show_abspath,omitempty" flag:"show-abspath"`
+	ProjectTag  string   `json:"project_tag,omitempty" flag:"proj-tag"`
+	LocalRunLog bool     `json:"local_run_log,omitempty" flag:"local-run-log"` // also write run logs into the working directory
+	Hardened    bool     `json:"hardened,omitempty" flag:"hardened"`           // run containers offline and unprivileged
+	LockMode    string   `json:"lock_mode,omitempty" flag:"lock-mode"`         // what run/wdrun do when an image does not match the lock file
+	LintDisable []string `json:"lint_disable,omitempty"`                       // rules of `gdocker dev lint` to skip (IDs or names)
+	RecipeDir   string   `json:"recipe_dir,omitempty" conf:"path"`             // directory of recipes for `gdocker dev new`
+
+	Roots []ImageRoot `json:"roots,omitempty" conf:"path"` // additional image root directories (see imageRoots)
+
+	SchemaVersion int `json:"schema_version,omitempty" conf:"meta"` // version of the file format
+
+	origins map[string]string // which layer supplied each field
+	files   []string          // configuration files merged
+}
+
+// ImageRoot is a directory to search image build directories.
+// Name is shown in outputs (default: the base name of Dir), and
+// a root with Arch is used only when it matches the default architecture.
+type ImageRoot struct {
+	Name string `json:"name,omitempty"`
+	Dir  string `json:"dir"`
+	Arch string `json:"arch,omitempty"`
+}
+
+const (
+	ORIGIN_DEFAULT = "default"
+	ENV_PREFIX     = "GDOCKER_"
+)
+
+// configField represents a field of Config.
+type configField struct {
+	Key   string // JSON key
+	Flag  string // command line flag name
+	Env   string // environment variable name
+	Path  bool   // relative path is resolved against the configuration file
+	Kind  reflect.Kind
+	index int
+}
+
+// configFields returns the fields of Config in the order of declaration.
+func configFields() []configField {
+	var cfs []configField
+	t := reflect.TypeFor[Config]()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() || f.Tag.Get("conf") == "meta" {
+			continue
+		}
+		key, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		cfs = append(cfs, configField{
+			Key:   key,
+			Flag:  f.Tag.Get("flag"),
+			Env:   ENV_PREFIX + strings.ToUpper(key),
+			Path:  f.Tag.Get("conf") == "path",
+			Kind:  f.Type.Kind(),
+			index: i,
+		})
+	}
+	return cfs
+}
+
+func (c *Config) value(cf configField) reflect.Value {
+	return reflect.ValueOf(c).Elem().Field(cf.index)
+}
+
+// setString sets the field from a string (e.g. a value of an environment variable).
+func (c *Config) setString(cf configField, s string) error {
+	switch cf.Kind {
+	case reflect.String:
+		c.value(cf).SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid value for `%s`: '%s'", cf.Key, s)
+		}
+		c.value(cf).SetBool(b)
+	default:
+		// other types are given as JSON (e.g. `[{"dir": "..."}]` for roots)
+		if err := json.Unmarshal([]byte(s), c.value(cf).Addr().Interface()); err != nil {
+			return fmt.Errorf("invalid value for `%s`: '%s' (%w)", cf.Key, s, err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) setOrigin(key string, origin string) {
+	if c.origins == nil {
+		c.origins = make(map[string]string)
+	}
+	c.origins[key] = origin
+}
+
+// Origin returns which layer supplied the field specified by the JSON key.
+func (c *Config) Origin(key string) string {
+	if origin, ok := c.origins[key]; ok {
+		return origin
+	}
+	return ORIGIN_DEFAULT
+}
+
+// mergeLayer overwrites the fields listed in keys with the values of the layer.
+func (c *Config) mergeLayer(layer Config, keys []string, origin string) {
+	for _, cf := range configFields() {
+		if !slices.Contains(keys, cf.Key) {
+			continue
+		}
+		old, new := c.value(cf), layer.value(cf)
+		if !reflect.DeepEqual(old.Interface(), new.Interface()) {
+			slog.Info(fmt.Sprintf("overwrite `%s`: '%v' with '%v' (%s)", cf.Key, old.Interface(), new.Interface(), origin))
+		}
+		old.Set(new)
+		c.setOrigin(cf.Key, origin)
+	}
+}
+
+// resolvePaths makes relative paths absolute against the directory (of the configuration file).
+func (c *Config) resolvePaths(dir string) {
+	abs := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+	for _, cf := range configFields() {
+		if cf.Path && cf.Kind == reflect.String {
+			v := c.value(cf)
+			v.SetString(abs(v.String()))
+		}
+	}
+	roots := make([]ImageRoot, len(c.Roots))
+	for i, root := range c.Roots {
+		root.Dir = abs(root.Dir)
+		roots[i] = root
+	}
+	if c.Roots != nil {
+		c.Roots = roots
+	}
+}
+
+// imageRoots returns the image root directories in order of precedence:
+// `dir` first, and then `roots` in the listed order.
+// Roots for other architectures are skipped.
+func (c *Config) imageRoots() []ImageRoot {
+	var roots []ImageRoot
+	if c.Dir != "" {
+		roots = append(roots, ImageRoot{Dir: c.Dir})
+	}
+	for _, root := range c.Roots {
+		if root.Arch != "" && root.Arch != c.DefaultArch {
+			slog.Debug(fmt.Sprintf("skip root '%s' for arch '%s'", root.Dir, root.Arch))
+			continue
+		}
+		roots = append(roots, root)
+	}
+	for i := range roots {
+		if roots[i].Name == "" {
+			roots[i].Name = filepath.Base(roots[i].Dir)
+		}
+	}
+	return roots
+}
+
+// mergeEnv overwrites the fields with GDOCKER_* environment variables.
+func (c *Config) mergeEnv() {
+	for _, cf := range configFields() {
+		v, ok := os.LookupEnv(cf.Env)
+		if !ok {
+			continue
+		}
+		var layer Config
+		if err := layer.setString(cf, v); err != nil {
+			slog.Warn(fmt.Sprintf("%s (%s)", err.Error(), cf.Env))
+			continue
+		}
+		c.mergeLayer(layer, []string{cf.Key}, "env:"+cf.Env)
+	}
+}
+
+// mergeFlags overwrites the fields with command line flags which are set.
+func (c *Config) mergeFlags(cmd *cli.Command) {
+	for _, cf := range configFields() {
+		if cf.Flag == "" || !cmd.IsSet(cf.Flag) {
+			continue
+		}
+		var layer Config
+		switch cf.Kind {
+		case reflect.String:
+			layer.value(cf).SetString(cmd.String(cf.Flag))
+		case reflect.Bool:
+			layer.value(cf).SetBool(cmd.Bool(cf.Flag))
+		}
+		c.mergeLayer(layer, []string{cf.Key}, "flag:--"+cf.Flag)
+	}
 }
 
 // NewConfig creates a new Config instance.
@@ -91,7 +292,35 @@ func (c *Config) updateProjectTag(tag string) bool {
 	return false
 }
 
+func (c *Config) updateLocalRunLog(local bool) bool {
+	if c.LocalRunLog != local {
+		slog.Info(fmt.Sprintf("overwrite `local run log`: '%v' with '%v'", c.LocalRunLog, local))
+		c.LocalRunLog = local
+		return true
+	}
+	return false
+}
+
+func (c *Config) updateLockMode(mode string) bool {
+	if c.LockMode != mode {
+		slog.Info(fmt.Sprintf("overwrite `lock mode`: '%v' with '%v'", c.LockMode, mode))
+		c.LockMode = mode
+		return true
+	}
+	return false
+}
+
+func (c *Config) updateHardened(hardened bool) bool {
+	if c.Hardened != hardened {
+		slog.Info(fmt.Sprintf("overwrite `hardened`: '%v' with '%v'", c.Hardened, hardened))
+		c.Hardened = hardened
+		return true
+	}
+	return false
+}
+
 // loadAndSaveConfig loads the configuration from a file or creates a new one if it doesn't exist.
+// Only the single file is read and written, so layers are not merged here.
 // It updates the configuration with command line arguments if they are set.
 // If the configuration is updated, it writes the new configuration to the file.
 // It returns the final configuration.
@@ -105,19 +334,24 @@ func loadAndSaveConfig(cmd *cli.Command) (Config, string) {
 		slog.Error(err.Error())
 		os.Exit(1)
 	}
+	// the global configuration is the base layer, and must hold docker_bin and dir.
+	// other (project) configurations only hold values which are specified.
+	base := file == searchConfigFiles([]string{getGlobalConfigFile()})
 	if isFile(file) {
 		config, err = readConfig(file)
 		if err != nil {
 			slog.Error(err.Error())
 			os.Exit(1)
 		}
-	} else {
+	} else if base {
 		config = *NewConfig(
 			cmd.String("docker-bin"),
 			dir,
 			cmd.String("arch"),
 		)
 		write = true
+	} else {
+		write = true
 	}
 
 	if cmd.IsSet("docker-bin") && config.updateDockerBin(cmd.String("docker-bin")) {
@@ -138,8 +372,17 @@ func loadAndSaveConfig(cmd *cli.Command) (Config, string) {
 	if cmd.IsSet("proj-tag") && config.updateProjectTag(cmd.String("proj-tag")) {
 		write = true
 	}
+	if cmd.IsSet("local-run-log") && config.updateLocalRunLog(cmd.Bool("local-run-log")) {
+		write = true
+	}
+	if cmd.IsSet("hardened") && config.updateHardened(cmd.Bool("hardened")) {
+		write = true
+	}
+	if cmd.IsSet("lock-mode") && config.updateLockMode(cmd.String("lock-mode")) {
+		write = true
+	}
 	if write {
-		if config.DockerBin == "" || config.Dir == "" {
+		if base && (config.DockerBin == "" || config.Dir == "") {
 			slog.Error("docker-bin and dir must be set")
 			os.Exit(1)
 		}
@@ -149,43 +392,56 @@ func loadAndSaveConfig(cmd *cli.Command) (Config, string) {
 	return config, file
 }
 
-// loadConfig loads the configuration from a file and updates it with command line arguments if they are set.
-// It returns the final configuration.
+// loadConfig loads the configuration and merges it field by field, in order:
+// default values, configuration files (global, then project), GDOCKER_* environment variables,
+// and then command line arguments if they are set.
+// It returns the final configuration and the last configuration file found.
 // It does not write the configuration to a file.
 func loadConfig(cmd *cli.Command) (Config, string) {
-	var config Config
-	var err error
-	file := searchConfigFiles(cmd.StringSlice("config"))
-	if !isFile(file) {
-		config = *NewConfig("docker", "", getCPUArch())
-		return config, ""
-	}
-	config, err = readConfig(file)
+	config, files, err := readConfigLayers(cmd.StringSlice("config"))
 	if err != nil {
 		slog.Error(err.Error())
 		os.Exit(1)
 	}
-
-	if cmd.IsSet("docker-bin") {
-		config.updateDockerBin(cmd.String("docker-bin"))
-	}
-	if cmd.IsSet("dir") {
-		config.updateDir(cmd.String("dir"))
-	}
-	if cmd.IsSet("arch") {
-		config.updateDefaultArch(cmd.String("arch"))
-	}
-	if config.StockDir == "" || cmd.IsSet("stock") {
+	config.mergeFlags(cmd)
+	if config.StockDir == "" {
 		config.updateStockDir(cmd.String("stock"))
 	}
-	if cmd.IsSet("show-abspath") {
-		config.updateShowAbspath(cmd.Bool("show-abspath"))
-	}
-	if cmd.IsSet("proj-tag") {
-		config.updateProjectTag(cmd.String("proj-tag"))
+
+	if len(files) == 0 {
+		return config, ""
 	}
+	return config, files[len(files)-1]
+}
 
-	return config, file
+// readConfigLayers reads the configuration files which exist, and merges them over
+// the default values in the given order. GDOCKER_* environment variables are merged last.
+// It returns the merged configuration and the configuration files read.
+func readConfigLayers(files []string) (Config, []string, error) {
+	config := *NewConfig("docker", "", getCPUArch())
+	config.setOrigin("docker_bin", ORIGIN_DEFAULT)
+	config.setOrigin("arch", ORIGIN_DEFAULT)
+
+	var read []string
+	for _, file := range files {
+		if !isFile(file) {
+			continue
+		}
+		absfile, err := filepath.Abs(file)
+		if err != nil {
+			return config, read, err
+		}
+		layer, keys, err := readConfigLayer(absfile)
+		if err != nil {
+			return config, read, err
+		}
+		layer.resolvePaths(filepath.Dir(absfile))
+		config.mergeLayer(layer, keys, absfile)
+		read = append(read, absfile)
+	}
+	config.files = read
+	config.mergeEnv()
+	return config, read, nil
 }
 
 // searchConfigFiles searches for configuration files in the provided list of files.
@@ -247,11 +503,11 @@ func anonymizeConfigFile(file string, abs bool) string {
 		return filepath.Join(getGlobalConfigFileDirAlias(), "gdocker", strings.TrimPrefix(file, conf_dir))
 	}
 
-	// check config file is in the working direcory or not
+	// check config file is in the working direcory (or its parents) or not
 	wd := getWd()
-	_, err := filepath.Rel(file, wd)
-	if !abs && err == nil {
-		return filepath.Join(".", strings.TrimPrefix(file, wd))
+	rel, err := filepath.Rel(wd, file)
+	if !abs && err == nil && (isParentDir(wd, file) || isParentDir(filepath.Dir(file), wd)) {
+		return rel
 	}
 
 	return file
@@ -260,19 +516,46 @@ func anonymizeConfigFile(file string, abs bool) string {
 // readConfig reads the configuration from a JSON file.
 // It returns the configuration and an error if any occurs.
 func readConfig(file string) (Config, error) {
+	config, _, err := readConfigLayer(file)
+	return config, err
+}
+
+// readConfigLayer reads the configuration from a JSON file.
+// It also returns the keys which are set in the file.
+func readConfigLayer(file string) (Config, []string, error) {
 	var config Config
-	f, err := os.Open(file)
+	var keys []string
+	b, err := os.ReadFile(file)
 	if err != nil {
-		return config, err
+		return config, keys, err
 	}
-	defer f.Close()
 
-	decoder := json.NewDecoder(f)
-	if err := decoder.Decode(&config); err != nil {
-		return config, err
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return config, keys, fmt.Errorf("%s: %w", file, err)
+	}
+	if err := migrateConfig(file, m); err != nil {
+		return config, keys, err
+	}
+	// unknown keys are ignored with warnings (see `gdocker config validate`)
+	for _, err := range checkConfigKeys(file, m) {
+		if errors.Is(err, ErrConfigUnknownKey) {
+			slog.Warn(err.Error())
+		} else {
+			return config, keys, err
+		}
+	}
+	for k := range m {
+		if _, err := lookupConfigField(k); err == nil {
+			keys = append(keys, k)
+		}
+	}
+	b, _ = json.Marshal(m)
+	if err := json.Unmarshal(b, &config); err != nil {
+		return config, keys, fmt.Errorf("%s: %w", file, err)
 	}
 	slog.Info(fmt.Sprintf("read configuration from '%s'", anonymizeConfigFile(file, config.ShowAbspath)))
-	return config, err
+	return config, keys, nil
 }
 
 // writeConfig writes the configuration to a JSON file.
@@ -292,6 +575,7 @@ func writeConfig(file string, config Config, dry_run bool) {
 			defer f.Close()
 			w = f
 		}
+		config.SchemaVersion = CONFIG_SCHEMA_VERSION
 		b, err := json.Marshal(config)
 		if err != nil {
 			fmt.Println(err)
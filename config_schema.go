@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// CONFIG_SCHEMA_VERSION is the version of the configuration file format.
+// Increase it and add a migration to configMigrations when the format changes.
+const CONFIG_SCHEMA_VERSION = 1
+
+// configMigrations[v] migrates a configuration of the schema version v to v+1.
+var configMigrations = map[int]func(m map[string]json.RawMessage) error{
+	// version 0 (before schema_version was introduced) is compatible with version 1.
+	0: func(m map[string]json.RawMessage) error { return nil },
+}
+
+var (
+	ErrConfigUnknownKey     = errors.New("unknown key")
+	ErrConfigInvalidValue   = errors.New("invalid value")
+	ErrConfigPathNotFound   = errors.New("path not found")
+	ErrConfigDockerNotFound = errors.New("docker binary not found")
+	ErrConfigSchemaVersion  = errors.New("unsupported schema version")
+)
+
+// ConfigError represents an error of a configuration key in a configuration file.
+type ConfigError struct {
+	File   string // configuration file (or the origin of the value)
+	Key    string
+	Detail string
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		fmt.Fprintf(&b, "%s: ", anonymizeConfigFile(e.File, false))
+	}
+	if e.Key != "" {
+		fmt.Fprintf(&b, "`%s`: ", e.Key)
+	}
+	b.WriteString(e.Err.Error())
+	if e.Detail != "" {
+		fmt.Fprintf(&b, " (%s)", e.Detail)
+	}
+	return b.String()
+}
+
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// lookupConfigField returns the configuration field of the JSON key.
+func lookupConfigField(key string) (configField, error) {
+	for _, cf := range configFields() {
+		if cf.Key == key {
+			return cf, nil
+		}
+	}
+	var keys []string
+	for _, cf := range configFields() {
+		keys = append(keys, cf.Key)
+	}
+	return configField{}, &ConfigError{Key: key, Err: ErrConfigUnknownKey, Detail: "one of " + strings.Join(keys, ", ")}
+}
+
+// migrateConfig checks the schema version of a raw configuration and migrates it to the current version.
+func migrateConfig(file string, m map[string]json.RawMessage) error {
+	version := 0
+	if raw, ok := m["schema_version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return &ConfigError{File: file, Key: "schema_version", Err: ErrConfigInvalidValue, Detail: string(raw)}
+		}
+	}
+	if version > CONFIG_SCHEMA_VERSION {
+		return &ConfigError{File: file, Key: "schema_version", Err: ErrConfigSchemaVersion,
+			Detail: fmt.Sprintf("%d is newer than %d, please update gdocker", version, CONFIG_SCHEMA_VERSION)}
+	}
+	for ; version < CONFIG_SCHEMA_VERSION; version++ {
+		migrate, ok := configMigrations[version]
+		if !ok {
+			return &ConfigError{File: file, Key: "schema_version", Err: ErrConfigSchemaVersion, Detail: fmt.Sprint(version)}
+		}
+		if err := migrate(m); err != nil {
+			return &ConfigError{File: file, Key: "schema_version", Err: err}
+		}
+	}
+	m["schema_version"], _ = json.Marshal(CONFIG_SCHEMA_VERSION)
+	return nil
+}
+
+// checkConfigKeys returns errors for unknown keys and values of wrong types in a raw configuration.
+func checkConfigKeys(file string, m map[string]json.RawMessage) []error {
+	var errs []error
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if k == "schema_version" {
+			continue
+		}
+		cf, err := lookupConfigField(k)
+		if err != nil {
+			errs = append(errs, &ConfigError{File: file, Key: k, Err: ErrConfigUnknownKey})
+			continue
+		}
+		var c Config
+		if err := json.Unmarshal(m[k], c.value(cf).Addr().Interface()); err != nil {
+			errs = append(errs, &ConfigError{File: file, Key: k, Err: ErrConfigInvalidValue,
+				Detail: fmt.Sprintf("%s is expected, but got %s", cf.Kind, string(m[k]))})
+		}
+	}
+	return errs
+}
+
+// readConfigMap reads a configuration file as a raw JSON object.
+// It returns an empty object if the file does not exist.
+func readConfigMap(file string) (map[string]json.RawMessage, error) {
+	m := make(map[string]json.RawMessage)
+	if !isFile(file) {
+		return m, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("%s: %w", file, err)
+	}
+	return m, migrateConfig(file, m)
+}
+
+// writeConfigMap writes a raw JSON object to a configuration file.
+// If dry_run is true, it writes the content to stdout.
+func writeConfigMap(file string, m map[string]json.RawMessage, dry_run bool) {
+	m["schema_version"], _ = json.Marshal(CONFIG_SCHEMA_VERSION)
+	b, err := json.Marshal(m)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	if dry_run {
+		fmt.Println(string(b))
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	if err := os.WriteFile(file, b, 0666); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	slog.Info(fmt.Sprintf("write configuration to '%s'", anonymizeConfigFile(file, false)))
+}
+
+// setConfigValue parses the value as the type of the key and sets it to the raw configuration.
+func setConfigValue(m map[string]json.RawMessage, key string, value string) error {
+	cf, err := lookupConfigField(key)
+	if err != nil {
+		return err
+	}
+	var c Config
+	if err := c.setString(cf, value); err != nil {
+		return &ConfigError{Key: key, Err: ErrConfigInvalidValue, Detail: value}
+	}
+	// relative paths are given from the working directory, not from the configuration file
+	c.resolvePaths(getWd())
+	raw, err := json.Marshal(c.value(cf).Interface())
+	if err != nil {
+		return err
+	}
+	m[key] = raw
+	return nil
+}
+
+// validate checks the merged configuration values.
+// It returns all errors found.
+func (c *Config) validate() []error {
+	var errs []error
+	for _, cf := range configFields() {
+		v := c.value(cf)
+		switch {
+		case cf.Key == "roots":
+			for _, root := range c.Roots {
+				if !isDir(root.Dir) {
+					errs = append(errs, &ConfigError{File: c.Origin(cf.Key), Key: cf.Key, Err: ErrConfigPathNotFound, Detail: root.Dir})
+				}
+			}
+			errs = append(errs, c.validateRootNames()...)
+		case cf.Path && v.String() != "" && c.Origin(cf.Key) != ORIGIN_DEFAULT && !isDir(v.String()):
+			errs = append(errs, &ConfigError{File: c.Origin(cf.Key), Key: cf.Key, Err: ErrConfigPathNotFound, Detail: v.String()})
+		case cf.Key == "docker_bin":
+			if _, err := exec.LookPath(v.String()); err != nil {
+				errs = append(errs, &ConfigError{File: c.Origin(cf.Key), Key: cf.Key, Err: ErrConfigDockerNotFound, Detail: v.String()})
+			}
+		case cf.Key == "lock_mode" && !slices.Contains(LOCK_MODES, v.String()):
+			errs = append(errs, &ConfigError{File: c.Origin(cf.Key), Key: cf.Key, Err: ErrConfigInvalidValue,
+				Detail: fmt.Sprintf("must be one of %s, not '%s'", strings.Join(LOCK_MODES[1:], ", "), v.String())})
+		case cf.Key == "lint_disable":
+			for _, rule := range c.LintDisable {
+				if _, ok := lookupLintRule(rule); !ok {
+					errs = append(errs, &ConfigError{File: c.Origin(cf.Key), Key: cf.Key, Err: ErrConfigInvalidValue,
+						Detail: fmt.Sprintf("unknown rule '%s'", rule)})
+				}
+			}
+		case cf.Key == "arch" && !slices.Contains([]string{"arm", "x86_64"}, v.String()):
+			errs = append(errs, &ConfigError{File: c.Origin(cf.Key), Key: cf.Key, Err: ErrConfigInvalidValue,
+				Detail: fmt.Sprintf("must be 'arm' or 'x86_64', not '%s'", v.String())})
+		}
+	}
+	return errs
+}
+
+// validateRootNames checks that the names of the image roots ("dir" and "roots" of all
+// architectures) are unique, so "@name" of showdeps tells the roots apart.
+// Names default to the base name of the directory.
+func (c *Config) validateRootNames() []error {
+	var roots []ImageRoot
+	if c.Dir != "" {
+		roots = append(roots, ImageRoot{Dir: c.Dir})
+	}
+	roots = append(roots, c.Roots...)
+
+	var errs []error
+	seen := make(map[string]string)
+	for _, root := range roots {
+		name := root.Name
+		if name == "" {
+			name = filepath.Base(root.Dir)
+		}
+		if dir, ok := seen[name]; ok {
+			errs = append(errs, &ConfigError{File: c.Origin("roots"), Key: "roots", Err: ErrConfigInvalidValue,
+				Detail: fmt.Sprintf("duplicate root name '%s' ('%s' and '%s'); set \"name\" to tell them apart", name, dir, root.Dir)})
+			continue
+		}
+		seen[name] = root.Dir
+	}
+	return errs
+}
+
+// valueString returns the value of the field as a string for printing.
+func (c *Config) valueString(cf configField) string {
+	v := c.value(cf)
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return fmt.Sprintf("%v", v.Interface())
+	}
+	b, _ := json.Marshal(v.Interface())
+	return string(b)
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// build status of the images which are older than their build directories (see setBuildStatus)
+const NODE_STATUS_STALE = "stale"
+
+// NodeDetail is the information of an image shown when a node is clicked in the dashboard.
+type NodeDetail struct {
+	GraphNode
+	Dockerfile string            `json:"dockerfile,omitempty"`
+	Resources  []MakeResource    `json:"resources"`
+	ImageID    string            `json:"image_id,omitempty"`
+	Labels     map[string]string `json:"labels"`
+	Size       int64             `json:"size"`
+	Created    time.Time         `json:"created"`
+	Parents    []string          `json:"parents"`  // images this image depends on
+	Children   []string          `json:"children"` // images depending on this image
+}
+
+// MakeResource is a resource target of the Makefile ("{tag}/$(DIR_OUT)/{resource}:").
+type MakeResource struct {
+	Name     string   `json:"name"`
+	Commands []string `json:"commands"`
+}
+
+// mapImageInfo returns ImageInfo of local images by name:tag.
+func mapImageInfo(iis []ImageInfo) map[string]ImageInfo {
+	m := make(map[string]ImageInfo)
+	for _, ii := range iis {
+		for _, iname := range ii.Names {
+			m[iname] = ii
+		}
+	}
+	return m
+}
+
+// setBuildStatus marks built images as stale when their Dockerfile, resources or Makefile
+// are newer than the image, or when an image they depend on is newer or stale.
+func setBuildStatus(g *DepGraph, infos map[string]ImageInfo) {
+	index := make(map[string]int, len(g.Nodes))
+	for i, n := range g.Nodes {
+		index[n.ID] = i
+		if n.Status != NODE_STATUS_BUILT {
+			continue
+		}
+		created := infos[n.ID].Created
+		if n.BuildDir != "" && !created.IsZero() && lastModified(n.BuildDir).After(created) {
+			g.Nodes[i].Status = NODE_STATUS_STALE
+		}
+	}
+
+	// propagate to the images depending on stale or newer images
+	for range len(g.Nodes) {
+		changed := false
+		for _, e := range g.Edges {
+			from, to := &g.Nodes[index[e.From]], g.Nodes[index[e.To]]
+			if from.Status != NODE_STATUS_BUILT {
+				continue
+			}
+			newer := infos[to.ID].Created.After(infos[from.ID].Created) && !infos[from.ID].Created.IsZero()
+			if to.Status == NODE_STATUS_STALE || (to.Built && newer) {
+				from.Status = NODE_STATUS_STALE
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+}
+
+// lastModified returns the latest modification time of the files in the build directory
+// (except the "cache" directory) and the Makefile of the image.
+func lastModified(build_dir string) time.Time {
+	var last time.Time
+	if info, err := os.Stat(filepath.Join(filepath.Dir(build_dir), "Makefile")); err == nil {
+		last = info.ModTime()
+	}
+	filepath.WalkDir(build_dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && d.Name() == "cache" {
+			return filepath.SkipDir
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+		return nil
+	})
+	return last
+}
+
+// newNodeDetail collects the information of the node.
+func newNodeDetail(g DepGraph, n GraphNode, infos map[string]ImageInfo) NodeDetail {
+	nd := NodeDetail{GraphNode: n, Resources: []MakeResource{}, Parents: []string{}, Children: []string{}}
+	if n.BuildDir != "" {
+		if b, err := os.ReadFile(filepath.Join(n.BuildDir, "Dockerfile")); err == nil {
+			nd.Dockerfile = string(b)
+		}
+		nd.Resources = readMakeResources(filepath.Join(filepath.Dir(n.BuildDir), "Makefile"), n.Tag)
+	}
+	if ii, ok := infos[n.ID]; ok {
+		nd.ImageID = ii.Hash
+		nd.Labels = ii.Labels
+		nd.Size = ii.Size
+		nd.Created = ii.Created
+	}
+	for _, e := range g.Edges {
+		if e.From == n.ID {
+			nd.Parents = append(nd.Parents, e.To)
+		}
+		if e.To == n.ID {
+			nd.Children = append(nd.Children, e.From)
+		}
+	}
+	return nd
+}
+
+// readMakeResources returns the resource targets of the tag and their recipes in the Makefile.
+func readMakeResources(file string, tag string) []MakeResource {
+	resources := []MakeResource{}
+	f, err := os.Open(file)
+	if err != nil {
+		return resources
+	}
+	defer f.Close()
+
+	re := regexp.MustCompile(`^` + regexp.QuoteMeta(tag+"/$(DIR_OUT)/") + `(\S+):`)
+	var current *MakeResource
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if current != nil && strings.HasPrefix(line, "\t") {
+			current.Commands = append(current.Commands, strings.TrimPrefix(line, "\t"))
+			continue
+		}
+		if current != nil {
+			resources = append(resources, *current)
+			current = nil
+		}
+		if m := re.FindStringSubmatch(line); m != nil {
+			current = &MakeResource{Name: m[1], Commands: []string{}}
+		}
+	}
+	if current != nil {
+		resources = append(resources, *current)
+	}
+	return resources
+}
+
+// checkLoopbackAddr returns an error unless the address (host:port) is bound to a loopback interface.
+func checkLoopbackAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if !isLoopbackHost(host) {
+		return fmt.Errorf("the dashboard binds to localhost only ('%s' is not a loopback address)", addr)
+	}
+	return nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// localhostOnly rejects requests whose Host header is not localhost (against DNS rebinding).
+func localhostOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if !isLoopbackHost(strings.Trim(host, "[]")) {
+			http.Error(w, "forbidden host", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// dashboard serves the dependency graph with the build status, node details and jobs.
+type dashboard struct {
+	*depGraphServer
+	jobs *jobQueue
+
+	infoMu sync.RWMutex
+	infos  map[string]ImageInfo // local images at the last load
+}
+
+// newDashboard returns a dashboard of all images in the image build directories.
+// args are passed to the gdocker commands run as jobs.
+func newDashboard(config Config, args []string) *dashboard {
+	d := &dashboard{}
+	load := func() (DepGraph, error) {
+		ibds := searchImageBuildDirs(config.imageRoots(), "archive")
+		ibds.makeMap()
+		deps, err := solveDeps(config, ibds, ibds.ImageNames())
+		if err != nil {
+			// keep serving while Dockerfiles are being edited
+			logDependencyError(err)
+		}
+		g := newDepGraph(deps, ibds, getExistImages(config.DockerBin))
+		infos := mapImageInfo(getImageInfo(config.DockerBin))
+		setBuildStatus(&g, infos)
+		d.infoMu.Lock()
+		d.infos = infos
+		d.infoMu.Unlock()
+		return g, nil
+	}
+	d.depGraphServer = newDepGraphServer(load, config.imageRoots())
+	d.jobs = newJobQueue(args, d.reload)
+	return d
+}
+
+type dataDashboardPage struct {
+	Title string
+	CSS   template.CSS
+	JS    template.JS
+	SVG   template.HTML
+}
+
+func (d *dashboard) renderPage() ([]byte, error) {
+	tmpl, err := template.ParseFS(webAssets, "web/dashboard.html")
+	if err != nil {
+		return nil, err
+	}
+	css, err := webAssets.ReadFile("web/dashboard.css")
+	if err != nil {
+		return nil, err
+	}
+	js, err := webAssets.ReadFile("web/dashboard.js")
+	if err != nil {
+		return nil, err
+	}
+	g, _ := d.current()
+	var b bytes.Buffer
+	err = tmpl.Execute(&b, dataDashboardPage{
+		Title: "gdocker dashboard",
+		CSS:   template.CSS(css),
+		JS:    template.JS(js),
+		SVG:   template.HTML(renderSVG(g)),
+	})
+	return b.Bytes(), err
+}
+
+// handler returns the handler of the dashboard page and the JSON API.
+//
+//	GET  /api/graph          the graph with the build status
+//	GET  /api/nodes/{id}     the detail of an image (name:tag)
+//	GET  /api/jobs           all jobs
+//	POST /api/jobs           queue a job ({"action": "build" or "clean", "images": [...]})
+//	GET  /api/jobs/{id}      a job
+//	GET  /api/jobs/{id}/log  the log of a job, streamed until the job is finished
+func (d *dashboard) handler() http.Handler {
+	mux := d.mux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		b, err := d.renderPage()
+		if err != nil {
+			http.Error(w, "template execute error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(b)
+	})
+	mux.HandleFunc("GET /api/graph", func(w http.ResponseWriter, r *http.Request) {
+		g, _ := d.current()
+		writeJSON(w, http.StatusOK, g)
+	})
+	mux.HandleFunc("GET /api/nodes/{id}", func(w http.ResponseWriter, r *http.Request) {
+		g, _ := d.current()
+		i := slices.IndexFunc(g.Nodes, func(n GraphNode) bool { return n.ID == r.PathValue("id") })
+		if i < 0 {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("image '%s' is not found", r.PathValue("id")))
+			return
+		}
+		d.infoMu.RLock()
+		infos := d.infos
+		d.infoMu.RUnlock()
+		writeJSON(w, http.StatusOK, newNodeDetail(g, g.Nodes[i], infos))
+	})
+	mux.HandleFunc("GET /api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, d.jobs.list())
+	})
+	mux.HandleFunc("POST /api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		// a JSON body can not be sent cross-origin without a preflight request
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+			writeJSONError(w, http.StatusUnsupportedMediaType, fmt.Errorf("Content-Type must be application/json"))
+			return
+		}
+		var req struct {
+			Action string   `json:"action"`
+			Images []string `json:"images"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		j, err := d.jobs.add(req.Action, req.Images)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/jobs/%d", j.ID))
+		writeJSON(w, http.StatusAccepted, j)
+	})
+	mux.HandleFunc("GET /api/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(r.PathValue("id"))
+		j, ok := d.jobs.get(id)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("job '%s' is not found", r.PathValue("id")))
+			return
+		}
+		writeJSON(w, http.StatusOK, j)
+	})
+	mux.HandleFunc("GET /api/jobs/{id}/log", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(r.PathValue("id"))
+		if _, ok := d.jobs.get(id); !ok {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("job '%s' is not found", r.PathValue("id")))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		flusher, _ := w.(http.Flusher)
+		offset := 0
+		for {
+			b, done := d.jobs.logFrom(id, offset)
+			if len(b) > 0 {
+				w.Write(b)
+				offset += len(b)
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			if done {
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(JOB_LOG_INTERVAL):
+			}
+		}
+	})
+	return localhostOnly(mux)
+}
+
+const JOB_LOG_INTERVAL = 200 * time.Millisecond
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
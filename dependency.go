@@ -1,8 +1,8 @@
 package main
 
 import (
+	"errors"
 	"log/slog"
-	"os"
 )
 
 // DockerImageの依存関係を示す。FromがToに依存している。
@@ -11,7 +11,10 @@ type Dependency struct {
 	To   DockerImage
 }
 
-func checkDependency(imgs []DockerImage, deps []Dependency) ([]DockerImage, map[string]struct{}) {
+// checkDependency returns the images and the images they depend on in build order,
+// and the root images. If there are cycles reachable from the images, all of them are
+// returned as a joined error of *CycleError[string].
+func checkDependency(imgs []DockerImage, deps []Dependency) ([]DockerImage, map[string]struct{}, error) {
 	// Initialize the graph.
 	graph := NewGraph[string]()
 
@@ -20,38 +23,40 @@ func checkDependency(imgs []DockerImage, deps []Dependency) ([]DockerImage, map[
 		graph.AddEdge(dep.From.String(), dep.To.String())
 	}
 
+	var starts []string
+	for _, img := range imgs {
+		if img.Name != "" {
+			starts = append(starts, img.String())
+		}
+	}
+	if cycles := graph.Cycles(starts...); len(cycles) > 0 {
+		errs := make([]error, len(cycles))
+		for i, c := range cycles {
+			errs[i] = c
+		}
+		return nil, nil, errors.Join(errs...)
+	}
+
 	roots := map[string]struct{}{}
 	sorted := make([]string, 0, len(imgs))
 	appeared := make(map[string]struct{}, len(imgs))
-	for i, img := range imgs {
-		exist := false
-		if i != 0 {
-			for _, called := range sorted {
-				if img.String() == called {
-					exist = true
-					break
-				}
-			}
-		}
-		if img.Name == "" {
+	for _, img := range starts {
+		if _, ok := appeared[img]; ok {
 			continue
 		}
 
-		if !exist {
-			imgnames_to_add, err := graph.TopSort(img.String())
-			if err != nil {
-				slog.Error(err.Error())
-				os.Exit(1)
-			}
-			if _, ok := roots[imgnames_to_add[0]]; !ok {
-				roots[imgnames_to_add[0]] = struct{}{}
-			}
-			for _, imgname_to_add := range imgnames_to_add {
-				if _, ok := appeared[imgname_to_add]; ok {
-				} else {
-					sorted = append(sorted, imgname_to_add)
-					appeared[imgname_to_add] = struct{}{}
-				}
+		imgnames_to_add, err := graph.TopSort(img)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(imgnames_to_add) == 0 {
+			continue
+		}
+		roots[imgnames_to_add[0]] = struct{}{}
+		for _, imgname_to_add := range imgnames_to_add {
+			if _, ok := appeared[imgname_to_add]; !ok {
+				sorted = append(sorted, imgname_to_add)
+				appeared[imgname_to_add] = struct{}{}
 			}
 		}
 	}
@@ -64,5 +69,16 @@ func checkDependency(imgs []DockerImage, deps []Dependency) ([]DockerImage, map[
 		}
 		img_sorted = append(img_sorted, d)
 	}
-	return img_sorted, roots
+	return img_sorted, roots, nil
+}
+
+// logDependencyError logs each error joined by checkDependency.
+func logDependencyError(err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			slog.Error(e.Error())
+		}
+		return
+	}
+	slog.Error(err.Error())
 }
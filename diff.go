@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DIFF_CONTEXT is the number of context lines of unifiedDiff.
+const DIFF_CONTEXT = 3
+
+// diffOp is a line of the edit script ( ' ': kept, '-': deleted, '+': inserted).
+type diffOp struct {
+	kind byte
+	text string
+	a, b int // line indexes (0-based) in the old and the new text
+}
+
+// splitLines splits the text into lines. The final newline does not make an empty line.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines returns the edit script from a to b by the longest common subsequence.
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
+
+// unifiedDiff returns the unified diff of the texts, or an empty string if they are equal.
+func unifiedDiff(fromFile, toFile, from, to string) string {
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	var buf strings.Builder
+	for start := 0; start < len(ops); {
+		// the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// the hunk ends where more than twice the context lines are kept
+		end := start
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			kept := end
+			for kept < len(ops) && ops[kept].kind == ' ' {
+				kept++
+			}
+			if kept == len(ops) || kept-end > 2*DIFF_CONTEXT {
+				break
+			}
+			end = kept
+		}
+		lo, hi := max(start-DIFF_CONTEXT, 0), min(end+DIFF_CONTEXT, len(ops))
+
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", fromFile, toFile)
+		}
+		var lenA, lenB int
+		for _, op := range ops[lo:hi] {
+			if op.kind != '+' {
+				lenA++
+			}
+			if op.kind != '-' {
+				lenB++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(ops[lo].a, lenA), hunkRange(ops[lo].b, lenB))
+		for _, op := range ops[lo:hi] {
+			fmt.Fprintf(&buf, "%c%s\n", op.kind, op.text)
+		}
+		start = hi
+	}
+	return buf.String()
+}
+
+// hunkRange returns the range of a hunk ("start,length", 1-based; the line before if empty).
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
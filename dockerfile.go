@@ -2,11 +2,11 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"slices"
 	"strings"
 )
 
@@ -15,6 +15,9 @@ var (
 	ARG_SEPARATER     = ":"
 	OLD_ARG_SEPARATER string
 	ONCE              = false
+
+	// entrypoint of the preset footer (see TMPL_UBUNTU_ENTRYPOINT)
+	PRESET_ENTRYPOINT = []string{"/usr/local/bin/entrypoint.sh"}
 )
 
 // Dockerfile
@@ -22,6 +25,10 @@ type Dockerfile struct {
 	stages    []Stage
 	numStages int
 	usePreset bool
+
+	// set by the parser (see ParseDockerfile)
+	directives     map[string]string // parser directives (e.g. syntax, escape)
+	noFinalNewline bool              // the source does not end with a newline
 }
 
 func NewDockerfile(preset bool) Dockerfile {
@@ -29,8 +36,8 @@ func NewDockerfile(preset bool) Dockerfile {
 	if preset {
 		d.usePreset = true
 		d.AddStage(Stage{})
-		d.LastStage().AddInstruction(&COMMENT{"syntax=docker/dockerfile:1"})
-		d.LastStage().AddInstruction(&COMMENT{"This Dockerfile is created using gdocker."})
+		d.LastStage().AddInstruction(&COMMENT{comment: "syntax=docker/dockerfile:1"})
+		d.LastStage().AddInstruction(&COMMENT{comment: "This Dockerfile is created using gdocker."})
 	}
 	return d
 }
@@ -50,9 +57,10 @@ func (d *Dockerfile) AddInstruction(inst InstructionBuilder) {
 		d.LastStage().containsFrom = true
 	}
 	if d.numStages == 0 {
-		if _, iscomment := inst.(*COMMENT); iscomment {
+		switch inst.(type) {
+		case *COMMENT, *BLANK, *ARG_INST:
 			d.AddStage(Stage{})
-		} else {
+		default:
 			slog.Error("first instruction must be FROM")
 			os.Exit(1)
 		}
@@ -62,7 +70,7 @@ func (d *Dockerfile) AddInstruction(inst InstructionBuilder) {
 		case *FROM_INST:
 		case *COMMENT:
 		case *BLANK:
-		//case *ARG:
+		case *ARG_INST:
 		default:
 			slog.Error(fmt.Sprintf("%T is disallowed before FROM", v))
 			os.Exit(1)
@@ -79,10 +87,10 @@ func (d *Dockerfile) addFooter() {
 	if d.usePreset {
 		presetFooter := []InstructionBuilder{
 			&BLANK{},
-			&LABEL_INST{[]string{fmt.Sprintf("com.gdocker.version=v%s", APP_VERSION)}},
+			&LABEL_INST{keys: []string{"com.gdocker.version"}, values: []string{fmt.Sprintf("v%s", APP_VERSION)}},
 			&BLANK{},
-			&WORKDIR_INST{"/data"},
-			&ENTRYPOINT_INST{"exec", []string{"/usr/local/bin/entrypoint.sh"}, "!"},
+			&WORKDIR_INST{workingdirectory: "/data"},
+			&ENTRYPOINT_INST{form: "exec", command: PRESET_ENTRYPOINT, operator: "!"},
 		}
 		for _, inst := range presetFooter {
 			d.LastStage().AddInstruction(inst)
@@ -92,21 +100,28 @@ func (d *Dockerfile) addFooter() {
 
 func (d *Dockerfile) BuildAll() {
 	d.addFooter()
+	os.Stdout.Write(d.Bytes())
+}
+
+// Bytes renders the Dockerfile. Parsed instructions are rendered as their original text,
+// and the others with the escape character of the escape directive.
+func (d *Dockerfile) Bytes() []byte {
 	var buf bytes.Buffer
+	escape := d.directives["escape"]
 	for _, stage := range d.stages {
-		stage.appendBuffer(&buf)
+		stage.appendBuffer(&buf, escape)
+	}
+	if d.noFinalNewline {
+		return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
 	}
-	buf.WriteTo(os.Stdout)
+	return buf.Bytes()
 }
 
 func (d *Dockerfile) WriteTo(file string, box bool) {
 	d.addFooter()
 
 	var err error
-	var buf bytes.Buffer
-	for _, stage := range d.stages {
-		stage.appendBuffer(&buf)
-	}
+	buf := bytes.NewBuffer(d.Bytes())
 
 	var w io.Writer
 	if file == "stdout" {
@@ -142,43 +157,50 @@ func (s *Stage) AddInstruction(inst InstructionBuilder) {
 	s.Instructions = append(s.Instructions, inst)
 }
 
-func (s Stage) appendBuffer(buf *bytes.Buffer) {
+func (s Stage) appendBuffer(buf *bytes.Buffer, escape string) {
 	s.Merge()
 	s.MergeApt()
+	s.MergePkg()
 	if len(s.Instructions) > 0 {
 		for _, inst := range s.Instructions {
-			buf.WriteString(inst.Build())
+			buf.WriteString(renderEscaped(inst, escape))
 			buf.WriteString("\n")
 		}
 	}
 }
 
+// Merge merges consecutive RUNs continued by their operators (e.g. &&).
+// RUNs with different options (e.g. mounts) and heredocs are not merged.
 func (s *Stage) Merge() {
 	var new []InstructionBuilder
 	merged := RUN_INST{}
+	flush := func() {
+		if merged.form == "" {
+			return
+		}
+		toadd := RUN_INST{}
+		toadd.form = merged.form
+		toadd.options = merged.options
+		toadd.command = append(toadd.command, merged.command...)
+		toadd.operator = "!"
+		new = append(new, &toadd)
+		merged = RUN_INST{}
+	}
 	for _, inst := range s.Instructions {
 		r, is_run := inst.(*RUN_INST)
+		if is_run && merged.form != "" && !slices.Equal(r.options, merged.options) {
+			flush()
+		}
 		// append
-		if !is_run || r.operator == "!" {
-			if merged.form == "" {
-				// temporal inst only
-				new = append(new, inst)
-				continue
-			} else {
-				// both merged and temporal inst
-				toadd := RUN_INST{}
-				toadd.form = merged.form
-				toadd.command = append(toadd.command, merged.command...)
-				toadd.operator = "!"
-				new = append(new, &toadd)
-				new = append(new, inst)
-				merged = RUN_INST{}
-				continue
-			}
+		if !is_run || r.operator == "!" || r.heredoc != "" {
+			flush()
+			new = append(new, inst)
+			continue
 		}
 
 		if merged.form == "" {
 			merged.form = r.form
+			merged.options = r.options
 			merged.command = r.command
 			merged.operator = r.operator
 		} else {
@@ -187,41 +209,29 @@ func (s *Stage) Merge() {
 			merged.operator = r.operator
 		}
 	}
+	flush()
 	s.Instructions = new
 }
 
+// MergeApt merges apt installs into the first one. Those with different options
+// (i.e. the cache mounts) are merged separately.
 func (s *Stage) MergeApt() {
-	merged := APT_INSTALL{}
-	for _, inst := range s.Instructions {
-		a, is_apt := inst.(*APT_INSTALL)
-		// append
-		if is_apt {
-			if merged.form == "shell" {
-				merged.command = append(merged.command, a.command...)
-			} else {
-				merged.form = "shell"
-				merged.command = a.command
-				merged.operator = "!"
-			}
-		}
-	}
-	if merged.form == "" {
-		return
-	}
-	unuse := true
+	var merged []*APT_INSTALL
 	var new []InstructionBuilder
 	for _, inst := range s.Instructions {
-		_, is_apt := inst.(*APT_INSTALL)
-		if is_apt {
-			if unuse {
-				new = append(new, &merged)
-				unuse = false
-			} else {
-				continue
-			}
-		} else {
+		a, is_apt := inst.(*APT_INSTALL)
+		if !is_apt {
 			new = append(new, inst)
+			continue
 		}
+		i := slices.IndexFunc(merged, func(m *APT_INSTALL) bool { return slices.Equal(m.options, a.options) })
+		if i < 0 {
+			m := &APT_INSTALL{form: "shell", options: a.options, command: slices.Clone(a.command), operator: "!"}
+			merged = append(merged, m)
+			new = append(new, m)
+			continue
+		}
+		merged[i].command = append(merged[i].command, a.command...)
 	}
 	s.Instructions = new
 }
@@ -229,12 +239,13 @@ func (s *Stage) MergeApt() {
 // InstructionBuilder
 type InstructionBuilder interface {
 	Build() string
-	Parse(string)
-	ParseShort(string)
+	Parse(string) error
+	ParseShort(string) error
 }
 
 // blank or comment line
 type COMMENT struct {
+	source
 	comment string
 }
 
@@ -246,24 +257,29 @@ func (comment COMMENT) Build() string {
 	return strings.Join(args, " ")
 }
 
-func (comment *COMMENT) Parse(s string) {
+func (comment *COMMENT) Parse(s string) error {
+	if err := checkLine("comment", s); err != nil {
+		return err
+	}
 	comment.comment = s
+	return nil
 }
 
-func (comment *COMMENT) ParseShort(s string) {
-	comment.Parse(s)
+func (comment *COMMENT) ParseShort(s string) error {
+	return comment.Parse(s)
 }
 
 type BLANK COMMENT
 
 func (blank BLANK) Build() string { return "" }
 
-func (blank *BLANK) Parse(s string) {}
+func (blank *BLANK) Parse(s string) error { return nil }
 
-func (blank *BLANK) ParseShort(s string) {}
+func (blank *BLANK) ParseShort(s string) error { return nil }
 
 // FROM
 type FROM_INST struct {
+	source
 	platform string
 	image    string
 	tag      string
@@ -275,54 +291,77 @@ func (from FROM_INST) Build() string {
 	if from.platform != "" {
 		args = append(args, fmt.Sprintf("--platform=%s", from.platform))
 	}
-	if from.tag == "" {
-		args = append(args, from.image)
-	} else {
-		args = append(args, fmt.Sprintf("%s:%s", from.image, from.tag))
-	}
+	args = append(args, from.reference())
 	if from.AS != "" {
 		args = append(args, []string{"AS", from.AS}...)
 	}
 	return strings.Join(args, " ")
 }
 
-func (from *FROM_INST) Parse(s string) {
+// reference returns the image reference (image[:tag]).
+func (from FROM_INST) reference() string {
+	if from.tag == "" {
+		return from.image
+	}
+	return fmt.Sprintf("%s:%s", from.image, from.tag)
+}
+
+// Parse parses "<platform>:<image>:<tag>:<alias>" (all but the image can be empty).
+func (from *FROM_INST) Parse(s string) error {
 	args := strings.Split(s, ARG_SEPARATER)
-	from.platform = args[0]
-	from.image = args[1]
-	from.tag = args[2]
-	from.AS = args[3]
+	if len(args) != 4 || args[1] == "" {
+		return fmt.Errorf("invalid FROM '%s' (<platform>:<image>:<tag>:<alias>)", s)
+	}
+	from.platform, from.image, from.tag, from.AS = args[0], args[1], args[2], args[3]
+	return from.check(s)
 }
 
-func (from *FROM_INST) ParseShort(s string) {
+func (from *FROM_INST) ParseShort(s string) error {
 	args := strings.Split(s, ARG_SEPARATER)
-	switch len(args) {
-	case 1:
-		from.image = args[0]
-	case 2:
-		from.image = args[0]
-		from.tag = args[1]
-	case 3:
-		from.image = args[0]
+	if len(args) > 3 || args[0] == "" {
+		return fmt.Errorf("invalid from string '%s'", s)
+	}
+	from.image = args[0]
+	if len(args) > 1 {
 		from.tag = args[1]
+	}
+	if len(args) > 2 {
 		from.AS = args[2]
-	default:
-		slog.Error(fmt.Sprintf("invalid from string '%s'", s))
-		os.Exit(1)
 	}
+	return from.check(s)
 }
 
-// common instruction build routine of RUN, CMD and ENTRYPOINT
+// check returns an error if any field would be split into words.
+func (from FROM_INST) check(s string) error {
+	for _, v := range []string{from.platform, from.image, from.tag, from.AS} {
+		if strings.ContainsAny(v, " \t\r\n") {
+			return fmt.Errorf("invalid FROM '%s': whitespace is not allowed", s)
+		}
+	}
+	return nil
+}
+
+// common instruction build routine of RUN, CMD and ENTRYPOINT.
+// The words of the shell form are the text of the shell joined by spaces ("\" continues the line).
+// A shell form which a Dockerfile can not keep (line breaks or the escape character at the end)
+// is written in the exec form run by /bin/sh -c.
 func commonBuild(inst, form string, command []string) string {
 	args := []string{inst}
 
 	// check form
-	if form != "shell" && form != "exec" {
-		slog.Error(fmt.Sprintf("form must be either shell or exec, '%s'", form))
+	if err := checkForm(form); err != nil {
+		slog.Error(err.Error())
 		os.Exit(1)
 	}
 
 	if form == "shell" {
+		// a continuation at the end would join the next line
+		for len(command) > 0 && command[len(command)-1] == `\` {
+			command = command[:len(command)-1]
+		}
+		if !isShellFormSafe(command) {
+			return strings.Join(append(args, jsonArray([]string{"/bin/sh", "-c", shellText(command)})), " ")
+		}
 		args = append(args, command...)
 		for i, v := range args {
 			if v == `\` {
@@ -331,62 +370,172 @@ func commonBuild(inst, form string, command []string) string {
 			}
 		}
 	} else {
-		out, _ := json.Marshal(command)
-		args = append(args, string(out))
+		args = append(args, jsonArray(command))
 	}
 	return strings.Join(args, " ")
 }
 
+// checkCommand returns an error if the command of RUN, CMD, ENTRYPOINT or HEALTHCHECK has no word,
+// which docker rejects.
+func checkCommand(command []string, s string) error {
+	for _, v := range command {
+		if strings.TrimSpace(v) != "" && v != `\` {
+			return nil
+		}
+	}
+	return fmt.Errorf("no command found. '%s'", s)
+}
+
+func checkForm(form string) error {
+	if form != "shell" && form != "exec" {
+		return fmt.Errorf("form must be either shell or exec, '%s'", form)
+	}
+	return nil
+}
+
+// isShellFormSafe reports whether the words can be written in the shell form.
+func isShellFormSafe(command []string) bool {
+	for _, v := range command {
+		if strings.ContainsAny(v, "\r\n") {
+			return false
+		}
+	}
+	return len(command) == 0 || !strings.HasSuffix(command[len(command)-1], `\`)
+}
+
+// shellText returns the text of the shell form without the continuations.
+func shellText(command []string) string {
+	var words []string
+	for _, v := range command {
+		if v != `\` {
+			words = append(words, v)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
 // RUN
 type RUN_INST struct {
+	source
 	form     string
 	command  []string
 	operator string
+	options  []string // flags (e.g. --mount=type=cache,target=/root/.cache, --network=none)
+	heredoc  string   // body of the heredoc (RUN [command] <<EOF)
+}
+
+func (run RUN_INST) Build() string {
+	keyword := strings.Join(append([]string{"RUN"}, run.options...), " ")
+	if run.heredoc == "" {
+		return commonBuild(keyword, run.form, run.command)
+	}
+	// the command (e.g. python3) reads the heredoc, or the heredoc is run by the shell
+	delim := heredocDelimiter(run.heredoc)
+	args := []string{keyword}
+	for _, v := range run.command {
+		if v != "" {
+			args = append(args, v)
+		}
+	}
+	args = append(args, "<<"+delim)
+	return strings.Join(args, " ") + "\n" + strings.TrimSuffix(run.heredoc, "\n") + "\n" + delim
 }
 
-func (run RUN_INST) Build() string { return commonBuild("RUN", run.form, run.command) }
+// heredocDelimiter returns a delimiter (EOF) which does not appear as a line in the body.
+func heredocDelimiter(body string) string {
+	delim := "EOF"
+	for slices.Contains(strings.Split(body, "\n"), delim) {
+		delim += "_"
+	}
+	return delim
+}
 
-func (run *RUN_INST) Parse(s string) {
+func (run *RUN_INST) Parse(s string) error {
 	form, cut_form, ok := strings.Cut(s, ARG_SEPARATER)
 	if !ok {
-		slog.Error(fmt.Sprintf("no form found. '%s'", s))
-		os.Exit(1)
+		return fmt.Errorf("no form found. '%s'", s)
+	}
+	if err := checkForm(form); err != nil {
+		return err
+	}
+	sep, rest, err := separatorCheck(cut_form)
+	if err != nil {
+		return err
 	}
-	sep, rest := separatorCheck(cut_form)
 	com, op := operatorCheck(rest)
 	run.form = form
 	run.command = strings.Split(com, sep)
-	run.operator = op
+	run.operator, run.options = optionCheck(op, sep)
+	return checkCommand(run.command, s)
 }
 
-func (run *RUN_INST) ParseShort(s string) {
-	sep, rest := separatorCheck(s)
+func (run *RUN_INST) ParseShort(s string) error {
+	sep, rest, err := separatorCheck(s)
+	if err != nil {
+		return err
+	}
 	com, op := operatorCheck(rest)
 	run.form = "shell"
 	run.command = strings.Split(com, sep)
-	run.operator = op
+	run.operator, run.options = optionCheck(op, sep)
+	return checkCommand(run.command, s)
+}
+
+// mounts of the apt cache (see APT_INSTALL)
+var APT_CACHE_MOUNTS = []string{
+	"--mount=type=cache,target=/var/cache/apt,sharing=locked",
+	"--mount=type=cache,target=/var/lib/apt,sharing=locked",
 }
 
 // apt install
 type APT_INSTALL RUN_INST
 
 func (apt APT_INSTALL) Build() string {
+	if slices.Equal(apt.options, APT_CACHE_MOUNTS) {
+		// packages and lists are kept in the cache mounts instead of the image,
+		// so the cleanup by the base image (docker-clean) is removed and no cleanup is needed.
+		var command []string
+		for _, option := range apt.options {
+			command = append(command, option, "\\")
+		}
+		command = append(command, []string{"rm", "-f", "/etc/apt/apt.conf.d/docker-clean", "\\"}...)
+		command = append(command, []string{"&&", "apt-get", "update", "&&", "apt-get", "install", "-y", "--no-install-recommends", "\\"}...)
+		command = append(command, apt.command...)
+		return commonBuild("RUN", apt.form, slices.Clone(command[:len(command)-1]))
+	}
 	command := []string{"apt-get", "update", "&&", "apt-get", "install", "-y", "--no-install-recommends", "\\"}
 	command = append(command, apt.command...)
 	command = append(command, []string{"&&", "apt-get", "clean", "-y", "\\"}...)
-	command = append(command, []string{"&&", "rm", "-rf", "/var/lib/apt/list/*"}...)
+	command = append(command, []string{"&&", "rm", "-rf", "/var/lib/apt/lists/*"}...)
 	return commonBuild("RUN", apt.form, command)
 }
 
-func (apt *APT_INSTALL) Parse(s string) {
+// Parse parses "<pkg>[,<pkg>...][:cache]" ("cache" uses the apt cache mounts of BuildKit).
+func (apt *APT_INSTALL) Parse(s string) error {
+	pkgs, option, _ := strings.Cut(s, ARG_SEPARATER)
+	switch option {
+	case "":
+	case "cache":
+		apt.options = APT_CACHE_MOUNTS
+	default:
+		return fmt.Errorf("invalid apt option '%s'", option)
+	}
 	apt.form = "shell"
-	for _, pkg := range strings.Split(s, ",") {
-		apt.command = append(apt.command, pkg, "\\")
+	for _, pkg := range strings.Split(pkgs, ",") {
+		if strings.ContainsAny(pkg, " \t\r\n") {
+			return fmt.Errorf("invalid apt package '%s' in '%s'", pkg, s)
+		}
+		if pkg != "" {
+			apt.command = append(apt.command, pkg, "\\")
+		}
+	}
+	if len(apt.command) == 0 {
+		return fmt.Errorf("no apt package found. '%s'", s)
 	}
+	return nil
 }
-
-func (apt *APT_INSTALL) ParseShort(s string) {
-	apt.Parse(s)
+func (apt *APT_INSTALL) ParseShort(s string) error {
+	return apt.Parse(s)
 }
 
 // ENTRYPOINT
@@ -396,25 +545,36 @@ func (ep ENTRYPOINT_INST) Build() string {
 	return commonBuild("ENTRYPOINT", ep.form, ep.command)
 }
 
-func (ep *ENTRYPOINT_INST) Parse(s string) {
+func (ep *ENTRYPOINT_INST) Parse(s string) error {
 	form, cut_form, ok := strings.Cut(s, ARG_SEPARATER)
 	if !ok {
-		slog.Error(fmt.Sprintf("no form found. '%s'", s))
-		os.Exit(1)
+		return fmt.Errorf("no form found. '%s'", s)
+	}
+	if err := checkForm(form); err != nil {
+		return err
 	}
 	ep.form = form
-	sep, rest := separatorCheck(cut_form)
+	sep, rest, err := separatorCheck(cut_form)
+	if err != nil {
+		return err
+	}
 	ep.command = strings.Split(rest, sep)
+	return checkCommand(ep.command, s)
 }
 
-func (ep *ENTRYPOINT_INST) ParseShort(s string) {
-	sep, rest := separatorCheck(s)
+func (ep *ENTRYPOINT_INST) ParseShort(s string) error {
+	sep, rest, err := separatorCheck(s)
+	if err != nil {
+		return err
+	}
 	ep.form = "exec"
 	ep.command = strings.Split(rest, sep)
+	return checkCommand(ep.command, s)
 }
 
 // COPY
 type COPY_INST struct {
+	source
 	form    string
 	options []string
 	src     []string
@@ -427,48 +587,57 @@ func (copy COPY_INST) Build() string {
 		args = append(args, copy.options...)
 	}
 
-	if copy.form == "shell" {
-		args = append(args, append(copy.src, copy.dest)...)
+	// paths with whitespace or quotes are written in the exec form
+	paths := append(slices.Clone(copy.src), copy.dest)
+	if copy.form == "shell" && !slices.ContainsFunc(paths, func(p string) bool { return strings.ContainsAny(p, " \t\"'") }) {
+		args = append(args, paths...)
 	} else {
-		out, _ := json.Marshal(append(copy.src, copy.dest))
-		args = append(args, string(out))
+		args = append(args, jsonArray(paths))
 	}
 	return strings.Join(args, " ")
 }
 
-func (cp *COPY_INST) Parse(s string) {
+func (cp *COPY_INST) Parse(s string) error {
 	form, cut_form, ok := strings.Cut(s, ARG_SEPARATER)
 	if !ok {
-		slog.Error(fmt.Sprintf("no form found. '%s'", s))
-		os.Exit(1)
+		return fmt.Errorf("no form found. '%s'", s)
+	}
+	if err := checkForm(form); err != nil {
+		return err
 	}
 	cp.form = form
 
-	sep, cut_sep := separatorCheck(cut_form)
+	sep, cut_sep, err := separatorCheck(cut_form)
+	if err != nil {
+		return err
+	}
 
 	option, cut_option, ok := strings.Cut(cut_sep, ARG_SEPARATER)
 	if !ok {
-		slog.Error(fmt.Sprintf("no option found. '%s'", cut_sep))
-		os.Exit(1)
+		return fmt.Errorf("no option found. '%s'", cut_sep)
+	}
+	if option != "" {
+		cp.options = strings.Split(option, sep)
 	}
-	cp.options = strings.Split(option, sep)
 
 	src, dest, ok := strings.Cut(cut_option, ARG_SEPARATER)
 	if !ok {
-		slog.Error(fmt.Sprintf("no src found. '%s'", cut_option))
-		os.Exit(1)
+		return fmt.Errorf("no src found. '%s'", cut_option)
 	}
 	cp.src = strings.Split(src, sep)
 	cp.dest = dest
+	return cp.check(s)
 }
 
-func (cp *COPY_INST) ParseShort(s string) {
-	sep, cut_sep := separatorCheck(s)
+func (cp *COPY_INST) ParseShort(s string) error {
+	sep, cut_sep, err := separatorCheck(s)
+	if err != nil {
+		return err
+	}
 
 	src, cut_src, found := strings.Cut(cut_sep, ARG_SEPARATER)
 	if !found {
-		slog.Error(fmt.Sprintf("no src found. '%s'", cut_sep))
-		os.Exit(1)
+		return fmt.Errorf("no src found. '%s'", cut_sep)
 	}
 
 	dest, option, found := strings.Cut(cut_src, ARG_SEPARATER)
@@ -477,13 +646,25 @@ func (cp *COPY_INST) ParseShort(s string) {
 	}
 
 	cp.form = "shell"
-	cp.options = strings.Split(option, sep)
+	if option != "" {
+		cp.options = strings.Split(option, sep)
+	}
 	cp.src = strings.Split(src, sep)
 	cp.dest = dest
+	return cp.check(s)
+}
+
+// check returns an error if any path is empty or spans lines.
+func (cp COPY_INST) check(s string) error {
+	if cp.dest == "" || slices.Contains(cp.src, "") {
+		return fmt.Errorf("no src or dest found. '%s'", s)
+	}
+	return checkLine("COPY", strings.Join(append(slices.Clone(cp.options), cp.src...), " "))
 }
 
 // WORKDIR
 type WORKDIR_INST struct {
+	source
 	workingdirectory string
 }
 
@@ -492,18 +673,26 @@ func (wd WORKDIR_INST) Build() string {
 	return strings.Join(args, " ")
 }
 
-func (wd *WORKDIR_INST) Parse(s string) {
+func (wd *WORKDIR_INST) Parse(s string) error {
+	if strings.TrimSpace(s) == "" {
+		return fmt.Errorf("invalid WORKDIR '%s'", s)
+	}
+	if err := checkLine("WORKDIR", s); err != nil {
+		return err
+	}
 	wd.workingdirectory = s
+	return nil
 }
 
-func (wd *WORKDIR_INST) ParseShort(s string) {
-	wd.Parse(s)
+func (wd *WORKDIR_INST) ParseShort(s string) error {
+	return wd.Parse(s)
 }
 
 // ENV
 type ENV_INST struct {
+	source
 	keys   []string
-	values []string
+	values []string // quoted when built (see dockerfileWord)
 }
 
 func (env ENV_INST) Build() string {
@@ -513,105 +702,549 @@ func (env ENV_INST) Build() string {
 		os.Exit(1)
 	}
 	for i, k := range env.keys {
-		args = append(args, fmt.Sprintf("%s=%s", k, env.values[i]))
+		args = append(args, fmt.Sprintf("%s=%s", k, dockerfileWord(env.values[i])))
 	}
 
 	return strings.Join(args, " ")
 }
 
-func (env *ENV_INST) Parse(s string) {
-	kv_pairs := strings.Split(s, ARG_SEPARATER)
-	if len(kv_pairs) == 0 {
-		slog.Error("invalid ENV")
-		os.Exit(1)
+func (env *ENV_INST) Parse(s string) error {
+	keys, values, err := parseKeyValuePairs(s)
+	if err != nil {
+		return err
 	}
-	for _, pair := range kv_pairs {
+	if err := checkKeyValues("ENV", keys, values); err != nil {
+		return err
+	}
+	env.keys = append(env.keys, keys...)
+	env.values = append(env.values, values...)
+	return nil
+}
+
+func (env *ENV_INST) ParseShort(s string) error {
+	return env.Parse(s)
+}
+
+// parseKeyValuePairs parses "<key>=<value>[:<key>=<value>...]" of ENV and LABEL.
+func parseKeyValuePairs(s string) ([]string, []string, error) {
+	var keys, values []string
+	for _, pair := range strings.Split(s, ARG_SEPARATER) {
 		key, value, found := strings.Cut(pair, "=")
 		if !found {
-			slog.Error(fmt.Sprintf("invalid key-value pair '%s'", pair))
-			os.Exit(1)
+			return nil, nil, fmt.Errorf("invalid key-value pair '%s'", pair)
 		}
-		env.keys = append(env.keys, key)
-		env.values = append(env.values, value)
+		keys = append(keys, key)
+		values = append(values, value)
 	}
-}
-
-func (env *ENV_INST) ParseShort(s string) {
-	env.Parse(s)
+	return keys, values, nil
 }
 
 // VOLUME
 type VOLUMNE_INST struct {
+	source
 	volumes []string
 }
 
 func (volume VOLUMNE_INST) Build() string {
 	args := []string{"VOLUME"}
 
-	out, _ := json.Marshal(volume.volumes)
-	args = append(args, string(out))
+	args = append(args, jsonArray(volume.volumes))
 
 	return strings.Join(args, " ")
 }
 
-func (volume *VOLUMNE_INST) Parse(s string) {
+func (volume *VOLUMNE_INST) Parse(s string) error {
 	volumes := strings.Split(s, ARG_SEPARATER)
-	if len(volumes) == 0 {
-		slog.Error("invalid VOLUME")
-		os.Exit(1)
+	if err := checkList("VOLUME", volumes); err != nil {
+		return err
 	}
 	volume.volumes = volumes
+	return nil
 }
 
-func (volume *VOLUMNE_INST) ParseShort(s string) {
-	volume.Parse(s)
+// checkList returns an error if the list of VOLUME or SHELL is empty or has an empty value.
+func checkList(kind string, list []string) error {
+	if len(list) == 0 || slices.Contains(list, "") {
+		return fmt.Errorf("invalid %s '%s': empty value", kind, strings.Join(list, ","))
+	}
+	return nil
+}
+
+func (volume *VOLUMNE_INST) ParseShort(s string) error {
+	return volume.Parse(s)
 }
 
 // LABEL
 type LABEL_INST struct {
-	labels []string
+	source
+	keys   []string // quoted when built as well as the values (see dockerfileWord)
+	values []string
 }
 
 func (label LABEL_INST) Build() string {
 	args := []string{"LABEL"}
 
-	args = append(args, label.labels...)
+	for i, k := range label.keys {
+		args = append(args, fmt.Sprintf("%s=%s", dockerfileWord(k), dockerfileWord(label.values[i])))
+	}
 
 	return strings.Join(args, " ")
 }
 
-func (label *LABEL_INST) Parse(s string) {
-	kv_pairs := strings.Split(s, ARG_SEPARATER)
-	if len(kv_pairs) == 0 {
-		slog.Error("invalid LABEL")
-		os.Exit(1)
+func (label *LABEL_INST) Parse(s string) error {
+	keys, values, err := parseKeyValuePairs(s)
+	if err != nil {
+		return err
 	}
-	for _, pair := range kv_pairs {
-		key, value, found := strings.Cut(pair, "=")
-		if !found {
-			slog.Error(fmt.Sprintf("invalid key-value pair '%s'", pair))
-			os.Exit(1)
+	if err := checkKeyValues("LABEL", keys, values); err != nil {
+		return err
+	}
+	label.keys = append(label.keys, keys...)
+	label.values = append(label.values, values...)
+	return nil
+}
+
+func (label *LABEL_INST) ParseShort(s string) error {
+	return label.Parse(s)
+}
+
+// lookup returns the value of the label.
+func (label LABEL_INST) lookup(key string) (string, bool) {
+	i := slices.Index(label.keys, key)
+	if i < 0 {
+		return "", false
+	}
+	return label.values[i], true
+}
+
+// ARG
+type ARG_INST struct {
+	source
+	args []string // name or name=default (the default is quoted when built)
+}
+
+func (arg ARG_INST) Build() string {
+	args := []string{"ARG"}
+	for _, v := range arg.args {
+		if name, def, found := strings.Cut(v, "="); found {
+			v = name + "=" + dockerfileWord(def)
+		}
+		args = append(args, v)
+	}
+	return strings.Join(args, " ")
+}
+
+func (arg *ARG_INST) Parse(s string) error {
+	args := strings.Split(s, ARG_SEPARATER)
+	if err := checkArgs(args); err != nil {
+		return err
+	}
+	arg.args = append(arg.args, args...)
+	return nil
+}
+
+// checkArgs checks the names of ARG (name or name=default).
+func checkArgs(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("invalid ARG: no name")
+	}
+	for _, v := range args {
+		name, _, _ := strings.Cut(v, "=")
+		if name == "" || strings.ContainsAny(name, " \t\"'\\$") {
+			return fmt.Errorf("invalid ARG '%s'", v)
+		}
+		if err := checkLine("ARG", v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (arg *ARG_INST) ParseShort(s string) error {
+	return arg.Parse(s)
+}
+
+// CMD
+type CMD_INST RUN_INST
+
+func (c CMD_INST) Build() string {
+	return commonBuild("CMD", c.form, c.command)
+}
+
+func (c *CMD_INST) Parse(s string) error {
+	return (*ENTRYPOINT_INST)(c).Parse(s)
+}
+
+func (c *CMD_INST) ParseShort(s string) error {
+	return (*ENTRYPOINT_INST)(c).ParseShort(s)
+}
+
+// ADD
+type ADD_INST COPY_INST
+
+func (add ADD_INST) Build() string {
+	return strings.Replace(COPY_INST(add).Build(), "COPY", "ADD", 1)
+}
+
+func (add *ADD_INST) Parse(s string) error {
+	return (*COPY_INST)(add).Parse(s)
+}
+
+func (add *ADD_INST) ParseShort(s string) error {
+	return (*COPY_INST)(add).ParseShort(s)
+}
+
+// USER
+type USER_INST struct {
+	source
+	user  string
+	group string
+}
+
+func (user USER_INST) Build() string {
+	if user.group == "" {
+		return strings.Join([]string{"USER", user.user}, " ")
+	}
+	return fmt.Sprintf("USER %s:%s", user.user, user.group)
+}
+
+func (user *USER_INST) Parse(s string) error {
+	u, g, _ := strings.Cut(s, ARG_SEPARATER)
+	if err := checkUser(u, g); err != nil {
+		return err
+	}
+	user.user = u
+	user.group = g
+	return nil
+}
+
+func (user *USER_INST) ParseShort(s string) error {
+	return user.Parse(s)
+}
+
+// checkUser checks the user and the group (optional) of USER.
+func checkUser(user, group string) error {
+	if user == "" || strings.ContainsAny(user+group, " \t\r\n") {
+		if group != "" {
+			user += ":" + group
+		}
+		return fmt.Errorf("invalid USER '%s'", user)
+	}
+	return nil
+}
+
+// EXPOSE
+type EXPOSE_INST struct {
+	source
+	ports []string // port[/protocol]
+}
+
+func (expose EXPOSE_INST) Build() string {
+	args := []string{"EXPOSE"}
+	args = append(args, expose.ports...)
+	return strings.Join(args, " ")
+}
+
+func (expose *EXPOSE_INST) Parse(s string) error {
+	ports := strings.Split(s, ARG_SEPARATER)
+	if err := checkPorts(ports); err != nil {
+		return err
+	}
+	expose.ports = ports
+	return nil
+}
+
+// checkPorts checks the ports of EXPOSE (port[/protocol]).
+func checkPorts(ports []string) error {
+	if len(ports) == 0 {
+		return fmt.Errorf("invalid EXPOSE: no port")
+	}
+	for _, port := range ports {
+		if port == "" || strings.ContainsAny(port, " \t\r\n") {
+			return fmt.Errorf("invalid EXPOSE '%s'", port)
 		}
-		label.labels = append(label.labels, fmt.Sprintf("%s=%s", key, value))
 	}
+	return nil
 }
 
-func (label *LABEL_INST) ParseShort(s string) {
-	label.Parse(s)
+func (expose *EXPOSE_INST) ParseShort(s string) error {
+	return expose.Parse(s)
+}
+
+// SHELL (exec form only)
+type SHELL_INST struct {
+	source
+	command []string
+}
+
+func (shell SHELL_INST) Build() string {
+	return commonBuild("SHELL", "exec", shell.command)
+}
+
+func (shell *SHELL_INST) Parse(s string) error {
+	sep, rest, err := separatorCheck(s)
+	if err != nil {
+		return err
+	}
+	if rest == "" {
+		return fmt.Errorf("invalid SHELL '%s'", s)
+	}
+	command := strings.Split(rest, sep)
+	if err := checkList("SHELL", command); err != nil {
+		return err
+	}
+	shell.command = command
+	return nil
+}
+
+func (shell *SHELL_INST) ParseShort(s string) error {
+	return shell.Parse(s)
+}
+
+// HEALTHCHECK
+type HEALTHCHECK_INST struct {
+	source
+	none    bool // HEALTHCHECK NONE
+	options []string
+	form    string
+	command []string
+}
+
+func (hc HEALTHCHECK_INST) Build() string {
+	args := []string{"HEALTHCHECK"}
+	if hc.none {
+		return strings.Join(append(args, "NONE"), " ")
+	}
+	args = append(args, hc.options...)
+	args = append(args, commonBuild("CMD", hc.form, hc.command))
+	return strings.Join(args, " ")
+}
+
+func (hc *HEALTHCHECK_INST) Parse(s string) error {
+	if strings.EqualFold(s, "none") {
+		hc.none = true
+		return nil
+	}
+	form, cut_form, ok := strings.Cut(s, ARG_SEPARATER)
+	if !ok {
+		return fmt.Errorf("no form found. '%s'", s)
+	}
+	if err := checkForm(form); err != nil {
+		return err
+	}
+	hc.form = form
+
+	sep, cut_sep, err := separatorCheck(cut_form)
+	if err != nil {
+		return err
+	}
+	option, command, ok := strings.Cut(cut_sep, ARG_SEPARATER)
+	if !ok {
+		return fmt.Errorf("no option found. '%s'", cut_sep)
+	}
+	if option != "" {
+		hc.options = strings.Split(option, sep)
+	}
+	hc.command = strings.Split(command, sep)
+	return checkCommand(hc.command, s)
+}
+
+func (hc *HEALTHCHECK_INST) ParseShort(s string) error {
+	if strings.EqualFold(s, "none") {
+		hc.none = true
+		return nil
+	}
+	sep, cut_sep, err := separatorCheck(s)
+	if err != nil {
+		return err
+	}
+	command, option, found := strings.Cut(cut_sep, ARG_SEPARATER)
+	hc.form = "shell"
+	hc.command = strings.Split(command, sep)
+	if found && option != "" {
+		hc.options = strings.Split(option, ",")
+	}
+	return checkCommand(hc.command, s)
+}
+
+// STOPSIGNAL
+type STOPSIGNAL_INST struct {
+	source
+	signal string
+}
+
+func (stop STOPSIGNAL_INST) Build() string {
+	return strings.Join([]string{"STOPSIGNAL", stop.signal}, " ")
+}
+
+func (stop *STOPSIGNAL_INST) Parse(s string) error {
+	if err := checkSignal(s); err != nil {
+		return err
+	}
+	stop.signal = s
+	return nil
+}
+
+// checkSignal checks the signal of STOPSIGNAL (e.g. SIGTERM or 9).
+func checkSignal(s string) error {
+	if s == "" || strings.ContainsAny(s, " \t\r\n") {
+		return fmt.Errorf("invalid STOPSIGNAL '%s'", s)
+	}
+	return nil
+}
+
+func (stop *STOPSIGNAL_INST) ParseShort(s string) error {
+	return stop.Parse(s)
+}
+
+// ONBUILD
+type ONBUILD_INST struct {
+	source
+	inst InstructionBuilder // instruction triggered in the child image
+}
+
+func (onbuild ONBUILD_INST) Build() string {
+	return strings.Join([]string{"ONBUILD", onbuild.inst.Build()}, " ")
+}
+
+// Parse parses the trigger instruction in the same syntax of --inst (e.g. "RUN:shell:,:make" or "run:,:make").
+func (onbuild *ONBUILD_INST) Parse(s string) error {
+	inst_type, rest, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("no instruction type found, '%s'", s)
+	}
+	inst, short, ok := newInstructionBuilder(inst_type)
+	if !ok {
+		return fmt.Errorf("invalid type '%s'", inst_type)
+	}
+	switch inst.(type) {
+	case *FROM_INST, *ONBUILD_INST, *COMMENT, *BLANK:
+		return fmt.Errorf("%T is disallowed in ONBUILD", inst)
+	}
+	parse := inst.Parse
+	if short {
+		parse = inst.ParseShort
+	}
+	if err := parse(rest); err != nil {
+		return err
+	}
+	onbuild.inst = inst
+	return nil
+}
+
+func (onbuild *ONBUILD_INST) ParseShort(s string) error {
+	return onbuild.Parse(s)
+}
+
+// newInstructionBuilder returns an empty instruction of the --inst type,
+// and whether the type uses the short-hand parser.
+func newInstructionBuilder(inst_type string) (InstructionBuilder, bool, bool) {
+	switch inst_type {
+	// use full parser
+	case "FROM":
+		return &FROM_INST{}, false, true
+	case "ARG":
+		return &ARG_INST{}, false, true
+	case "RUN":
+		return &RUN_INST{}, false, true
+	case "CMD":
+		return &CMD_INST{}, false, true
+	case "ENTRYPOINT":
+		return &ENTRYPOINT_INST{}, false, true
+	case "COPY":
+		return &COPY_INST{}, false, true
+	case "ADD":
+		return &ADD_INST{}, false, true
+	case "WORKDIR":
+		return &WORKDIR_INST{}, false, true
+	case "ENV":
+		return &ENV_INST{}, false, true
+	case "VOLUME":
+		return &VOLUMNE_INST{}, false, true
+	case "LABEL":
+		return &LABEL_INST{}, false, true
+	case "USER":
+		return &USER_INST{}, false, true
+	case "EXPOSE":
+		return &EXPOSE_INST{}, false, true
+	case "SHELL":
+		return &SHELL_INST{}, false, true
+	case "HEALTHCHECK":
+		return &HEALTHCHECK_INST{}, false, true
+	case "STOPSIGNAL":
+		return &STOPSIGNAL_INST{}, false, true
+	case "ONBUILD":
+		return &ONBUILD_INST{}, false, true
+	// use short-hand parser
+	case "lab":
+		return &LABEL_INST{}, true, true
+	case "from":
+		return &FROM_INST{}, true, true
+	case "arg":
+		return &ARG_INST{}, true, true
+	case "run":
+		return &RUN_INST{}, true, true
+	case "cmd":
+		return &CMD_INST{}, true, true
+	case "ep":
+		return &ENTRYPOINT_INST{}, true, true
+	case "cp":
+		return &COPY_INST{}, true, true
+	case "add":
+		return &ADD_INST{}, true, true
+	case "wd":
+		return &WORKDIR_INST{}, true, true
+	case "env":
+		return &ENV_INST{}, true, true
+	case "vol":
+		return &VOLUMNE_INST{}, true, true
+	case "user":
+		return &USER_INST{}, true, true
+	case "expose":
+		return &EXPOSE_INST{}, true, true
+	case "shell":
+		return &SHELL_INST{}, true, true
+	case "hc":
+		return &HEALTHCHECK_INST{}, true, true
+	case "stop":
+		return &STOPSIGNAL_INST{}, true, true
+	case "onbuild":
+		return &ONBUILD_INST{}, true, true
+	case "cm":
+		return &COMMENT{}, false, true
+	case "bl":
+		return &BLANK{}, false, true
+	case "apt":
+		return &APT_INSTALL{}, false, true
+	case "pip", "conda", "micromamba", "r", "apk", "dnf":
+		return &PKG_INSTALL{manager: inst_type}, false, true
+	}
+	return nil, false, false
 }
 
 // Utility functions
-func separatorCheck(s string) (sep string, rest string) {
+func separatorCheck(s string) (sep string, rest string, err error) {
 	sep, rest, found := strings.Cut(s, ARG_SEPARATER)
 	if !found {
-		slog.Error(fmt.Sprintf("no separator found. '%s'", s))
-		os.Exit(1)
+		return "", "", fmt.Errorf("no separator found. '%s'", s)
 	}
 	// default separator
 	if sep == "" {
 		sep = ","
 	}
-	return sep, rest
+	return sep, rest, nil
+}
+
+// optionCheck splits the options of RUN following the operator (e.g. "&&:--network=none").
+func optionCheck(s string, sep string) (op string, options []string) {
+	op, opts, found := strings.Cut(s, ARG_SEPARATER)
+	if op == "" {
+		op = "&&"
+	}
+	if found && opts != "" {
+		options = strings.Split(opts, sep)
+	}
+	return op, options
 }
 
 func operatorCheck(s string) (string, op string) {
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// modes of `gdocker dev dockerfile` (create writes a new Dockerfile, the others edit the existing one)
+var DOCKERFILE_MODES = []string{"create", "append", "insert-after", "replace", "remove", "apt"}
+
+// instructionMatcher selects instructions by the keyword and/or a regular expression
+// of the text ("<KEYWORD>[~<regexp>]" or "~<regexp>", e.g. "COPY", "RUN~apt-get").
+type instructionMatcher struct {
+	keyword string
+	re      *regexp.Regexp
+}
+
+func parseInstructionMatcher(s string) (instructionMatcher, error) {
+	var m instructionMatcher
+	keyword, expr, found := strings.Cut(s, "~")
+	m.keyword = strings.ToUpper(strings.TrimSpace(keyword))
+	if found {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return m, fmt.Errorf("invalid match '%s': %w", s, err)
+		}
+		m.re = re
+	}
+	if m.keyword == "" && m.re == nil {
+		return m, fmt.Errorf("invalid match '%s' (<KEYWORD>[~<regexp>] or ~<regexp>)", s)
+	}
+	if m.keyword != "" && m.keyword != "#" && !slices.Contains(DOCKERFILE_INSTRUCTIONS, m.keyword) {
+		return m, fmt.Errorf("invalid match '%s': unknown instruction '%s'", s, keyword)
+	}
+	return m, nil
+}
+
+// Match reports whether the instruction matches. Comments have the keyword "#".
+func (m instructionMatcher) Match(inst InstructionBuilder) bool {
+	text := render(inst)
+	if m.keyword != "" && m.keyword != instructionKeyword(text) {
+		return false
+	}
+	return m.re == nil || m.re.MatchString(text)
+}
+
+// instructionKeyword returns the keyword of the text of an instruction in upper case.
+func instructionKeyword(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return ""
+	}
+	if strings.HasPrefix(fields[0], "#") {
+		return "#"
+	}
+	return strings.ToUpper(fields[0])
+}
+
+// EditMatches replaces each instruction matching in every stage with the result of the edit,
+// and returns the number of the matches. FROM can not be replaced (the stages are kept).
+func (d *Dockerfile) EditMatches(m instructionMatcher, edit func(inst InstructionBuilder) []InstructionBuilder) (int, error) {
+	n := 0
+	for i := range d.stages {
+		var new []InstructionBuilder
+		for _, inst := range d.stages[i].Instructions {
+			if !m.Match(inst) {
+				new = append(new, inst)
+				continue
+			}
+			n++
+			edited := edit(inst)
+			if _, isFrom := inst.(*FROM_INST); isFrom && (len(edited) == 0 || edited[0] != inst) {
+				return n, fmt.Errorf("FROM can not be replaced or removed")
+			}
+			new = append(new, edited...)
+		}
+		d.stages[i].Instructions = new
+	}
+	return n, nil
+}
+
+// Append adds the instructions at the end of the last stage. If the stage ends with the
+// footer of gdocker (LABEL com.gdocker.version, WORKDIR and ENTRYPOINT), they are added before it.
+func (d *Dockerfile) Append(insts []InstructionBuilder) {
+	stage := d.LastStage()
+	at := stage.footerIndex()
+	stage.Instructions = slices.Concat(stage.Instructions[:at], insts, stage.Instructions[at:])
+}
+
+// footerIndex returns the index where the footer of gdocker starts (the length if none).
+func (s *Stage) footerIndex() int {
+	at := len(s.Instructions)
+	for i := len(s.Instructions) - 1; i >= 0; i-- {
+		switch v := s.Instructions[i].(type) {
+		case *BLANK, *WORKDIR_INST, *ENTRYPOINT_INST, *CMD_INST:
+			continue
+		case *LABEL_INST:
+			if _, ok := v.lookup("com.gdocker.version"); ok {
+				at = i
+				// and the blank line before it
+				if i > 0 {
+					if _, ok := s.Instructions[i-1].(*BLANK); ok {
+						at = i - 1
+					}
+				}
+			}
+		}
+		break
+	}
+	return at
+}
+
+// AddAptPackages adds the packages to an apt install (the first one in the final stage,
+// or the first one matching). Packages already installed are skipped.
+// A parsed instruction is edited in its text, so the layout is kept.
+// It returns the packages added.
+func (d *Dockerfile) AddAptPackages(pkgs []string, m *instructionMatcher) ([]string, error) {
+	insts := d.instructions()
+	if m == nil {
+		if stage := d.finalStage(); stage != nil {
+			insts = stage.Instructions
+		}
+	}
+	for _, inst := range insts {
+		if m != nil && !m.Match(inst) {
+			continue
+		}
+		switch v := inst.(type) {
+		case *APT_INSTALL:
+			added := newAptPackages(v.command, pkgs)
+			for _, pkg := range added {
+				v.command = append(v.command, pkg, "\\")
+			}
+			return added, nil
+		case *RUN_INST, *OTHER_INST:
+			raw, line := inst.(sourced).Source()
+			if line == 0 || instructionKeyword(raw) != "RUN" || !reAptInstall.MatchString(raw) {
+				continue
+			}
+			escape := d.directives["escape"]
+			if escape == "" {
+				escape = "\\"
+			}
+			edited, added, err := addAptPackagesToText(raw, pkgs, escape)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			inst.(interface{ setSource(source) }).setSource(source{edited, line})
+			return added, nil
+		}
+	}
+	return nil, fmt.Errorf("no apt install found")
+}
+
+// newAptPackages returns the packages not installed yet. Packages are compared by
+// the names without the versions (e.g. curl and curl=8.5.0 are the same package).
+func newAptPackages(installed, pkgs []string) []string {
+	aptName := func(pkg string) string {
+		name, _, _ := strings.Cut(pkg, "=")
+		return name
+	}
+	var added []string
+	for _, pkg := range pkgs {
+		name := aptName(pkg)
+		has := func(p string) bool { return aptName(p) == name }
+		if !slices.ContainsFunc(installed, has) && !slices.ContainsFunc(added, has) {
+			added = append(added, pkg)
+		}
+	}
+	return added
+}
+
+// addAptPackagesToText adds the packages after the last package of `apt-get install` in the text
+// of a RUN. Packages written a line each get new lines of the same indent, and the others
+// are added in the line.
+func addAptPackagesToText(raw string, pkgs []string, escape string) (string, []string, error) {
+	lines := strings.Split(raw, "\n")
+	cmds := scanInstallCommands(lines, escape)
+	i := slices.IndexFunc(cmds, func(c installCommand) bool { return c.manager == "apt" })
+	if i < 0 {
+		return raw, nil, fmt.Errorf("no apt-get install found")
+	}
+	var installed []string
+	for _, pkg := range cmds[i].packages {
+		installed = append(installed, pkg.text)
+	}
+	last := cmds[i].install
+	if len(cmds[i].packages) > 0 {
+		last = cmds[i].packages[len(cmds[i].packages)-1]
+	}
+	lastLine, lastEnd, alone, continued := last.line, last.end, last.alone, last.continued
+
+	added := newAptPackages(installed, pkgs)
+	if len(added) == 0 {
+		return raw, nil, nil
+	}
+
+	if !alone {
+		line := lines[lastLine]
+		lines[lastLine] = line[:lastEnd] + " " + strings.Join(added, " ") + line[lastEnd:]
+		return strings.Join(lines, "\n"), added, nil
+	}
+	line := lines[lastLine]
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	var new []string
+	for _, pkg := range added {
+		new = append(new, indent+pkg+" "+escape)
+	}
+	if !continued {
+		// the last package ends the instruction
+		lines[lastLine] = strings.TrimRight(line, " \t") + " " + escape
+		new[len(new)-1] = strings.TrimSuffix(new[len(new)-1], " "+escape)
+	}
+	lines = slices.Insert(lines, lastLine+1, new...)
+	return strings.Join(lines, "\n"), added, nil
+}
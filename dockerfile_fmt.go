@@ -0,0 +1,352 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// FormatDockerfile returns the Dockerfile in the canonical style of gdocker:
+//   - keywords in upper case (and AS of FROM, the trigger of ONBUILD)
+//   - continued lines indented as commonBuild emits ("\t " and " \" at the end)
+//   - packages of apt and pip sorted and deduplicated (see sortInstallPackages)
+//   - no blank lines at the start and the end, no consecutive blank lines,
+//     and a blank line before each stage but the first
+//
+// Comments, heredocs and the lines in quoted strings are kept as they are written.
+func FormatDockerfile(d *Dockerfile) []byte {
+	escape := d.directives["escape"]
+	if escape == "" {
+		escape = "\\"
+	}
+
+	type item struct {
+		text           string
+		blank, comment bool
+		from           bool
+	}
+	var items []item
+	for _, stage := range d.stages {
+		for _, inst := range stage.Instructions {
+			switch inst.(type) {
+			case *BLANK:
+				items = append(items, item{blank: true})
+			case *COMMENT:
+				items = append(items, item{text: strings.TrimRight(render(inst), " \t\r"), comment: true})
+			default:
+				_, from := inst.(*FROM_INST)
+				items = append(items, item{text: formatInstruction(inst, escape), from: from})
+			}
+		}
+	}
+
+	// a blank line before each FROM but the first (and the comments right above it)
+	separated := make([]bool, len(items))
+	first := true
+	for i, it := range items {
+		if !it.from {
+			continue
+		}
+		if first {
+			first = false
+			continue
+		}
+		j := i
+		for j > 0 && items[j-1].comment {
+			j--
+		}
+		separated[j] = true
+	}
+
+	var lines []string
+	blank := false
+	for i, it := range items {
+		if it.blank {
+			blank = len(lines) > 0
+			continue
+		}
+		if (blank || separated[i]) && len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		blank = false
+		lines = append(lines, it.text)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// formatInstruction returns the text of the instruction in the canonical style.
+func formatInstruction(inst InstructionBuilder, escape string) string {
+	lines := strings.Split(render(inst), "\n")
+	lines[0] = formatKeywords(strings.TrimRight(lines[0], " \t\r"))
+
+	keyword := instructionKeyword(lines[0])
+	heredoc := false
+	switch v := inst.(type) {
+	case *RUN_INST:
+		heredoc = v.heredoc != ""
+	case *OTHER_INST:
+		heredoc = slices.Contains(HEREDOC_INSTRUCTIONS, keyword) && len(findHeredocs(v.args)) > 0
+	}
+	// the bodies of heredocs are kept
+	if heredoc {
+		return strings.Join(lines, "\n")
+	}
+
+	// the spaces at the start and the end of the lines in quoted strings are kept,
+	// as they are a part of the strings
+	formatted := []string{}
+	var quote byte
+	for i, line := range lines {
+		body := strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimSpace(body)
+		if i > 0 && strings.HasPrefix(trimmed, "#") {
+			formatted = append(formatted, "\t "+trimmed)
+			continue
+		}
+		cont := strings.HasSuffix(body, escape)
+		if cont {
+			body = strings.TrimSuffix(body, escape)
+		}
+		_, open := shellWords(body, quote)
+		if cont && open == 0 {
+			body = strings.TrimRight(body, " \t")
+		}
+		if i > 0 {
+			// blank lines and empty continued lines
+			if strings.TrimSpace(body) == "" {
+				continue
+			}
+			if quote == 0 {
+				body = "\t " + strings.TrimLeft(body, " \t")
+			}
+		}
+		if cont && open == 0 {
+			body += " " + escape
+		} else if cont {
+			body += escape
+		}
+		formatted = append(formatted, body)
+		quote = open
+	}
+	if keyword == "RUN" {
+		formatted = sortInstallPackages(formatted, escape)
+	}
+	return strings.Join(formatted, "\n")
+}
+
+// formatKeywords makes the keyword of the first line of an instruction upper case,
+// as well as AS of FROM and the trigger of ONBUILD.
+func formatKeywords(line string) string {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return line
+	}
+	keyword := strings.ToUpper(words[0])
+	rest := strings.TrimLeft(strings.TrimSpace(line)[len(words[0]):], " \t")
+	switch {
+	case keyword == "ONBUILD" && len(words) > 1:
+		rest = formatKeywords(rest)
+	case keyword == "FROM" && len(words) == 4 && strings.EqualFold(words[2], "AS"):
+		return strings.Join([]string{keyword, words[1], "AS", words[3]}, " ")
+	}
+	if rest == "" {
+		return keyword
+	}
+	return keyword + " " + rest
+}
+
+// installWord is a word of an install command in the lines of a RUN.
+type installWord struct {
+	text       string
+	line       int
+	start, end int  // offsets in the line
+	alone      bool // the only word of the line
+	continued  bool // the line is continued by the escape character
+}
+
+// installCommand is an install command of a package manager (e.g. apt-get install -y curl git).
+type installCommand struct {
+	manager  string // apt or pip
+	install  installWord
+	packages []installWord
+	sortable bool // no shell expansions in the packages
+}
+
+// commands and their package managers of scanInstallCommands
+var INSTALL_COMMANDS = map[string]string{"apt-get": "apt", "apt": "apt", "pip": "pip", "pip3": "pip"}
+
+// options taking the next word as the value
+var INSTALL_VALUE_OPTIONS = map[string][]string{
+	"apt": {"-o", "--option", "-t", "--target-release", "-c", "--config-file"},
+	"pip": {
+		"-i", "--index-url", "--extra-index-url", "-r", "--requirement", "-c", "--constraint",
+		"-e", "--editable", "-f", "--find-links", "-t", "--target", "--prefix", "--root", "--src",
+		"--trusted-host", "--platform", "--python-version", "--implementation", "--abi",
+		"--upgrade-strategy", "--progress-bar", "--cache-dir", "--log",
+	},
+}
+
+// scanInstallCommands returns the install commands of apt and pip in the lines of a RUN.
+func scanInstallCommands(lines []string, escape string) []installCommand {
+	var cmds []installCommand
+	var cur *installCommand
+	manager := ""
+	skip := false
+	var quote byte
+	finish := func() {
+		if cur != nil {
+			cmds = append(cmds, *cur)
+		}
+		cur, manager, skip = nil, "", false
+	}
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || (i > 0 && strings.HasPrefix(trimmed, "#")) {
+			continue
+		}
+		body := strings.TrimRight(line, " \t\r")
+		cont := strings.HasSuffix(body, escape)
+		body = strings.TrimSuffix(body, escape)
+		var locs [][2]int
+		locs, quote = shellWords(body, quote)
+		for _, loc := range locs {
+			w := installWord{text: body[loc[0]:loc[1]], line: i, start: loc[0], end: loc[1], continued: cont}
+			if cur == nil {
+				switch {
+				case INSTALL_COMMANDS[w.text] != "":
+					manager = INSTALL_COMMANDS[w.text]
+				case manager != "" && w.text == "install":
+					cur = &installCommand{manager: manager, install: w, sortable: true}
+				case manager != "" && strings.HasPrefix(w.text, "-"):
+				default:
+					manager = ""
+				}
+				continue
+			}
+			switch {
+			case skip:
+				skip = false
+				continue
+			case isShellOperator(w.text):
+				finish()
+				continue
+			case strings.HasPrefix(w.text, "-"):
+				skip = !strings.Contains(w.text, "=") && slices.Contains(INSTALL_VALUE_OPTIONS[cur.manager], w.text)
+				continue
+			}
+			name, end := strings.CutSuffix(w.text, ";")
+			if name != "" {
+				w.text, w.end = name, w.start+len(name)
+				w.alone = strings.TrimSpace(body) == name
+				// quoted or escaped words are kept as they are written
+				if strings.ContainsAny(name, "$`(){}*?\"'\\") || strings.Contains(name, escape) {
+					cur.sortable = false
+				}
+				cur.packages = append(cur.packages, w)
+			}
+			if end {
+				finish()
+			}
+		}
+	}
+	finish()
+	return cmds
+}
+
+// shellWords returns the offsets of the words of the line, which are split at blanks
+// outside quotes. quote is the quote open at the start of the line (0 if none),
+// and the quote open at the end of the line is returned.
+func shellWords(line string, quote byte) ([][2]int, byte) {
+	var words [][2]int
+	start := -1
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if quote == 0 && (c == ' ' || c == '\t') {
+			if start >= 0 {
+				words = append(words, [2]int{start, i})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		switch {
+		case c == '\\' && quote != '\'':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		}
+	}
+	if start >= 0 {
+		words = append(words, [2]int{start, len(line)})
+	}
+	return words, quote
+}
+
+func isShellOperator(w string) bool {
+	switch w {
+	case "&&", "||", ";", "|", "&":
+		return true
+	}
+	for _, prefix := range []string{">", "<", "1>", "2>", "&>"} {
+		if strings.HasPrefix(w, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortInstallPackages sorts and deduplicates the packages of the install commands
+// written a package per line or in a line. Those in other layouts, and those with quotes,
+// escapes or shell expansions are kept.
+func sortInstallPackages(lines []string, escape string) []string {
+	cmds := scanInstallCommands(lines, escape)
+	for k := len(cmds) - 1; k >= 0; k-- {
+		c := cmds[k]
+		if !c.sortable || len(c.packages) < 2 {
+			continue
+		}
+		var texts []string
+		for _, pkg := range c.packages {
+			texts = append(texts, pkg.text)
+		}
+		sorted := slices.Clone(texts)
+		slices.SortStableFunc(sorted, func(a, b string) int {
+			return cmp.Or(strings.Compare(strings.ToLower(a), strings.ToLower(b)), strings.Compare(a, b))
+		})
+		sorted = slices.Compact(sorted)
+		if slices.Equal(sorted, texts) {
+			continue
+		}
+
+		first, last := c.packages[0], c.packages[len(c.packages)-1]
+		perLine := true
+		for j, pkg := range c.packages {
+			perLine = perLine && pkg.alone && pkg.line == first.line+j
+		}
+		switch {
+		case perLine:
+			line := lines[first.line]
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			var new []string
+			for j, pkg := range sorted {
+				l := indent + pkg
+				if j < len(sorted)-1 || last.continued {
+					l += " " + escape
+				}
+				new = append(new, l)
+			}
+			lines = slices.Replace(lines, first.line, last.line+1, new...)
+		case first.line == last.line && slices.Equal(strings.Fields(lines[first.line][first.start:last.end]), texts):
+			line := lines[first.line]
+			lines[first.line] = line[:first.start] + strings.Join(sorted, " ") + line[last.end:]
+		}
+	}
+	return lines
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatDockerfile(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			name: "sorted packages",
+			in:   "FROM ubuntu\nRUN apt-get install -y c b a b\n",
+			want: "FROM ubuntu\nRUN apt-get install -y a b c\n",
+		},
+		{
+			name: "quoted apt packages are kept",
+			in:   "FROM ubuntu\nRUN apt-get install -y \"b c\" a\n",
+			want: "FROM ubuntu\nRUN apt-get install -y \"b c\" a\n",
+		},
+		{
+			name: "quoted pip packages are kept",
+			in:   "FROM ubuntu\nRUN pip install zlib \"x y\" abc\n",
+			want: "FROM ubuntu\nRUN pip install zlib \"x y\" abc\n",
+		},
+		{
+			name: "quoted packages a line each are kept",
+			in:   "FROM ubuntu\nRUN apt-get install -y \\\n  z \\\n  'y x' \\\n  a\n",
+			want: "FROM ubuntu\nRUN apt-get install -y \\\n\t z \\\n\t 'y x' \\\n\t a\n",
+		},
+		{
+			name: "operators in quotes do not end the command",
+			in:   "FROM ubuntu\nRUN sh -c \"echo a && b\" && apt-get install -y c b\n",
+			want: "FROM ubuntu\nRUN sh -c \"echo a && b\" && apt-get install -y b c\n",
+		},
+		{
+			name: "continued lines in quotes are kept",
+			in:   "FROM ubuntu\nRUN echo \"hello   \\\n      world\"  \\\n   && true\n",
+			want: "FROM ubuntu\nRUN echo \"hello   \\\n      world\" \\\n\t && true\n",
+		},
+		{
+			name: "escape directive",
+			in:   "# escape=`\nFROM ubuntu\nRUN echo 'a  `\n  b' `\n  && apt-get install -y c b\n",
+			want: "# escape=`\nFROM ubuntu\nRUN echo 'a  `\n  b' `\n\t && apt-get install -y b c\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := ParseDockerfile(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(FormatDockerfile(&d)); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// LintRule is a check run over the Dockerfiles by `gdocker dev lint`.
+type LintRule struct {
+	ID    string
+	Name  string
+	Usage string
+	check func(t lintTarget) []LintFinding
+}
+
+// LintFinding is a problem found by a rule.
+type LintFinding struct {
+	Rule string // rule ID
+	File string
+	Line int // 0 if the problem is not on a line
+	Msg  string
+}
+
+func (f LintFinding) String() string {
+	if f.Line == 0 {
+		return fmt.Sprintf("%s: %s %s", f.File, f.Rule, f.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s %s", f.File, f.Line, f.Rule, f.Msg)
+}
+
+// lintTarget is a Dockerfile of a tag in an image build directory.
+type lintTarget struct {
+	file      string // path to the Dockerfile (as shown in findings)
+	context   string // build context (the tag directory)
+	tag       string
+	d         Dockerfile
+	resources []string // resources made by the Makefile (see readMakeResources)
+}
+
+// LINT_RULES are the rules of `gdocker dev lint` in order of the ID.
+var LINT_RULES = []LintRule{
+	{"GD001", "apt-lists", "apt-get install without removing /var/lib/apt/lists/* in the same RUN", lintAptLists},
+	{"GD002", "latest-parent", "FROM a floating parent image (no tag or \"latest\")", lintLatestParent},
+	{"GD003", "gdocker-label", "the final stage has no com.gdocker.version label", lintGdockerLabel},
+	{"GD004", "copy-source", "a COPY/ADD source is not found in the build context", lintCopySource},
+	{"GD005", "add-url", "ADD of a remote URL", lintAddURL},
+	{"GD006", "entrypoint", "the ENTRYPOINT differs from the preset", lintEntrypoint},
+}
+
+// lookupLintRule returns the rule of the ID or the name.
+func lookupLintRule(s string) (LintRule, bool) {
+	for _, rule := range LINT_RULES {
+		if strings.EqualFold(s, rule.ID) || s == rule.Name {
+			return rule, true
+		}
+	}
+	return LintRule{}, false
+}
+
+// lintDockerfile runs the rules which are not disabled (by IDs or names).
+func lintDockerfile(t lintTarget, disabled []string) []LintFinding {
+	var findings []LintFinding
+	for _, rule := range LINT_RULES {
+		if slices.ContainsFunc(disabled, func(s string) bool { r, _ := lookupLintRule(s); return r.ID == rule.ID }) {
+			continue
+		}
+		for _, f := range rule.check(t) {
+			f.Rule, f.File = rule.ID, t.file
+			findings = append(findings, f)
+		}
+	}
+	slices.SortStableFunc(findings, func(a, b LintFinding) int { return a.Line - b.Line })
+	return findings
+}
+
+// instructions returns the instructions of all stages.
+func (d *Dockerfile) instructions() []InstructionBuilder {
+	var insts []InstructionBuilder
+	for _, stage := range d.stages {
+		insts = append(insts, stage.Instructions...)
+	}
+	return insts
+}
+
+// finalStage returns the last stage which has FROM (nil if none).
+func (d *Dockerfile) finalStage() *Stage {
+	for i := len(d.stages) - 1; i >= 0; i-- {
+		if d.stages[i].containsFrom {
+			return &d.stages[i]
+		}
+	}
+	return nil
+}
+
+// lineOf returns the line number of a parsed instruction (0 if not parsed).
+func lineOf(inst InstructionBuilder) int {
+	if s, ok := inst.(sourced); ok {
+		_, line := s.Source()
+		return line
+	}
+	return 0
+}
+
+var (
+	reAptInstall = regexp.MustCompile(`\bapt(-get)?\s+(-\S+\s+)*install\b`)
+	reRemoteURL  = regexp.MustCompile(`^(https?://|git@)`)
+)
+
+func lintAptLists(t lintTarget) []LintFinding {
+	var findings []LintFinding
+	for _, inst := range t.d.instructions() {
+		switch v := inst.(type) {
+		case *RUN_INST:
+		case *OTHER_INST:
+			if v.keyword != "RUN" {
+				continue
+			}
+		default:
+			continue
+		}
+		// lists in the cache mount (e.g. --mount=type=cache,target=/var/lib/apt) are not in the image
+		text := render(inst)
+		if reAptInstall.MatchString(text) && !strings.Contains(text, "/var/lib/apt/lists") && !strings.Contains(text, "target=/var/lib/apt") {
+			findings = append(findings, LintFinding{Line: lineOf(inst), Msg: "apt-get install without `rm -rf /var/lib/apt/lists/*`"})
+		}
+	}
+	return findings
+}
+
+func lintLatestParent(t lintTarget) []LintFinding {
+	var findings []LintFinding
+	froms, images := t.d.baseFroms()
+	for i, from := range froms {
+		if strings.Contains(images[i], "@") {
+			continue
+		}
+		if _, tag := splitImageReference(images[i]); tag == "" || tag == "latest" {
+			findings = append(findings, LintFinding{Line: lineOf(from), Msg: fmt.Sprintf("parent image '%s' is not pinned to a tag", images[i])})
+		}
+	}
+	return findings
+}
+
+func lintGdockerLabel(t lintTarget) []LintFinding {
+	stage := t.d.finalStage()
+	if stage == nil {
+		return nil
+	}
+	line := 0
+	for _, inst := range stage.Instructions {
+		switch v := inst.(type) {
+		case *FROM_INST:
+			line = lineOf(v)
+		case *LABEL_INST:
+			if _, ok := v.lookup("com.gdocker.version"); ok {
+				return nil
+			}
+		}
+	}
+	return []LintFinding{{Line: line, Msg: "no `LABEL com.gdocker.version=...` in the final stage (the image is not treated as built by gdocker)"}}
+}
+
+func lintCopySource(t lintTarget) []LintFinding {
+	var findings []LintFinding
+	for _, inst := range t.d.instructions() {
+		var keyword string
+		var cp COPY_INST
+		switch v := inst.(type) {
+		case *COPY_INST:
+			keyword, cp = "COPY", *v
+		case *ADD_INST:
+			keyword, cp = "ADD", COPY_INST(*v)
+		default:
+			continue
+		}
+		// sources of other stages or images
+		if slices.ContainsFunc(cp.options, func(o string) bool { return strings.HasPrefix(o, "--from") }) {
+			continue
+		}
+		for _, src := range cp.src {
+			if strings.Contains(src, "$") || reRemoteURL.MatchString(src) {
+				continue
+			}
+			rel := filepath.Clean(src)
+			if rel == ".." || strings.HasPrefix(rel, "../") || filepath.IsAbs(rel) {
+				findings = append(findings, LintFinding{Line: lineOf(inst), Msg: fmt.Sprintf("%s source '%s' is outside the build context", keyword, src)})
+				continue
+			}
+			if matches, _ := filepath.Glob(filepath.Join(t.context, rel)); len(matches) > 0 {
+				continue
+			}
+			// made by the Makefile before building (e.g. cache/rush)
+			if res, ok := strings.CutPrefix(rel, "cache/"); ok && slices.Contains(t.resources, res) {
+				continue
+			}
+			findings = append(findings, LintFinding{Line: lineOf(inst), Msg: fmt.Sprintf("%s source '%s' is not found in '%s'", keyword, src, t.tag)})
+		}
+	}
+	return findings
+}
+
+func lintAddURL(t lintTarget) []LintFinding {
+	var findings []LintFinding
+	for _, inst := range t.d.instructions() {
+		add, ok := inst.(*ADD_INST)
+		if !ok {
+			continue
+		}
+		for _, src := range add.src {
+			if reRemoteURL.MatchString(src) {
+				findings = append(findings, LintFinding{Line: lineOf(inst), Msg: fmt.Sprintf("ADD of remote URL '%s' (download it as a Makefile resource to cache it)", src)})
+			}
+		}
+	}
+	return findings
+}
+
+func lintEntrypoint(t lintTarget) []LintFinding {
+	stage := t.d.finalStage()
+	if stage == nil {
+		return nil
+	}
+	var ep *ENTRYPOINT_INST
+	for _, inst := range stage.Instructions {
+		if v, ok := inst.(*ENTRYPOINT_INST); ok {
+			ep = v
+		}
+	}
+	// inherited from the parent image
+	if ep == nil {
+		return nil
+	}
+	if ep.form != "exec" || !slices.Equal(ep.command, PRESET_ENTRYPOINT) {
+		return []LintFinding{{Line: lineOf(ep), Msg: fmt.Sprintf("ENTRYPOINT differs from the preset %s", commonBuild("ENTRYPOINT", "exec", PRESET_ENTRYPOINT))}}
+	}
+	return nil
+}
@@ -0,0 +1,706 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// instructions of the Dockerfile reference
+var DOCKERFILE_INSTRUCTIONS = []string{
+	"ADD", "ARG", "CMD", "COPY", "ENTRYPOINT", "ENV", "EXPOSE", "FROM", "HEALTHCHECK",
+	"LABEL", "MAINTAINER", "ONBUILD", "RUN", "SHELL", "STOPSIGNAL", "USER", "VOLUME", "WORKDIR",
+}
+
+// instructions which can have heredocs (e.g. RUN <<EOF)
+var HEREDOC_INSTRUCTIONS = []string{"RUN", "COPY", "ADD"}
+
+var (
+	reDirective = regexp.MustCompile(`^#\s*([a-zA-Z][a-zA-Z0-9]*)\s*=\s*(.*?)\s*$`)
+	// the delimiter is a word, quoted or up to a blank or a redirect (e.g. <<EOF, <<-"END OF", <<a.txt>out)
+	reHeredoc = regexp.MustCompile(`<<(-?)(?:"([^"\n]+)"|'([^'\n]+)'|([^\s"'<>|&;]+))`)
+)
+
+// findHeredocs returns the indices of the submatches of reHeredoc in the text,
+// leaving out here-strings (<<<word).
+func findHeredocs(text string) [][]int {
+	var found [][]int
+	for _, m := range reHeredoc.FindAllStringSubmatchIndex(text, -1) {
+		if m[0] > 0 && text[m[0]-1] == '<' {
+			continue
+		}
+		found = append(found, m)
+	}
+	return found
+}
+
+// matchedHeredoc returns the delimiter of the heredoc matched by findHeredocs (without quotes),
+// and whether the leading tabs of the body are stripped (<<-).
+func matchedHeredoc(text string, m []int) (string, bool) {
+	strip := m[3] > m[2]
+	for g := 2; g <= 4; g++ {
+		if m[2*g] >= 0 {
+			return text[m[2*g]:m[2*g+1]], strip
+		}
+	}
+	return "", strip
+}
+
+// source is the original text of a parsed instruction.
+// An instruction with its source is rendered as is, so a parsed Dockerfile round-trips.
+type source struct {
+	raw  string // lines of the instruction (including continuations, comments and heredocs)
+	line int    // line number of the first line (1-based)
+}
+
+// Source returns the original text and the line number of the instruction (empty if not parsed).
+func (s source) Source() (string, int) {
+	return s.raw, s.line
+}
+
+type sourced interface {
+	Source() (string, int)
+}
+
+// render returns the original text of a parsed instruction, or builds the instruction.
+func render(inst InstructionBuilder) string {
+	if s, ok := inst.(sourced); ok {
+		if raw, line := s.Source(); line > 0 {
+			return raw
+		}
+	}
+	return inst.Build()
+}
+
+// renderEscaped returns the text of the instruction as render, but the built instruction uses
+// the escape character (e.g. ` of "# escape=`") for the continuations and the escapes of the values.
+// Heredocs are kept, as their bodies are not parsed by the builder.
+func renderEscaped(inst InstructionBuilder, escape string) string {
+	text := render(inst)
+	if escape == "" || escape == `\` {
+		return text
+	}
+	if s, ok := inst.(sourced); ok {
+		if _, line := s.Source(); line > 0 {
+			return text
+		}
+	}
+	switch v := inst.(type) {
+	case *ONBUILD_INST:
+		return "ONBUILD " + renderEscaped(v.inst, escape)
+	case *RUN_INST:
+		if v.heredoc != "" {
+			return text
+		}
+	case *ENV_INST, *LABEL_INST, *ARG_INST:
+		// the escapes of dockerfileWord (\\, \" and \$ in double quotes)
+		var b strings.Builder
+		for i := 0; i < len(text); i++ {
+			if text[i] == '\\' && i+1 < len(text) {
+				i++
+				if text[i] != '\\' {
+					b.WriteString(escape)
+				}
+			}
+			b.WriteByte(text[i])
+		}
+		return b.String()
+	}
+	return strings.ReplaceAll(text, "\\\n", escape+"\n")
+}
+
+// instruction which has no dedicated type (kept as the text after the keyword)
+type OTHER_INST struct {
+	source
+	keyword string
+	args    string
+}
+
+func (other OTHER_INST) Build() string {
+	return strings.Join([]string{other.keyword, other.args}, " ")
+}
+
+func (other *OTHER_INST) Parse(s string) error {
+	keyword, args, _ := strings.Cut(s, " ")
+	other.keyword = strings.ToUpper(keyword)
+	other.args = strings.TrimSpace(args)
+	return nil
+}
+
+func (other *OTHER_INST) ParseShort(s string) error {
+	return other.Parse(s)
+}
+
+// DockerfileParseError is returned when a Dockerfile can not be parsed.
+type DockerfileParseError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *DockerfileParseError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// readDockerfile parses the Dockerfile.
+func readDockerfile(file string) (Dockerfile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return Dockerfile{}, err
+	}
+	defer f.Close()
+	d, err := ParseDockerfile(f)
+	if pe, ok := err.(*DockerfileParseError); ok {
+		pe.File = file
+	}
+	return d, err
+}
+
+// logicalLine is an instruction joined from its physical lines.
+type logicalLine struct {
+	segments []string // physical lines without the escape characters (comment lines are dropped)
+	heredocs []string // bodies of heredocs
+	start    int      // index of the first line
+	end      int      // index of the last line
+}
+
+func (l logicalLine) text() string {
+	return strings.Join(l.segments, "")
+}
+
+// ParseDockerfile parses a Dockerfile into stages and instructions.
+// Parser directives, comments and blank lines are kept as COMMENT and BLANK, and every
+// instruction keeps its original text (see render). Instructions without a dedicated type
+// and those the type can not represent (e.g. RUN with flags or heredocs) are OTHER_INST.
+func ParseDockerfile(r io.Reader) (Dockerfile, error) {
+	d := Dockerfile{directives: make(map[string]string)}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return d, err
+	}
+	if len(b) == 0 {
+		return d, nil
+	}
+	lines := strings.Split(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		d.noFinalNewline = true
+	}
+
+	escape := "\\"
+	add := func(inst InstructionBuilder, keyword string, line int) error {
+		if keyword == "FROM" {
+			d.AddStage(Stage{containsFrom: true})
+		} else if d.numStages == 0 {
+			d.AddStage(Stage{})
+		}
+		if !d.LastStage().containsFrom && keyword != "" && keyword != "ARG" {
+			return &DockerfileParseError{Line: line, Msg: fmt.Sprintf("%s is disallowed before FROM", keyword)}
+		}
+		d.LastStage().AddInstruction(inst)
+		return nil
+	}
+
+	// parser directives are only at the top of the file
+	i := 0
+	for ; i < len(lines); i++ {
+		m := reDirective.FindStringSubmatch(strings.TrimSpace(strings.TrimSuffix(lines[i], "\r")))
+		if m == nil {
+			break
+		}
+		key := strings.ToLower(m[1])
+		if _, ok := d.directives[key]; ok {
+			break
+		}
+		d.directives[key] = m[2]
+		if key == "escape" {
+			if m[2] != "\\" && m[2] != "`" {
+				return d, &DockerfileParseError{Line: i + 1, Msg: fmt.Sprintf("invalid escape character '%s'", m[2])}
+			}
+			escape = m[2]
+		}
+		add(&COMMENT{source: source{lines[i], i + 1}, comment: strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[i]), "#"))}, "", i+1)
+	}
+
+	for ; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			add(&BLANK{source: source{lines[i], i + 1}}, "", i+1)
+			continue
+		case strings.HasPrefix(trimmed, "#"):
+			add(&COMMENT{source: source{lines[i], i + 1}, comment: strings.TrimSpace(strings.TrimPrefix(trimmed, "#"))}, "", i+1)
+			continue
+		}
+
+		ll := joinLogicalLine(lines, i, escape)
+		i = ll.end
+
+		keyword, _, _ := strings.Cut(strings.TrimSpace(ll.text()), " ")
+		keyword, _, _ = strings.Cut(keyword, "\t")
+		keyword = strings.ToUpper(keyword)
+		if !slices.Contains(DOCKERFILE_INSTRUCTIONS, keyword) {
+			return d, &DockerfileParseError{Line: ll.start + 1, Msg: fmt.Sprintf("unknown instruction '%s'", keyword)}
+		}
+		if slices.Contains(HEREDOC_INSTRUCTIONS, keyword) {
+			var err error
+			if ll, err = readHeredocs(lines, ll); err != nil {
+				return d, err
+			}
+			i = ll.end
+		}
+
+		raw := strings.Join(lines[ll.start:ll.end+1], "\n")
+		inst, err := parseInstruction(keyword, ll, raw, escape)
+		if err != nil {
+			return d, &DockerfileParseError{Line: ll.start + 1, Msg: err.Error()}
+		}
+		if s, ok := inst.(interface{ setSource(source) }); ok {
+			s.setSource(source{raw, ll.start + 1})
+		}
+		if err := add(inst, keyword, ll.start+1); err != nil {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+func (s *source) setSource(src source) {
+	*s = src
+}
+
+// joinLogicalLine joins the physical lines continued by the escape character from lines[start].
+// Comment lines in a continued instruction are dropped, as Docker does.
+func joinLogicalLine(lines []string, start int, escape string) logicalLine {
+	ll := logicalLine{start: start, end: start}
+	for i := start; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		if i > start {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				ll.end = i
+				continue
+			}
+		}
+		ll.end = i
+		body := strings.TrimRight(line, " \t")
+		if !strings.HasSuffix(body, escape) {
+			ll.segments = append(ll.segments, line)
+			return ll
+		}
+		ll.segments = append(ll.segments, strings.TrimSuffix(body, escape))
+	}
+	// the last line is continued (Docker accepts it)
+	return ll
+}
+
+// readHeredocs reads the bodies of heredocs (e.g. <<EOF ... EOF) following the instruction.
+func readHeredocs(lines []string, ll logicalLine) (logicalLine, error) {
+	text := ll.text()
+	if isJSONForm(afterKeyword(text)) {
+		return ll, nil
+	}
+	for _, m := range findHeredocs(text) {
+		delim, strip := matchedHeredoc(text, m)
+		var body []string
+		found := false
+		for ll.end+1 < len(lines) {
+			ll.end++
+			line := strings.TrimSuffix(lines[ll.end], "\r")
+			check := line
+			if strip {
+				check = strings.TrimLeft(line, "\t")
+			}
+			if check == delim {
+				found = true
+				break
+			}
+			body = append(body, line)
+		}
+		if !found {
+			return ll, &DockerfileParseError{Line: ll.start + 1, Msg: fmt.Sprintf("unterminated heredoc '%s'", delim)}
+		}
+		ll.heredocs = append(ll.heredocs, strings.Join(body, "\n"))
+	}
+	return ll, nil
+}
+
+// afterKeyword returns the text after the instruction keyword.
+func afterKeyword(text string) string {
+	text = strings.TrimSpace(text)
+	i := strings.IndexAny(text, " \t")
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(text[i:])
+}
+
+// parseFlags returns the leading flags (e.g. --platform=linux/amd64) and the rest.
+func parseFlags(args string) ([]string, string) {
+	var flags []string
+	for strings.HasPrefix(args, "--") {
+		i := strings.IndexAny(args, " \t")
+		if i < 0 {
+			return append(flags, args), ""
+		}
+		flags = append(flags, args[:i])
+		args = strings.TrimSpace(args[i:])
+	}
+	return flags, args
+}
+
+func isJSONForm(args string) bool {
+	_, ok := parseJSONForm(args)
+	return ok
+}
+
+// parseJSONForm parses the exec (JSON) form. ok is false if args is in the shell form.
+func parseJSONForm(args string) ([]string, bool) {
+	args = strings.TrimSpace(args)
+	if !strings.HasPrefix(args, "[") {
+		return nil, false
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(args), &list); err != nil {
+		return nil, false
+	}
+	return list, true
+}
+
+// shellSegments returns the trimmed physical lines of a shell form command as
+// RUN_INST.command (separated by "\").
+func shellSegments(ll logicalLine) []string {
+	var command []string
+	for i, seg := range ll.segments {
+		if i == 0 {
+			seg = afterKeyword(seg)
+		}
+		seg = strings.TrimSpace(seg)
+		if seg == "" {
+			continue
+		}
+		if len(command) > 0 {
+			command = append(command, "\\")
+		}
+		command = append(command, seg)
+	}
+	return command
+}
+
+// splitWords splits the text at unquoted whitespace. Quotes and escapes are kept in words.
+func splitWords(text string, escape string) []string {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	escaped := false
+	inWord := false
+	for _, c := range text {
+		switch {
+		case escaped:
+			escaped = false
+		case string(c) == escape:
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		}
+		word.WriteRune(c)
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// parseKeyValues parses "key=value ..." (or the legacy "key value") of ENV and LABEL.
+// Keys and values are unquoted (see unquoteWord).
+func parseKeyValues(args string, escape string) ([]string, []string, error) {
+	words := splitWords(args, escape)
+	if len(words) == 0 {
+		return nil, nil, fmt.Errorf("no key-value pair")
+	}
+	var keys, values []string
+	if !strings.Contains(words[0], "=") {
+		// legacy form: the value is the rest of the line
+		value := strings.TrimSpace(strings.TrimPrefix(args, words[0]))
+		return []string{unquoteWord(words[0], escape)}, []string{unquoteWord(value, escape)}, nil
+	}
+	for _, word := range words {
+		key, value, found := strings.Cut(word, "=")
+		if !found || key == "" {
+			return nil, nil, fmt.Errorf("invalid key-value pair '%s'", word)
+		}
+		keys = append(keys, unquoteWord(key, escape))
+		values = append(values, unquoteWord(value, escape))
+	}
+	return keys, values, nil
+}
+
+// parseInstruction returns the instruction of the logical line (raw is its original text).
+func parseInstruction(keyword string, ll logicalLine, raw string, escape string) (InstructionBuilder, error) {
+	args := afterKeyword(ll.text())
+	flags, rest := parseFlags(args)
+	other := &OTHER_INST{keyword: keyword, args: afterKeyword(raw)}
+
+	switch keyword {
+	case "FROM":
+		from := &FROM_INST{}
+		for _, flag := range flags {
+			if v, ok := strings.CutPrefix(flag, "--platform="); ok {
+				from.platform = v
+			} else {
+				return nil, fmt.Errorf("unknown flag '%s' of FROM", flag)
+			}
+		}
+		words := strings.Fields(rest)
+		switch {
+		case len(words) == 1:
+		case len(words) == 3 && strings.EqualFold(words[1], "AS"):
+			from.AS = words[2]
+		default:
+			return nil, fmt.Errorf("invalid FROM '%s'", args)
+		}
+		from.image, from.tag = splitImageReference(words[0])
+		return from, nil
+	case "RUN":
+		switch len(ll.heredocs) {
+		case 0:
+		case 1:
+			// a heredoc at the end (e.g. RUN <<EOF, RUN python3 <<EOF)
+			m := findHeredocs(rest)
+			if len(m) != 1 || strings.TrimSpace(rest[m[0][1]:]) != "" {
+				return other, nil
+			}
+			command := strings.Fields(rest[:m[0][0]])
+			return &RUN_INST{form: "shell", command: command, operator: "!", options: flags, heredoc: ll.heredocs[0]}, nil
+		default:
+			return other, nil
+		}
+		if list, ok := parseJSONForm(rest); ok {
+			return &RUN_INST{form: "exec", command: list, operator: "!", options: flags}, nil
+		}
+		command := shellSegments(ll)
+		// drop the flags, which may be continued over lines
+		for n := len(flags); n > 0 && len(command) > 0; {
+			f, r := parseFlags(command[0])
+			n -= len(f)
+			if command[0] = r; r != "" {
+				break
+			}
+			command = command[1:]
+			if len(command) > 0 && command[0] == "\\" {
+				command = command[1:]
+			}
+		}
+		return &RUN_INST{form: "shell", command: command, operator: "!", options: flags}, nil
+	case "ENTRYPOINT":
+		if list, ok := parseJSONForm(rest); ok {
+			return &ENTRYPOINT_INST{form: "exec", command: list, operator: "!"}, nil
+		}
+		return &ENTRYPOINT_INST{form: "shell", command: shellSegments(ll), operator: "!"}, nil
+	case "COPY":
+		if len(ll.heredocs) > 0 {
+			return other, nil
+		}
+		cp := &COPY_INST{form: "shell", options: flags}
+		list, ok := parseJSONForm(rest)
+		if ok {
+			cp.form = "exec"
+		} else {
+			list = strings.Fields(rest)
+		}
+		if len(list) < 2 {
+			return nil, fmt.Errorf("COPY requires at least two arguments")
+		}
+		cp.src, cp.dest = list[:len(list)-1], list[len(list)-1]
+		return cp, nil
+	case "WORKDIR":
+		if rest == "" {
+			return nil, fmt.Errorf("WORKDIR requires a directory")
+		}
+		return &WORKDIR_INST{workingdirectory: rest}, nil
+	case "ENV":
+		keys, values, err := parseKeyValues(args, escape)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ENV: %w", err)
+		}
+		return &ENV_INST{keys: keys, values: values}, nil
+	case "LABEL":
+		keys, values, err := parseKeyValues(args, escape)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LABEL: %w", err)
+		}
+		return &LABEL_INST{keys: keys, values: values}, nil
+	case "VOLUME":
+		list, ok := parseJSONForm(rest)
+		if !ok {
+			list = strings.Fields(rest)
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("VOLUME requires at least one argument")
+		}
+		return &VOLUMNE_INST{volumes: list}, nil
+	case "ARG":
+		words := splitWords(args, escape)
+		if len(words) == 0 {
+			return nil, fmt.Errorf("ARG requires at least one argument")
+		}
+		for i, word := range words {
+			if name, def, found := strings.Cut(word, "="); found {
+				words[i] = name + "=" + unquoteWord(def, escape)
+			}
+		}
+		return &ARG_INST{args: words}, nil
+	case "CMD":
+		if list, ok := parseJSONForm(rest); ok {
+			return &CMD_INST{form: "exec", command: list, operator: "!"}, nil
+		}
+		return &CMD_INST{form: "shell", command: shellSegments(ll), operator: "!"}, nil
+	case "ADD":
+		if len(ll.heredocs) > 0 {
+			return other, nil
+		}
+		add := &ADD_INST{form: "shell", options: flags}
+		list, ok := parseJSONForm(rest)
+		if ok {
+			add.form = "exec"
+		} else {
+			list = strings.Fields(rest)
+		}
+		if len(list) < 2 {
+			return nil, fmt.Errorf("ADD requires at least two arguments")
+		}
+		add.src, add.dest = list[:len(list)-1], list[len(list)-1]
+		return add, nil
+	case "USER":
+		if len(strings.Fields(rest)) != 1 {
+			return nil, fmt.Errorf("USER requires exactly one argument")
+		}
+		user, group, _ := strings.Cut(rest, ":")
+		return &USER_INST{user: user, group: group}, nil
+	case "EXPOSE":
+		ports := strings.Fields(rest)
+		if len(ports) == 0 {
+			return nil, fmt.Errorf("EXPOSE requires at least one argument")
+		}
+		return &EXPOSE_INST{ports: ports}, nil
+	case "SHELL":
+		list, ok := parseJSONForm(rest)
+		if !ok {
+			return nil, fmt.Errorf("SHELL requires the arguments to be in JSON form")
+		}
+		return &SHELL_INST{command: list}, nil
+	case "HEALTHCHECK":
+		hc := &HEALTHCHECK_INST{options: flags}
+		if strings.EqualFold(rest, "NONE") {
+			hc.none = true
+			return hc, nil
+		}
+		keyword, command, _ := strings.Cut(rest, " ")
+		if !strings.EqualFold(keyword, "CMD") {
+			return nil, fmt.Errorf("HEALTHCHECK requires CMD or NONE")
+		}
+		if list, ok := parseJSONForm(command); ok {
+			hc.form, hc.command = "exec", list
+		} else {
+			hc.form, hc.command = "shell", []string{strings.TrimSpace(command)}
+		}
+		return hc, nil
+	case "STOPSIGNAL":
+		if rest == "" {
+			return nil, fmt.Errorf("STOPSIGNAL requires a signal")
+		}
+		return &STOPSIGNAL_INST{signal: rest}, nil
+	case "ONBUILD":
+		keyword, _, _ := strings.Cut(rest, " ")
+		keyword = strings.ToUpper(keyword)
+		switch keyword {
+		case "ONBUILD", "FROM", "MAINTAINER":
+			return nil, fmt.Errorf("%s is disallowed in ONBUILD", keyword)
+		}
+		if !slices.Contains(DOCKERFILE_INSTRUCTIONS, keyword) {
+			return nil, fmt.Errorf("unknown instruction '%s' in ONBUILD", keyword)
+		}
+		trigger_ll := logicalLine{segments: slices.Clone(ll.segments)}
+		trigger_ll.segments[0] = afterKeyword(trigger_ll.segments[0])
+		trigger, err := parseInstruction(keyword, trigger_ll, rest, escape)
+		if err != nil {
+			return nil, err
+		}
+		return &ONBUILD_INST{inst: trigger}, nil
+	}
+	return other, nil
+}
+
+// splitImageReference splits an image reference into the image and the tag.
+// A reference with a digest (image@sha256:...) has no tag.
+func splitImageReference(ref string) (string, string) {
+	if strings.Contains(ref, "@") {
+		return ref, ""
+	}
+	i := strings.LastIndex(ref, ":")
+	if i < 0 || strings.Contains(ref[i:], "/") {
+		return ref, ""
+	}
+	return ref[:i], ref[i+1:]
+}
+
+// BaseImages returns the images referred by FROM instructions in order.
+// Earlier stages (FROM builder) and "scratch" are excluded, and
+// the ARGs declared before the first FROM are expanded with their default values.
+func (d *Dockerfile) BaseImages() []string {
+	_, images := d.baseFroms()
+	return images
+}
+
+// baseFroms returns the FROM instructions referring to base images (see BaseImages),
+// and their references with the ARGs expanded.
+func (d *Dockerfile) baseFroms() ([]*FROM_INST, []string) {
+	args := make(map[string]string)
+	var stages []string
+	var froms []*FROM_INST
+	var images []string
+	for _, stage := range d.stages {
+		for _, inst := range stage.Instructions {
+			switch v := inst.(type) {
+			case *ARG_INST:
+				if !stage.containsFrom {
+					for _, word := range v.args {
+						key, value, _ := strings.Cut(word, "=")
+						args[key] = strings.Trim(value, `"'`)
+					}
+				}
+			case *FROM_INST:
+				ref := os.Expand(v.reference(), func(key string) string { return args[key] })
+				if ref != "scratch" && !slices.Contains(stages, strings.ToLower(ref)) {
+					froms = append(froms, v)
+					images = append(images, ref)
+				}
+				if v.AS != "" {
+					stages = append(stages, strings.ToLower(v.AS))
+				}
+			}
+		}
+	}
+	return froms, images
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// package install by a package manager other than apt (see PKG_MANAGERS)
+type PKG_INSTALL struct {
+	source
+	manager  string
+	packages []string // <name>, <name>=<version> or a spec of the manager (e.g. numpy>=1.26)
+	options  []string // <key>=<value> (e.g. channel=conda-forge)
+}
+
+// pkgManager is a package manager of PKG_INSTALL.
+type pkgManager struct {
+	options []string // keys of the options
+	// build returns the RUN command which installs the packages and cleans up the cache
+	build func(pkgs []pkgSpec, opts map[string][]string) []string
+}
+
+// pkgSpec is a package with the version pinned by "<name>=<version>".
+type pkgSpec struct {
+	name    string
+	version string
+}
+
+// parsePkgSpec splits "<name>=<version>". Other specs (e.g. numpy>=1.26) are kept as the name.
+func parsePkgSpec(s string) pkgSpec {
+	if strings.ContainsAny(s, "<>!~") || strings.Contains(s, "==") {
+		return pkgSpec{name: s}
+	}
+	name, version, _ := strings.Cut(s, "=")
+	return pkgSpec{name: name, version: version}
+}
+
+// PKG_MANAGERS are the package managers by the --inst type.
+var PKG_MANAGERS = map[string]pkgManager{
+	"pip": {
+		options: []string{"index", "extra-index"},
+		build: func(pkgs []pkgSpec, opts map[string][]string) []string {
+			install := []string{"pip", "install", "--no-cache-dir"}
+			for _, url := range opts["index"] {
+				install = append(install, "--index-url", url)
+			}
+			for _, url := range opts["extra-index"] {
+				install = append(install, "--extra-index-url", url)
+			}
+			return pkgCommand(install, pinPkgs(pkgs, "=="), nil)
+		},
+	},
+	"conda": {
+		options: []string{"channel"},
+		build: func(pkgs []pkgSpec, opts map[string][]string) []string {
+			install := []string{"conda", "install", "-y"}
+			for _, ch := range opts["channel"] {
+				install = append(install, "-c", ch)
+			}
+			return pkgCommand(install, pinPkgs(pkgs, "="), []string{"conda", "clean", "--all", "-y"})
+		},
+	},
+	"micromamba": {
+		options: []string{"channel"},
+		build: func(pkgs []pkgSpec, opts map[string][]string) []string {
+			install := []string{"micromamba", "install", "-y", "-n", "base"}
+			for _, ch := range opts["channel"] {
+				install = append(install, "-c", ch)
+			}
+			return pkgCommand(install, pinPkgs(pkgs, "="), []string{"micromamba", "clean", "--all", "--yes"})
+		},
+	},
+	"r": {
+		options: []string{"repos"},
+		build:   buildRInstall,
+	},
+	"apk": {
+		options: []string{"repository"},
+		build: func(pkgs []pkgSpec, opts map[string][]string) []string {
+			// --no-cache does not keep the index in the image
+			install := []string{"apk", "add", "--no-cache"}
+			for _, url := range opts["repository"] {
+				install = append(install, "--repository", url)
+			}
+			return pkgCommand(install, pinPkgs(pkgs, "="), nil)
+		},
+	},
+	"dnf": {
+		options: []string{"repo"},
+		build: func(pkgs []pkgSpec, opts map[string][]string) []string {
+			install := []string{"dnf", "install", "-y"}
+			for _, repo := range opts["repo"] {
+				install = append(install, fmt.Sprintf("--enablerepo=%s", repo))
+			}
+			return pkgCommand(install, pinPkgs(pkgs, "-"), []string{"dnf", "clean", "all", "&&", "rm", "-rf", "/var/cache/dnf"})
+		},
+	},
+}
+
+// R_DEFAULT_REPOS is the CRAN mirror used when no repos option is given.
+const R_DEFAULT_REPOS = "https://cloud.r-project.org"
+
+// buildRInstall installs the packages by install.packages, and the pinned ones
+// by remotes::install_version (remotes is installed first if missing).
+// install.packages only warns on failure, so the packages are checked at last.
+// The downloaded packages are in the tempdir of R, which is removed on exit.
+func buildRInstall(pkgs []pkgSpec, opts map[string][]string) []string {
+	repos := opts["repos"]
+	if len(repos) == 0 {
+		repos = []string{R_DEFAULT_REPOS}
+	}
+	var latest, names []string
+	var command []string
+	pinned := false
+	for _, pkg := range pkgs {
+		names = append(names, rString(pkg.name))
+		if pkg.version == "" {
+			latest = append(latest, rString(pkg.name))
+		} else {
+			pinned = true
+		}
+	}
+	if pinned {
+		command = append(command, fmt.Sprintf("Rscript -e 'if (!requireNamespace(\"remotes\", quietly = TRUE)) install.packages(\"remotes\", repos = %s)'", rVector(rStrings(repos))), "\\", "&&")
+	}
+	if len(latest) > 0 {
+		command = append(command, fmt.Sprintf("Rscript -e 'install.packages(%s, repos = %s)'", rVector(latest), rVector(rStrings(repos))), "\\", "&&")
+	}
+	for _, pkg := range pkgs {
+		if pkg.version != "" {
+			command = append(command, fmt.Sprintf("Rscript -e 'remotes::install_version(%s, version = %s, repos = %s)'", rString(pkg.name), rString(pkg.version), rVector(rStrings(repos))), "\\", "&&")
+		}
+	}
+	command = append(command, fmt.Sprintf("Rscript -e 'stopifnot(all(%s %%in%% rownames(installed.packages())))'", rVector(names)))
+	return command
+}
+
+func rString(s string) string {
+	return fmt.Sprintf("%q", s)
+}
+
+func rStrings(ss []string) []string {
+	var out []string
+	for _, s := range ss {
+		out = append(out, rString(s))
+	}
+	return out
+}
+
+// rVector returns the R character vector of the (quoted) strings.
+func rVector(ss []string) string {
+	if len(ss) == 1 {
+		return ss[0]
+	}
+	return fmt.Sprintf("c(%s)", strings.Join(ss, ", "))
+}
+
+// pinPkgs returns the packages pinned by the separator of the manager (e.g. numpy==1.26.4).
+func pinPkgs(pkgs []pkgSpec, sep string) []string {
+	var out []string
+	for _, pkg := range pkgs {
+		s := pkg.name
+		if pkg.version != "" {
+			s += sep + pkg.version
+		}
+		// specs like numpy>=1.26 or extras like requests[socks] are special in the shell
+		if strings.ContainsAny(s, "<>|&;()*?[]$ ") {
+			s = fmt.Sprintf("'%s'", s)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// pkgCommand lays out the install, a package per line and the cleanup.
+func pkgCommand(install []string, pkgs []string, cleanup []string) []string {
+	command := append(slices.Clone(install), "\\")
+	for _, pkg := range pkgs {
+		command = append(command, pkg, "\\")
+	}
+	if len(cleanup) == 0 {
+		return command[:len(command)-1]
+	}
+	return append(append(command, "&&"), cleanup...)
+}
+
+func (pkg PKG_INSTALL) Build() string {
+	manager, ok := PKG_MANAGERS[pkg.manager]
+	if !ok {
+		slog.Error(fmt.Sprintf("unknown package manager '%s'", pkg.manager))
+		os.Exit(1)
+	}
+	var pkgs []pkgSpec
+	for _, p := range pkg.packages {
+		pkgs = append(pkgs, parsePkgSpec(p))
+	}
+	opts := map[string][]string{}
+	for _, option := range pkg.options {
+		key, value, _ := strings.Cut(option, "=")
+		opts[key] = append(opts[key], value)
+	}
+	return commonBuild("RUN", "shell", manager.build(pkgs, opts))
+}
+
+// Parse parses "<pkg>[,<pkg>...][:<key>=<value>[,<key>=<value>...]]".
+// The rest of the first separator is the options, so values can contain it (e.g. URLs).
+func (pkg *PKG_INSTALL) Parse(s string) error {
+	pkgs, options, _ := strings.Cut(s, ARG_SEPARATER)
+	pkg.packages = nil
+	for _, p := range strings.Split(pkgs, ",") {
+		if p != "" {
+			pkg.packages = append(pkg.packages, p)
+		}
+	}
+	if len(pkg.packages) == 0 {
+		return fmt.Errorf("no %s package found. '%s'", pkg.manager, s)
+	}
+	if err := checkLine(pkg.manager, s); err != nil {
+		return err
+	}
+	pkg.options = nil
+	if options != "" {
+		for _, option := range strings.Split(options, ",") {
+			if err := pkg.AddOption(option); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (pkg *PKG_INSTALL) ParseShort(s string) error {
+	return pkg.Parse(s)
+}
+
+// AddOption adds "<key>=<value>" after checking the key for the manager.
+func (pkg *PKG_INSTALL) AddOption(option string) error {
+	if err := checkPkgOption(pkg.manager, option); err != nil {
+		return err
+	}
+	pkg.options = append(pkg.options, option)
+	return nil
+}
+
+func checkPkgOption(manager, option string) error {
+	keys := PKG_MANAGERS[manager].options
+	key, value, ok := strings.Cut(option, "=")
+	if !ok || value == "" || !slices.Contains(keys, key) {
+		return fmt.Errorf("invalid %s option '%s' (<key>=<value> of %s)", manager, option, strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+// MergePkg merges package installs of the same manager into the first one.
+// Those with different options (e.g. channels) are merged separately.
+func (s *Stage) MergePkg() {
+	var merged []*PKG_INSTALL
+	var new []InstructionBuilder
+	for _, inst := range s.Instructions {
+		p, is_pkg := inst.(*PKG_INSTALL)
+		if !is_pkg {
+			new = append(new, inst)
+			continue
+		}
+		i := slices.IndexFunc(merged, func(m *PKG_INSTALL) bool {
+			return m.manager == p.manager && slices.Equal(m.options, p.options)
+		})
+		if i < 0 {
+			m := &PKG_INSTALL{manager: p.manager, packages: slices.Clone(p.packages), options: p.options}
+			merged = append(merged, m)
+			new = append(new, m)
+			continue
+		}
+		merged[i].packages = append(merged[i].packages, p.packages...)
+	}
+	s.Instructions = new
+}
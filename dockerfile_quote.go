@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Values of the instructions (e.g. ENV, LABEL and the default of ARG) are kept as they are meant
+// and quoted when rendered. In the values, $NAME and ${NAME} are variables expanded by the builder
+// as in the Dockerfile, and \$ is a literal $.
+
+// dockerfileWord returns the value as a word of the Dockerfile, double-quoted if needed.
+func dockerfileWord(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '$':
+			b.WriteString(`\$`)
+			i++
+		case c == '\\' || c == '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// unquoteWord returns the value of a word of the Dockerfile (the reverse of dockerfileWord).
+// Quotes are removed, and escaped or single-quoted $ are kept as \$.
+func unquoteWord(word string, escape string) string {
+	var b strings.Builder
+	var quote rune
+	escaped := false
+	for _, c := range word {
+		switch {
+		case escaped:
+			escaped = false
+			switch {
+			case c == '$':
+				b.WriteString(`\`)
+			case quote == '"' && c != '"' && string(c) != escape:
+				// in double quotes, only ", $ and the escape character are escaped
+				b.WriteString(escape)
+			}
+			b.WriteRune(c)
+		case quote != '\'' && string(c) == escape:
+			escaped = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == '\'' && c == '$':
+			b.WriteString(`\$`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	if escaped {
+		b.WriteString(escape)
+	}
+	return b.String()
+}
+
+// jsonArray returns the list in the exec (JSON) form. Unlike json.Marshal,
+// characters like & and < are not escaped (e.g. ["sh","-c","make && make install"]).
+func jsonArray(list []string) string {
+	if list == nil {
+		list = []string{}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	// a list of strings is always encoded
+	_ = enc.Encode(list)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// checkLine returns an error if the value spans lines, which a Dockerfile can not keep.
+func checkLine(kind, s string) error {
+	if strings.ContainsAny(s, "\r\n") {
+		return fmt.Errorf("invalid %s '%s': line breaks are not allowed", kind, s)
+	}
+	return nil
+}
+
+// checkKeyValues checks the keys and the values of ENV or LABEL.
+// Keys of ENV are names, and keys of LABEL can be anything quoted.
+func checkKeyValues(kind string, keys, values []string) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("invalid %s: no key-value pair", kind)
+	}
+	for i, key := range keys {
+		if key == "" || kind == "ENV" && strings.ContainsAny(key, " \t=\"'\\$") {
+			return fmt.Errorf("invalid %s key '%s'", kind, key)
+		}
+		if err := checkLine(kind, key+"="+values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
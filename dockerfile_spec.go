@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DockerfileSpec is a Dockerfile described as structured data (YAML or JSON).
+// It is read by `gdocker dev dockerfile --spec`.
+//
+//	args: [VERSION=1.17]           # ARGs before the first FROM
+//	stages:
+//	  - from: ubuntu_a:22.04
+//	    as: build                  # optional (platform too)
+//	    instructions:
+//	      - apt: [curl, git]
+//	      - run: make install
+type DockerfileSpec struct {
+	Args   []specString `json:"args,omitempty"`
+	Stages []StageSpec  `json:"stages"`
+}
+
+// StageSpec is a stage of DockerfileSpec.
+// Each instruction is a mapping of a single key (e.g. {"run": "make"}, see specInstruction).
+type StageSpec struct {
+	From         specString        `json:"from"`
+	Platform     string            `json:"platform,omitempty"`
+	As           string            `json:"as,omitempty"`
+	Instructions []json.RawMessage `json:"instructions,omitempty"`
+}
+
+// specString is a string which can also be written as a number or a boolean in YAML (e.g. tag: 22.04).
+type specString string
+
+func (s *specString) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err == nil {
+		*s = specString(v)
+		return nil
+	}
+	var raw any
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	switch raw.(type) {
+	case float64, bool:
+		*s = specString(b)
+		return nil
+	}
+	return fmt.Errorf("a string is expected, but got %s", string(b))
+}
+
+// readDockerfileSpec reads a spec file. Files with the ".json" extension are read as JSON,
+// and the others as YAML (see yamlToJSON).
+func readDockerfileSpec(file string) (DockerfileSpec, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return DockerfileSpec{}, err
+	}
+	spec, err := parseDockerfileSpec(b, filepath.Ext(file) == ".json")
+	if err != nil {
+		return spec, fmt.Errorf("%s: %w", file, err)
+	}
+	return spec, nil
+}
+
+// parseDockerfileSpec decodes a spec written in JSON or YAML.
+func parseDockerfileSpec(b []byte, isJSON bool) (DockerfileSpec, error) {
+	var spec DockerfileSpec
+	if !isJSON {
+		var err error
+		b, err = yamlToJSON(b)
+		if err != nil {
+			return spec, err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return spec, err
+	}
+	return spec, nil
+}
+
+// AddTo adds the instructions of the spec to the Dockerfile in order.
+func (spec DockerfileSpec) AddTo(d *Dockerfile) error {
+	if len(spec.Args) > 0 {
+		d.AddInstruction(&ARG_INST{args: specStringsOf(spec.Args)})
+	}
+	for i, stage := range spec.Stages {
+		if stage.From == "" {
+			return fmt.Errorf("stages[%d]: from is required", i)
+		}
+		from := &FROM_INST{platform: stage.Platform, AS: stage.As}
+		from.image, from.tag = splitImageReference(string(stage.From))
+		d.AddInstruction(from)
+		for j, raw := range stage.Instructions {
+			inst, err := specInstruction(raw)
+			if err != nil {
+				return fmt.Errorf("stages[%d].instructions[%d]: %w", i, j, err)
+			}
+			d.AddInstruction(inst)
+		}
+	}
+	return nil
+}
+
+func specStringsOf(ss []specString) []string {
+	strs := make([]string, len(ss))
+	for i, s := range ss {
+		strs[i] = string(s)
+	}
+	return strs
+}
+
+// SPEC_INSTRUCTIONS are the keys of the instructions in DockerfileSpec.
+var SPEC_INSTRUCTIONS = []string{
+	"comment", "blank", "arg", "run", "cmd", "entrypoint", "copy", "add", "workdir", "env", "volume",
+	"label", "user", "expose", "shell", "healthcheck", "stopsignal", "onbuild", "apt",
+	"pip", "conda", "micromamba", "r", "apk", "dnf",
+}
+
+// specInstruction returns the instruction of a single key mapping.
+// Values are written as in the Dockerfile reference (e.g. a string is the shell form,
+// and a list is the exec form of run, cmd and entrypoint):
+//
+//	run:         "<command>" | [<executable>, <param>...] | {form, command, operator, options, heredoc}
+//	cmd:         (same as run)
+//	entrypoint:  (same as run)
+//	copy:        [<src>..., <dest>] | {src, dest, options, form}
+//	add:         (same as copy)
+//	env:         {<key>: <value>...} | ["<key>=<value>"...]
+//	label:       (same as env)
+//	arg, volume, expose: "<value>" | [<value>...]
+//	apt:         "<pkg>" | [<pkg>...] | {packages, cache}
+//	pip, conda, micromamba, r, apk, dnf: "<pkg>" | [<pkg>...] | {packages, <option>...}
+//	             (pinned R packages are installed by remotes, which is installed first if missing)
+//	workdir, stopsignal, comment: "<value>"
+//	user:        "<user>[:<group>]" | {user, group}
+//	shell:       [<executable>, <param>...]
+//	healthcheck: "none" | (same as run) | {options, form, command}
+//	onbuild:     {<instruction>: <value>}
+//	blank:       null
+//
+// A multi-line string of the shell form is continued with backslashes.
+func specInstruction(raw json.RawMessage) (InstructionBuilder, error) {
+	keys, values, err := decodeJSONObject(raw)
+	if err != nil || len(keys) != 1 {
+		return nil, fmt.Errorf("an instruction must be a mapping of a single key (one of %s)", strings.Join(SPEC_INSTRUCTIONS, ", "))
+	}
+	key, value := keys[0], values[0]
+
+	switch key {
+	case "comment":
+		s, err := specScalar(value)
+		if err == nil {
+			err = checkLine("comment", s)
+		}
+		return &COMMENT{comment: s}, err
+	case "blank":
+		return &BLANK{}, nil
+	case "arg":
+		args, err := specList(value)
+		if err == nil {
+			err = checkArgs(args)
+		}
+		return &ARG_INST{args: args}, err
+	case "run":
+		run := RUN_INST{operator: "&&"}
+		err := specCommand(value, &run, true)
+		if err == nil && run.heredoc == "" {
+			err = checkCommand(run.command, string(value))
+		}
+		return &run, err
+	case "cmd":
+		cmd := RUN_INST{operator: "!"}
+		err := specCommand(value, &cmd, false)
+		if err == nil {
+			err = checkCommand(cmd.command, string(value))
+		}
+		return (*CMD_INST)(&cmd), err
+	case "entrypoint":
+		ep := RUN_INST{operator: "!"}
+		err := specCommand(value, &ep, false)
+		if err == nil {
+			err = checkCommand(ep.command, string(value))
+		}
+		return (*ENTRYPOINT_INST)(&ep), err
+	case "copy":
+		cp, err := specCopy(value)
+		return &cp, err
+	case "add":
+		cp, err := specCopy(value)
+		return (*ADD_INST)(&cp), err
+	case "workdir":
+		s, err := specScalar(value)
+		if err == nil {
+			err = checkLine("WORKDIR", s)
+		}
+		return &WORKDIR_INST{workingdirectory: s}, err
+	case "env":
+		keys, values, err := specKeyValues(value)
+		if err == nil {
+			err = checkKeyValues("ENV", keys, values)
+		}
+		return &ENV_INST{keys: keys, values: values}, err
+	case "label":
+		keys, values, err := specKeyValues(value)
+		if err == nil {
+			err = checkKeyValues("LABEL", keys, values)
+		}
+		return &LABEL_INST{keys: keys, values: values}, err
+	case "volume":
+		volumes, err := specList(value)
+		if err == nil {
+			err = checkList("VOLUME", volumes)
+		}
+		return &VOLUMNE_INST{volumes: volumes}, err
+	case "user":
+		var user struct {
+			User  specString `json:"user"`
+			Group specString `json:"group"`
+		}
+		if err := specDecodeStrict(value, &user); err == nil {
+			u, g := string(user.User), string(user.Group)
+			return &USER_INST{user: u, group: g}, checkUser(u, g)
+		}
+		s, err := specScalar(value)
+		u, g, _ := strings.Cut(s, ":")
+		if err == nil {
+			err = checkUser(u, g)
+		}
+		return &USER_INST{user: u, group: g}, err
+	case "expose":
+		ports, err := specList(value)
+		if err == nil {
+			err = checkPorts(ports)
+		}
+		return &EXPOSE_INST{ports: ports}, err
+	case "shell":
+		command, err := specList(value)
+		if err == nil {
+			err = checkList("SHELL", command)
+		}
+		return &SHELL_INST{command: command}, err
+	case "healthcheck":
+		hc := &HEALTHCHECK_INST{}
+		if s, err := specScalar(value); err == nil && strings.EqualFold(s, "none") {
+			hc.none = true
+			return hc, nil
+		}
+		var opts struct {
+			Options []specString    `json:"options"`
+			Form    string          `json:"form"`
+			Command json.RawMessage `json:"command"`
+		}
+		if err := specDecodeStrict(value, &opts); err == nil && opts.Command != nil {
+			hc.options = specStringsOf(opts.Options)
+			value = opts.Command
+			if opts.Form != "" {
+				value, _ = json.Marshal(map[string]any{"form": opts.Form, "command": opts.Command})
+			}
+		}
+		cmd := RUN_INST{}
+		err := specCommand(value, &cmd, false)
+		if err == nil {
+			err = checkCommand(cmd.command, string(value))
+		}
+		hc.form, hc.command = cmd.form, cmd.command
+		return hc, err
+	case "stopsignal":
+		s, err := specScalar(value)
+		if err == nil {
+			err = checkSignal(s)
+		}
+		return &STOPSIGNAL_INST{signal: s}, err
+	case "onbuild":
+		inst, err := specInstruction(value)
+		if err != nil {
+			return nil, fmt.Errorf("onbuild: %w", err)
+		}
+		switch inst.(type) {
+		case *ONBUILD_INST, *COMMENT, *BLANK:
+			return nil, fmt.Errorf("%T is disallowed in ONBUILD", inst)
+		}
+		return &ONBUILD_INST{inst: inst}, nil
+	case "apt":
+		apt := &APT_INSTALL{form: "shell"}
+		var opts struct {
+			Packages json.RawMessage `json:"packages"`
+			Cache    bool            `json:"cache"`
+		}
+		if err := specDecodeStrict(value, &opts); err == nil && opts.Packages != nil {
+			value = opts.Packages
+			if opts.Cache {
+				apt.options = APT_CACHE_MOUNTS
+			}
+		}
+		pkgs, err := specList(value)
+		for _, pkg := range pkgs {
+			apt.command = append(apt.command, pkg, "\\")
+		}
+		return apt, err
+	case "pip", "conda", "micromamba", "r", "apk", "dnf":
+		return specPkgInstall(key, value)
+	}
+	return nil, fmt.Errorf("unknown instruction '%s' (one of %s)", key, strings.Join(SPEC_INSTRUCTIONS, ", "))
+}
+
+// specPkgInstall returns the install of the packages (e.g. samtools=1.17) with
+// the options of the manager as keys (e.g. {packages: [samtools], channel: [conda-forge, bioconda]}).
+func specPkgInstall(manager string, value json.RawMessage) (InstructionBuilder, error) {
+	pkg := &PKG_INSTALL{manager: manager}
+	keys, values, err := decodeJSONObject(value)
+	if err != nil {
+		// packages only
+		pkg.packages, err = specList(value)
+		return pkg, err
+	}
+	for i, key := range keys {
+		if key == "packages" {
+			pkgs, err := specList(values[i])
+			if err != nil {
+				return nil, err
+			}
+			pkg.packages = append(pkg.packages, pkgs...)
+			continue
+		}
+		opts, err := specList(values[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		for _, opt := range opts {
+			option := fmt.Sprintf("%s=%s", key, opt)
+			if err := checkPkgOption(manager, option); err != nil {
+				return nil, err
+			}
+			pkg.options = append(pkg.options, option)
+		}
+	}
+	if len(pkg.packages) == 0 {
+		return nil, fmt.Errorf("no %s package found", manager)
+	}
+	return pkg, nil
+}
+
+// decodeJSONObject returns the keys and the values of a JSON object in order.
+func decodeJSONObject(raw json.RawMessage) ([]string, []json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, nil, fmt.Errorf("a mapping is expected, but got %s", string(raw))
+	}
+	var keys []string
+	var values []json.RawMessage
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, t.(string))
+		values = append(values, v)
+	}
+	return keys, values, nil
+}
+
+func specDecodeStrict(raw json.RawMessage, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// specScalar returns a string, a number or a boolean as a string.
+func specScalar(raw json.RawMessage) (string, error) {
+	var s specString
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// specList returns a scalar or a list of scalars as a list.
+func specList(raw json.RawMessage) ([]string, error) {
+	if s, err := specScalar(raw); err == nil {
+		return []string{s}, nil
+	}
+	var ss []specString
+	if err := json.Unmarshal(raw, &ss); err != nil || len(ss) == 0 {
+		return nil, fmt.Errorf("a string or a list of strings is expected, but got %s", string(raw))
+	}
+	return specStringsOf(ss), nil
+}
+
+// specKeyValues returns the keys and the values of a mapping or a list of "key=value".
+func specKeyValues(raw json.RawMessage) ([]string, []string, error) {
+	var keys, values []string
+	if ks, vs, err := decodeJSONObject(raw); err == nil {
+		for i, k := range ks {
+			v, err := specScalar(vs[i])
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", k, err)
+			}
+			keys = append(keys, k)
+			values = append(values, v)
+		}
+		return keys, values, nil
+	}
+	pairs, err := specList(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, pair := range pairs {
+		k, v, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, nil, fmt.Errorf("invalid key-value pair '%s'", pair)
+		}
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	return keys, values, nil
+}
+
+// specCommand sets the form and the command of RUN, CMD, ENTRYPOINT or HEALTHCHECK.
+// The options and the heredoc are allowed only for RUN (runOptions).
+func specCommand(raw json.RawMessage, run *RUN_INST, runOptions bool) error {
+	var opts struct {
+		Form     string          `json:"form"`
+		Command  json.RawMessage `json:"command"`
+		Operator string          `json:"operator"`
+		Options  []specString    `json:"options"`
+		Heredoc  string          `json:"heredoc"`
+	}
+	if err := specDecodeStrict(raw, &opts); err == nil && (opts.Command != nil || opts.Heredoc != "") {
+		if !runOptions && (opts.Options != nil || opts.Heredoc != "") {
+			return fmt.Errorf("options and heredoc are only for run")
+		}
+		if opts.Command != nil {
+			if err := specCommand(opts.Command, run, false); err != nil {
+				return err
+			}
+		} else {
+			run.form = "shell"
+		}
+		run.options = specStringsOf(opts.Options)
+		run.heredoc = opts.Heredoc
+		if opts.Form != "" {
+			if opts.Form != "shell" && opts.Form != "exec" {
+				return fmt.Errorf("form must be either shell or exec, '%s'", opts.Form)
+			}
+			run.form = opts.Form
+		}
+		if opts.Operator != "" {
+			run.operator = opts.Operator
+		}
+		return nil
+	}
+
+	if s, err := specScalar(raw); err == nil {
+		run.form = "shell"
+		lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+		for i, line := range lines {
+			if i > 0 {
+				run.command = append(run.command, "\\")
+			}
+			run.command = append(run.command, strings.TrimSpace(line))
+		}
+		return nil
+	}
+	command, err := specList(raw)
+	if err != nil {
+		return err
+	}
+	run.form = "exec"
+	run.command = command
+	if run.operator == "&&" {
+		run.operator = "!"
+	}
+	return nil
+}
+
+// specCopy returns COPY (or ADD) from a list of sources and the destination, or a mapping.
+func specCopy(raw json.RawMessage) (COPY_INST, error) {
+	cp := COPY_INST{form: "shell"}
+	var opts struct {
+		Src     json.RawMessage `json:"src"`
+		Dest    specString      `json:"dest"`
+		Options []specString    `json:"options"`
+		Form    string          `json:"form"`
+	}
+	if err := specDecodeStrict(raw, &opts); err == nil && opts.Src != nil {
+		src, err := specList(opts.Src)
+		if err != nil {
+			return cp, fmt.Errorf("src: %w", err)
+		}
+		if opts.Dest == "" {
+			return cp, fmt.Errorf("dest is required")
+		}
+		if opts.Form != "" {
+			if !slices.Contains([]string{"shell", "exec"}, opts.Form) {
+				return cp, fmt.Errorf("form must be either shell or exec, '%s'", opts.Form)
+			}
+			cp.form = opts.Form
+		}
+		cp.src, cp.dest, cp.options = src, string(opts.Dest), specStringsOf(opts.Options)
+		return cp, nil
+	}
+	list, err := specList(raw)
+	if err != nil || len(list) < 2 {
+		return cp, fmt.Errorf("[<src>..., <dest>] or {src, dest} is expected, but got %s", string(raw))
+	}
+	cp.src, cp.dest = list[:len(list)-1], list[len(list)-1]
+	return cp, nil
+}
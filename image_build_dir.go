@@ -19,6 +19,7 @@ type DockerImage struct {
 	Tag      string // "latest" (tag name)
 	IsRoot   bool   // true when the image's Dockerfile has no parent image
 	IsLatest bool
+	Root     string // name of the image root directory (for display)
 }
 
 var (
@@ -78,6 +79,8 @@ func Strings(ds []DockerImage) []string {
 // Represent a docker image building directory.
 // Name and Tag must not contain semicolon ";" due to this tool design.
 type ImageBuildDir struct {
+	root      string // name of the image root directory
+	rootIndex int    // index of the image root directory in order of precedence
 	dirParent string
 	dirImage  string
 	dirTags   []string
@@ -168,15 +171,11 @@ func findDependenciesFromDockerfile(dfile string) ([]Dependency, error) {
 		return deps, err
 	}
 
-	lines := findLines(dfile, "FROM ")
-	for _, v := range lines {
-		li := strings.Split(strings.TrimPrefix(v, "FROM "), " ")
-		var imgname string
-		if strings.HasPrefix(li[0], "--platform=") {
-			imgname = li[1]
-		} else {
-			imgname = li[0]
-		}
+	d, err := readDockerfile(dfile)
+	if err != nil {
+		return deps, err
+	}
+	for _, imgname := range d.BaseImages() {
 		right, err := NewDockerImage(imgname)
 		if err != nil {
 			return deps, err
@@ -190,6 +189,11 @@ func (ibd *ImageBuildDir) Directory() string {
 	return filepath.Join(ibd.dirParent, ibd.dirImage)
 }
 
+// Returns the name of the image root directory which the directory was found in
+func (ibd *ImageBuildDir) Root() string {
+	return ibd.root
+}
+
 // Returns a slice of string to remove docker images in the directory
 func (ibd *ImageBuildDir) BuildCleanInstruction(tag string, anno bool) []string {
 	return []string{"-C", anonymizeWd(ibd.Directory(), anno), fmt.Sprintf("clean-%s", tag)}
@@ -272,21 +276,62 @@ func searchImageBuildDir(path string, skip string) ImageBuildDirs {
 	return ibds
 }
 
+// Search ImageBuildDir from the image root directories and return ImageBuildDirs
+// The roots should be ordered by precedence (see Config.imageRoots).
+func searchImageBuildDirs(roots []ImageRoot, skip string) ImageBuildDirs {
+	var ibds ImageBuildDirs
+	for i, root := range roots {
+		if !isDir(root.Dir) {
+			slog.Warn(fmt.Sprintf("image root '%s' is not found. skipped.", root.Dir))
+			continue
+		}
+		found := searchImageBuildDir(root.Dir, skip)
+		for _, ibd := range found.ibds {
+			ibd.root = root.Name
+			ibd.rootIndex = i
+			ibds.ibds = append(ibds.ibds, ibd)
+		}
+	}
+	return ibds
+}
+
+// makeMap makes maps to search ImageBuildDir from image names.
+// If an image name is found in several roots, the one found first takes precedence
+// and the others are dropped with a warning.
 func (ibds *ImageBuildDirs) makeMap() {
+	ibds.mapName = make(map[string]int)
+	var kept []ImageBuildDir
+	for _, ibd := range ibds.ibds {
+		if i, exist := ibds.mapName[ibd.dirImage]; exist {
+			prior := kept[i]
+			// roots are told apart by the index, as the names can be the same (e.g. the default base names)
+			if prior.rootIndex == ibd.rootIndex {
+				slog.Error(fmt.Sprintf("image name is duplicated: '%s'", ibd.dirImage))
+				os.Exit(1)
+			}
+			slog.Warn(fmt.Sprintf("image '%s' in root '%s' is shadowed by root '%s' ('%s')",
+				ibd.dirImage, ibd.root, prior.root, prior.Directory()))
+			continue
+		}
+		ibds.mapName[ibd.dirImage] = len(kept)
+		kept = append(kept, ibd)
+	}
+	ibds.ibds = kept
+
 	ibds.mapNameTag = make(map[string]int)
 	for i, ibd := range ibds.ibds {
 		for _, iname := range ibd.ImageNames() {
 			ibds.mapNameTag[iname] = i
 		}
 	}
-	ibds.mapName = make(map[string]int)
-	for i, ibd := range ibds.ibds {
-		if _, exist := ibds.mapName[ibd.dirImage]; exist {
-			slog.Error(fmt.Sprintf("image name is duplicated: '%s'", ibd.dirImage))
-			os.Exit(1)
-		}
-		ibds.mapName[ibd.dirImage] = i
+}
+
+// Returns the name of the image root directory of the image (name:tag), or "" if not found
+func (ibds *ImageBuildDirs) RootOf(iname string) string {
+	if i, ok := ibds.mapNameTag[iname]; ok {
+		return ibds.ibds[i].root
 	}
+	return ""
 }
 
 func (ibds *ImageBuildDirs) ImageNames() []string {
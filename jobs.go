@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// actions which can be queued from the dashboard
+const (
+	JOB_ACTION_BUILD = "build"
+	JOB_ACTION_CLEAN = "clean"
+)
+
+var JOB_ACTIONS = []string{JOB_ACTION_BUILD, JOB_ACTION_CLEAN}
+
+// states of a job
+const (
+	JOB_STATE_QUEUED  = "queued"
+	JOB_STATE_RUNNING = "running"
+	JOB_STATE_DONE    = "done"
+	JOB_STATE_FAILED  = "failed"
+)
+
+// Job is a gdocker build/clean command run by the dashboard.
+type Job struct {
+	ID       int       `json:"id"`
+	Action   string    `json:"action"`
+	Images   []string  `json:"images"`
+	State    string    `json:"state"`
+	ExitCode int       `json:"exit_code"`
+	Queued   time.Time `json:"queued"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+
+	log bytes.Buffer
+}
+
+func (j *Job) finished() bool {
+	return j.State == JOB_STATE_DONE || j.State == JOB_STATE_FAILED
+}
+
+// jobQueue runs jobs one by one in the order queued.
+type jobQueue struct {
+	args   []string // common arguments of gdocker (e.g. --config)
+	onDone func()   // called after each job
+
+	mu    sync.Mutex
+	jobs  []*Job
+	queue chan *Job
+}
+
+func newJobQueue(args []string, onDone func()) *jobQueue {
+	q := &jobQueue{args: args, onDone: onDone, queue: make(chan *Job, 64)}
+	go q.work()
+	return q
+}
+
+// add queues a job. It returns an error if the action is unknown or the queue is full.
+func (q *jobQueue) add(action string, images []string) (Job, error) {
+	if !slices.Contains(JOB_ACTIONS, action) {
+		return Job{}, fmt.Errorf("unknown action '%s' (one of %s)", action, strings.Join(JOB_ACTIONS, ", "))
+	}
+	if len(images) == 0 {
+		return Job{}, fmt.Errorf("no image is specified")
+	}
+	for _, image := range images {
+		if _, err := NewDockerImage(image); err != nil || strings.HasPrefix(image, "-") {
+			return Job{}, fmt.Errorf("invalid image name '%s'", image)
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j := &Job{ID: len(q.jobs) + 1, Action: action, Images: images, State: JOB_STATE_QUEUED, Queued: time.Now()}
+	select {
+	case q.queue <- j:
+	default:
+		return Job{}, fmt.Errorf("too many jobs are queued")
+	}
+	q.jobs = append(q.jobs, j)
+	return q.snapshot(j), nil
+}
+
+// snapshot returns a copy of the job without the log. q.mu must be held.
+func (q *jobQueue) snapshot(j *Job) Job {
+	return Job{
+		ID: j.ID, Action: j.Action, Images: j.Images, State: j.State, ExitCode: j.ExitCode,
+		Queued: j.Queued, Started: j.Started, Finished: j.Finished,
+	}
+}
+
+func (q *jobQueue) list() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]Job, len(q.jobs))
+	for i, j := range q.jobs {
+		jobs[i] = q.snapshot(j)
+	}
+	return jobs
+}
+
+func (q *jobQueue) get(id int) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if id < 1 || id > len(q.jobs) {
+		return Job{}, false
+	}
+	return q.snapshot(q.jobs[id-1]), true
+}
+
+// logFrom returns the log of the job after the offset, and whether the job is finished.
+func (q *jobQueue) logFrom(id int, offset int) ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j := q.jobs[id-1]
+	b := j.log.Bytes()
+	if offset > len(b) {
+		offset = len(b)
+	}
+	return slices.Clone(b[offset:]), j.finished()
+}
+
+// jobLogWriter appends the output of the running job to its log.
+type jobLogWriter struct {
+	q *jobQueue
+	j *Job
+}
+
+func (w jobLogWriter) Write(p []byte) (int, error) {
+	w.q.mu.Lock()
+	defer w.q.mu.Unlock()
+	return w.j.log.Write(p)
+}
+
+func (q *jobQueue) work() {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	for j := range q.queue {
+		q.mu.Lock()
+		j.State = JOB_STATE_RUNNING
+		j.Started = time.Now()
+		q.mu.Unlock()
+
+		args := append(append([]string{j.Action}, q.args...), j.Images...)
+		w := jobLogWriter{q, j}
+		fmt.Fprintf(w, "$ gdocker %s\n", strings.Join(args, " "))
+		slog.Info(fmt.Sprintf("job %d: gdocker %s", j.ID, strings.Join(args, " ")))
+
+		c := exec.Command(exe, args...)
+		c.Stdout = w
+		c.Stderr = w
+		err := c.Run()
+
+		q.mu.Lock()
+		j.Finished = time.Now()
+		j.State = JOB_STATE_DONE
+		if err != nil {
+			j.State = JOB_STATE_FAILED
+			j.ExitCode = -1
+			if ee, ok := err.(*exec.ExitError); ok {
+				j.ExitCode = ee.ExitCode()
+			}
+			fmt.Fprintf(&j.log, "%s\n", err.Error())
+		}
+		q.mu.Unlock()
+		slog.Info(fmt.Sprintf("job %d: %s", j.ID, j.State))
+
+		if q.onDone != nil {
+			q.onDone()
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const LOCK_FILE = "gdocker.lock"
+
+// what run/wdrun do when an image does not match the lock file
+const (
+	LOCK_MODE_REFUSE = "refuse" // default
+	LOCK_MODE_WARN   = "warn"
+	LOCK_MODE_OFF    = "off"
+)
+
+var LOCK_MODES = []string{"", LOCK_MODE_REFUSE, LOCK_MODE_WARN, LOCK_MODE_OFF}
+
+// status of a locked image
+const (
+	LOCK_STATUS_OK      = "ok"
+	LOCK_STATUS_CHANGED = "changed"
+	LOCK_STATUS_MISSING = "missing"
+)
+
+// lockMode returns the lock mode, or the default one if it is not set.
+func (c *Config) lockMode() string {
+	if c.LockMode == "" {
+		return LOCK_MODE_REFUSE
+	}
+	return c.LockMode
+}
+
+// LockFile represents `gdocker.lock`, which pins the images used by a project.
+type LockFile struct {
+	Images []LockedImage `json:"images"`
+
+	file string
+}
+
+// LockedImage represents an image pinned by its ID (and repository digests if any).
+type LockedImage struct {
+	Image    string   `json:"image"`
+	ID       string   `json:"id"`
+	Digests  []string `json:"digests,omitempty"`
+	BuildDir string   `json:"build_dir,omitempty"`
+}
+
+// findLockFile searches `gdocker.lock` from the directory up to the home (or root) directory.
+func findLockFile(dir string) (string, bool) {
+	return findFileUpward(dir, LOCK_FILE)
+}
+
+// readLockFile reads a lock file. It returns an empty lock if the file does not exist.
+func readLockFile(file string) (LockFile, error) {
+	lf := LockFile{file: file}
+	if !isFile(file) {
+		return lf, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return lf, err
+	}
+	if err := json.Unmarshal(b, &lf); err != nil {
+		return lf, fmt.Errorf("%s: %w", file, err)
+	}
+	return lf, nil
+}
+
+// write writes the lock file sorted by image names.
+// If dry_run is true, it writes the content to stdout.
+func (lf *LockFile) write(dry_run bool) error {
+	slices.SortFunc(lf.Images, func(a, b LockedImage) int {
+		return strings.Compare(a.Image, b.Image)
+	})
+	b, err := json.MarshalIndent(lf, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if dry_run {
+		fmt.Print(string(b))
+		return nil
+	}
+	if err := os.WriteFile(lf.file, b, 0666); err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("write lock file to '%s'", lf.file))
+	return nil
+}
+
+// lookup returns the locked image of the image name (name or name:tag).
+func (lf *LockFile) lookup(image string) (LockedImage, bool) {
+	img, err := NewDockerImage(image)
+	if err != nil {
+		return LockedImage{}, false
+	}
+	i := slices.IndexFunc(lf.Images, func(li LockedImage) bool { return li.Image == img.String() })
+	if i == -1 {
+		return LockedImage{}, false
+	}
+	return lf.Images[i], true
+}
+
+// set adds the locked image, or replaces the one of the same image name.
+func (lf *LockFile) set(li LockedImage) {
+	i := slices.IndexFunc(lf.Images, func(e LockedImage) bool { return e.Image == li.Image })
+	if i == -1 {
+		lf.Images = append(lf.Images, li)
+		return
+	}
+	lf.Images[i] = li
+}
+
+// lockImage inspects the local image and returns it as a LockedImage.
+func lockImage(docker_path string, image string) (LockedImage, error) {
+	img, err := NewDockerImage(image)
+	if err != nil {
+		return LockedImage{}, err
+	}
+	ii, err := inspectImage(docker_path, img.String())
+	if err != nil {
+		return LockedImage{}, err
+	}
+	return LockedImage{
+		Image:    img.String(),
+		ID:       ii.Hash,
+		Digests:  ii.Digests,
+		BuildDir: ii.buildDir(),
+	}, nil
+}
+
+// verify compares the locked image with the local image, and returns the status and the local image ID.
+func (li LockedImage) verify(docker_path string) (string, string) {
+	ii, err := inspectImage(docker_path, li.Image)
+	if err != nil {
+		slog.Debug(err.Error())
+		return LOCK_STATUS_MISSING, ""
+	}
+	if ii.Hash != li.ID {
+		return LOCK_STATUS_CHANGED, ii.Hash
+	}
+	return LOCK_STATUS_OK, ii.Hash
+}
+
+// lockedImagesFromHistory returns the images run in the directory (or its sub directories),
+// according to the run log.
+func lockedImagesFromHistory(dir string) []string {
+	rrs, err := readRunRecords(getRunLogFile())
+	if err != nil {
+		slog.Debug(err.Error())
+		return nil
+	}
+	var images []string
+	for _, rr := range rrs {
+		if !rr.matchDir(dir) || rr.Image == "" {
+			continue
+		}
+		img, err := NewDockerImage(rr.Image)
+		if err != nil || slices.Contains(images, img.String()) {
+			continue
+		}
+		images = append(images, img.String())
+	}
+	return images
+}
+
+// checkLock checks the image of the run against the lock file found from the working directory.
+// Depending on the mode, a mismatch stops gdocker or is reported as a warning.
+func checkLock(rr *RunRecord, mode string) {
+	if mode == LOCK_MODE_OFF || rr.Image == "" {
+		return
+	}
+	file, ok := findLockFile(rr.Cwd)
+	if !ok {
+		return
+	}
+	lf, err := readLockFile(file)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	li, ok := lf.lookup(rr.Image)
+	if !ok || li.ID == rr.ImageID {
+		return
+	}
+
+	local := shortImageID(rr.ImageID)
+	if local == "" {
+		local = "not found"
+	}
+	msg := fmt.Sprintf("'%s' does not match '%s' (locked: %s, local: %s). run 'gdocker lock %s' to update the lock.",
+		li.Image, filepath.Base(file), shortImageID(li.ID), local, li.Image)
+	if mode == LOCK_MODE_WARN {
+		slog.Warn(msg)
+		return
+	}
+	slog.Error(msg)
+	os.Exit(1)
+}
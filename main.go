@@ -30,10 +30,11 @@ func cmdMain() *cli.Command {
 	}
 	cmd.Version = fmt.Sprintf("%s %s", APP_VERSION, getDockerVersion("docker"))
 	cmd.Before = func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
-		logger := getLogger("main", getLogLevel(cmd.Int64("verbose")))
+		// warnings of the configuration are reported by the subcommand, which reads it again
+		logger := getLogger("main", max(getLogLevel(cmd.Int64("verbose")), slog.LevelError))
 		slog.SetDefault(logger)
 
-		config, err := readConfig(searchConfigFiles(cmd.StringSlice("config")))
+		config, _, err := readConfigLayers(cmd.StringSlice("config"))
 		if err != nil {
 			slog.Warn(err.Error())
 			config.updateDockerBin("docker")
@@ -48,12 +49,19 @@ func cmdMain() *cli.Command {
 
 	cmd.Commands = []*cli.Command{
 		cmdShowDeps(),
+		cmdDashboard(),
 		cmdBuild(),
 		cmdClean(),
 		cmdImages(),
 		cmdRun(),
 		cmdRunWorkingDirectory(),
+		cmdShell(),
+		cmdPs(),
+		cmdStop(),
+		cmdRm(),
 		cmdTag(),
+		cmdHistory(),
+		cmdLock(),
 		cmdConfig(),
 		cmdDev(),
 	}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// Recipe is a parameterised template of an image build directory (see `gdocker dev new`).
+// A recipe is a directory of:
+//
+//	recipe.yaml      the description, the parameters and the Makefile resources of the tag
+//	Dockerfile.yaml  the Dockerfile of the tag written as a DockerfileSpec
+//	...              other files put into the tag directory (e.g. scripts)
+//
+// All of them are templates ({{< .Name >}}) of the image name, the tag, the architecture,
+// the base image made by `gdocker dev init` and the parameters (e.g. {{< .pkg >}}).
+type Recipe struct {
+	Name        string           `json:"-"`
+	Description string           `json:"description"`
+	Params      []RecipeParam    `json:"params,omitempty"`
+	Resources   []RecipeResource `json:"resources,omitempty"`
+
+	origin string // "builtin" or the recipe directory
+	fsys   fs.FS
+}
+
+// RecipeParam is a parameter of a recipe set by `--set <name>=<value>`.
+// The default is a template, which can refer to the parameters above.
+type RecipeParam struct {
+	Name     string     `json:"name"`
+	Usage    string     `json:"usage,omitempty"`
+	Default  specString `json:"default,omitempty"`
+	Required bool       `json:"required,omitempty"`
+}
+
+// RecipeResource is a Makefile resource of the tag (put in {TAG}/cache/{NAME}).
+type RecipeResource struct {
+	Name     string   `json:"name"`
+	Commands []string `json:"commands"`
+}
+
+// recipeFile is a file of the tag directory rendered from a recipe.
+type recipeFile struct {
+	path    string // relative to the tag directory
+	content []byte
+	perm    fs.FileMode
+}
+
+const (
+	RECIPE_MANIFEST   = "recipe.yaml"
+	RECIPE_DOCKERFILE = "Dockerfile.yaml"
+)
+
+// built-in recipes
+//
+//go:embed recipes
+var builtinRecipes embed.FS
+
+var reRecipeParam = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// RECIPE_FUNCS are the functions of the recipe templates.
+var RECIPE_FUNCS = template.FuncMap{
+	// list returns the comma separated values as a list of the spec (e.g. ["samtools=1.17", "htslib"])
+	"list": func(s string) string {
+		items := []string{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return jsonArray(items)
+	},
+}
+
+// loadRecipes returns the recipes in the directory (set by "recipe_dir") and the built-in ones
+// sorted by name. Recipes in the directory take precedence over the built-in ones of the same name.
+func loadRecipes(dir string) ([]Recipe, error) {
+	var recipes []Recipe
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			recipe_dir := filepath.Join(dir, entry.Name())
+			if !entry.IsDir() || !isFile(filepath.Join(recipe_dir, RECIPE_MANIFEST)) {
+				continue
+			}
+			recipe, err := readRecipe(os.DirFS(recipe_dir), entry.Name(), recipe_dir)
+			if err != nil {
+				return nil, err
+			}
+			recipes = append(recipes, recipe)
+		}
+	}
+	entries, err := fs.ReadDir(builtinRecipes, "recipes")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if slices.ContainsFunc(recipes, func(r Recipe) bool { return r.Name == entry.Name() }) {
+			slog.Debug(fmt.Sprintf("recipe '%s' overrides the built-in one", entry.Name()))
+			continue
+		}
+		sub, err := fs.Sub(builtinRecipes, path.Join("recipes", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		recipe, err := readRecipe(sub, entry.Name(), "builtin")
+		if err != nil {
+			return nil, err
+		}
+		recipes = append(recipes, recipe)
+	}
+	slices.SortFunc(recipes, func(a, b Recipe) int { return strings.Compare(a.Name, b.Name) })
+	return recipes, nil
+}
+
+// readRecipe reads the manifest of a recipe.
+func readRecipe(fsys fs.FS, name, origin string) (Recipe, error) {
+	recipe := Recipe{Name: name, origin: origin, fsys: fsys}
+	b, err := fs.ReadFile(fsys, RECIPE_MANIFEST)
+	if err != nil {
+		return recipe, fmt.Errorf("recipe '%s': %w", name, err)
+	}
+	b, err = yamlToJSON(b)
+	if err != nil {
+		return recipe, fmt.Errorf("recipe '%s': %s: %w", name, RECIPE_MANIFEST, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&recipe); err != nil && err != io.EOF {
+		return recipe, fmt.Errorf("recipe '%s': %s: %w", name, RECIPE_MANIFEST, err)
+	}
+	for i, param := range recipe.Params {
+		if !reRecipeParam.MatchString(param.Name) {
+			return recipe, fmt.Errorf("recipe '%s': invalid parameter name '%s' (lower case letters, digits and '_')", name, param.Name)
+		}
+		if slices.ContainsFunc(recipe.Params[:i], func(p RecipeParam) bool { return p.Name == param.Name }) {
+			return recipe, fmt.Errorf("recipe '%s': duplicate parameter '%s'", name, param.Name)
+		}
+	}
+	if _, err := fs.Stat(fsys, RECIPE_DOCKERFILE); err != nil {
+		return recipe, fmt.Errorf("recipe '%s': no %s", name, RECIPE_DOCKERFILE)
+	}
+	return recipe, nil
+}
+
+// lookupRecipe returns the recipe of the name.
+func lookupRecipe(recipes []Recipe, name string) (Recipe, bool) {
+	i := slices.IndexFunc(recipes, func(r Recipe) bool { return r.Name == name })
+	if i < 0 {
+		return Recipe{}, false
+	}
+	return recipes[i], true
+}
+
+// initBaseImage returns the base image made by `gdocker dev init` for the architecture.
+func initBaseImage(arch string) string {
+	if arch == "arm" {
+		return "ubuntu_a:22.04"
+	}
+	return "ubuntu_x:22.04"
+}
+
+// templateData returns the data of the templates. The parameters are set by "<name>=<value>",
+// and the others are their defaults.
+func (r Recipe) templateData(name, tag, arch string, sets []string) (map[string]any, error) {
+	data := map[string]any{
+		"Name":           name,
+		"Tag":            tag,
+		"Arch":           arch,
+		"BaseImage":      initBaseImage(arch),
+		"GdockerVersion": APP_VERSION,
+	}
+	values := map[string]string{}
+	for _, set := range sets {
+		key, value, ok := strings.Cut(set, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid parameter '%s' (<name>=<value>)", set)
+		}
+		if !slices.ContainsFunc(r.Params, func(p RecipeParam) bool { return p.Name == key }) {
+			return nil, fmt.Errorf("unknown parameter '%s' of recipe '%s' (%s)", key, r.Name, strings.Join(r.paramNames(), ", "))
+		}
+		values[key] = value
+	}
+	for _, param := range r.Params {
+		if value, ok := values[param.Name]; ok {
+			data[param.Name] = value
+			continue
+		}
+		if param.Required {
+			return nil, fmt.Errorf("parameter '%s' of recipe '%s' is required (--set %s=...)", param.Name, r.Name, param.Name)
+		}
+		value, err := renderRecipeTemplate(param.Name, string(param.Default), data)
+		if err != nil {
+			return nil, err
+		}
+		data[param.Name] = value
+	}
+	return data, nil
+}
+
+func (r Recipe) paramNames() []string {
+	var names []string
+	for _, param := range r.Params {
+		names = append(names, param.Name)
+	}
+	return names
+}
+
+// renderRecipeTemplate renders a template. Keys not in the data are errors.
+func renderRecipeTemplate(name, text string, data map[string]any) (string, error) {
+	tmpl, err := template.New(name).Delims("{{<", ">}}").Funcs(RECIPE_FUNCS).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderResources returns the Makefile resources of the tag.
+func (r Recipe) renderResources(data map[string]any) ([]dataMakeResource, error) {
+	var resources []dataMakeResource
+	for i, resource := range r.Resources {
+		res, err := renderRecipeTemplate(fmt.Sprintf("resources[%d]", i), resource.Name, data)
+		if err != nil {
+			return nil, fmt.Errorf("recipe '%s': %w", r.Name, err)
+		}
+		var commands []string
+		for _, command := range resource.Commands {
+			c, err := renderRecipeTemplate(fmt.Sprintf("resources[%d]", i), command, data)
+			if err != nil {
+				return nil, fmt.Errorf("recipe '%s': %w", r.Name, err)
+			}
+			commands = append(commands, c)
+		}
+		resources = append(resources, dataMakeResource{Tag: data["Tag"].(string), Resource: res, Commands: commands})
+	}
+	return resources, nil
+}
+
+// renderTagFiles returns the Dockerfile and the other files of the tag directory.
+func (r Recipe) renderTagFiles(data map[string]any) ([]recipeFile, error) {
+	var files []recipeFile
+
+	// Dockerfile
+	b, err := fs.ReadFile(r.fsys, RECIPE_DOCKERFILE)
+	if err != nil {
+		return nil, err
+	}
+	text, err := renderRecipeTemplate(RECIPE_DOCKERFILE, string(b), data)
+	if err != nil {
+		return nil, fmt.Errorf("recipe '%s': %w", r.Name, err)
+	}
+	spec, err := parseDockerfileSpec([]byte(text), false)
+	if err != nil {
+		return nil, fmt.Errorf("recipe '%s': %s: %w", r.Name, RECIPE_DOCKERFILE, err)
+	}
+	d := NewDockerfile(true)
+	if err := spec.AddTo(&d); err != nil {
+		return nil, fmt.Errorf("recipe '%s': %s: %w", r.Name, RECIPE_DOCKERFILE, err)
+	}
+	d.addFooter()
+	files = append(files, recipeFile{path: "Dockerfile", content: d.Bytes(), perm: 0644})
+
+	// others
+	err = fs.WalkDir(r.fsys, ".", func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || p == RECIPE_MANIFEST || p == RECIPE_DOCKERFILE {
+			return nil
+		}
+		b, err := fs.ReadFile(r.fsys, p)
+		if err != nil {
+			return err
+		}
+		text, err := renderRecipeTemplate(p, string(b), data)
+		if err != nil {
+			return fmt.Errorf("recipe '%s': %w", r.Name, err)
+		}
+		// embedded files have no executable bit
+		perm := fs.FileMode(0644)
+		if info, err := entry.Info(); err == nil && info.Mode()&0111 != 0 || path.Ext(p) == ".sh" {
+			perm = 0755
+		}
+		files = append(files, recipeFile{path: filepath.FromSlash(p), content: []byte(text), perm: perm})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// writeRecipeFile writes the file, or shows it in a box (dry-run).
+func writeRecipeFile(file string, f recipeFile, box bool) {
+	if box {
+		w := &BoxedWriter{Title: anonymizeWd(file, true), Out: os.Stdout}
+		if _, err := w.Write(f.content); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	mkDirAll(filepath.Dir(file))
+	if err := os.WriteFile(file, f.content, f.perm); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// shellQuote quotes the string for the shell if needed.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	RUN_LOG_FILE       = "runs.jsonl"
+	RUN_LOG_LOCAL_FILE = ".gdocker-runs.jsonl"
+)
+
+// RunRecord represents a provenance entry of a container run by `run` or `wdrun`.
+type RunRecord struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Command   string            `json:"command"`
+	Cwd       string            `json:"cwd"`
+	Image     string            `json:"image"`
+	ImageID   string            `json:"image_id"`
+	Digests   []string          `json:"digests,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Argv      []string          `json:"argv"`
+	ExitCode  int               `json:"exit_code"`
+	Duration  float64           `json:"duration_sec"`
+	Hardened  bool              `json:"hardened,omitempty"`
+}
+
+// docker run options which take a value as the next argument.
+var dockerRunValueFlags = []string{
+	"-a", "--attach", "--add-host", "--annotation", "--blkio-weight", "--blkio-weight-device",
+	"-c", "--cpu-shares", "--cap-add", "--cap-drop", "--cgroup-parent", "--cgroupns", "--cidfile",
+	"--cpu-period", "--cpu-quota", "--cpu-rt-period", "--cpu-rt-runtime", "--cpus",
+	"--cpuset-cpus", "--cpuset-mems", "--detach-keys", "--device", "--device-cgroup-rule",
+	"--device-read-bps", "--device-read-iops", "--device-write-bps", "--device-write-iops",
+	"--dns", "--dns-option", "--dns-search", "--domainname", "--entrypoint", "-e", "--env",
+	"--env-file", "--expose", "--gpus", "--group-add", "--health-cmd", "--health-interval",
+	"--health-retries", "--health-start-interval", "--health-start-period", "--health-timeout",
+	"-h", "--hostname", "--ip", "--ip6", "--ipc", "--isolation", "--kernel-memory", "-l", "--label",
+	"--label-file", "--link", "--link-local-ip", "--log-driver", "--log-opt", "--mac-address",
+	"-m", "--memory", "--memory-reservation", "--memory-swap", "--memory-swappiness", "--mount",
+	"--name", "--network", "--network-alias", "--oom-score-adj", "--pid", "--pids-limit",
+	"--platform", "-p", "--publish", "--pull", "--restart", "--runtime", "--security-opt",
+	"--shm-size", "--stop-signal", "--stop-timeout", "--storage-opt", "--sysctl", "--tmpfs",
+	"--ulimit", "-u", "--user", "--userns", "--uts", "-v", "--volume", "--volume-driver",
+	"--volumes-from", "-w", "--workdir",
+}
+
+// findImageArg returns the index of the image name in the arguments of `docker run`.
+// The arguments should start with "run".
+func findImageArg(args []string) (int, bool) {
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			if i+1 < len(args) {
+				return i + 1, true
+			}
+			return -1, false
+		}
+		if !strings.HasPrefix(arg, "-") {
+			return i, true
+		}
+		if strings.Contains(arg, "=") {
+			continue
+		}
+		if slices.Contains(dockerRunValueFlags, arg) {
+			i++
+		}
+	}
+	return -1, false
+}
+
+// newRunRecord creates a RunRecord and resolves the image used by the docker run arguments.
+func newRunRecord(name string, docker_path string, cmdargs []string) *RunRecord {
+	rr := &RunRecord{
+		Timestamp: time.Now(),
+		Command:   name,
+		Cwd:       getWd(),
+		Argv:      append([]string{docker_path}, cmdargs...),
+	}
+	if i, ok := findImageArg(cmdargs); ok {
+		rr.Image = cmdargs[i]
+	}
+	rr.resolveImage(docker_path)
+	return rr
+}
+
+// resolveImage fills image ID, digests and labels of the record.
+// The image may not exist before the run (e.g. pulled by docker run).
+func (rr *RunRecord) resolveImage(docker_path string) {
+	if rr.Image == "" || rr.ImageID != "" {
+		return
+	}
+	ii, err := inspectImage(docker_path, rr.Image)
+	if err != nil {
+		slog.Debug(err.Error())
+		return
+	}
+	rr.ImageID = ii.Hash
+	rr.Digests = ii.Digests
+	rr.Labels = ii.Labels
+}
+
+// finish sets the exit code and the duration of the run.
+func (rr *RunRecord) finish(docker_path string, code int) {
+	rr.ExitCode = code
+	rr.Duration = time.Since(rr.Timestamp).Seconds()
+	rr.resolveImage(docker_path)
+}
+
+// save appends the record to the run log in the state directory,
+// and to the local run log in the working directory if local is true.
+// Failures to write logs are reported as warnings and never stop gdocker.
+func (rr *RunRecord) save(local bool) {
+	files := []string{getRunLogFile()}
+	if local {
+		files = append(files, filepath.Join(rr.Cwd, RUN_LOG_LOCAL_FILE))
+	}
+
+	b, err := json.Marshal(rr)
+	if err != nil {
+		slog.Warn(err.Error())
+		return
+	}
+	b = append(b, '\n')
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			slog.Warn(err.Error())
+			continue
+		}
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			slog.Warn(err.Error())
+			continue
+		}
+		if _, err := f.Write(b); err != nil {
+			slog.Warn(err.Error())
+		}
+		f.Close()
+		slog.Debug(fmt.Sprintf("run log is written to '%s'", file))
+	}
+}
+
+func getRunLogFile() string {
+	return filepath.Join(getGlobalStateDir(), RUN_LOG_FILE)
+}
+
+// readRunRecords reads all records from a JSONL run log file.
+// Broken lines are skipped with a warning.
+func readRunRecords(file string) ([]RunRecord, error) {
+	var rrs []RunRecord
+	f, err := os.Open(file)
+	if err != nil {
+		return rrs, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	n := 0
+	for s.Scan() {
+		n++
+		if len(strings.TrimSpace(s.Text())) == 0 {
+			continue
+		}
+		var rr RunRecord
+		if err := json.Unmarshal(s.Bytes(), &rr); err != nil {
+			slog.Warn(fmt.Sprintf("%s:%d: %s", file, n, err.Error()))
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, s.Err()
+}
+
+// matchImage reports whether the record used the image specified by name, name:tag or ID prefix.
+func (rr *RunRecord) matchImage(query string) bool {
+	if query == "" {
+		return true
+	}
+	if rr.Image == query {
+		return true
+	}
+	if img, err := NewDockerImage(query); err == nil && !strings.Contains(query, ":") {
+		if i, err := NewDockerImage(rr.Image); err == nil && i.Name == img.Name {
+			return true
+		}
+	}
+	id := strings.TrimPrefix(rr.ImageID, "sha256:")
+	q := strings.TrimPrefix(query, "sha256:")
+	return len(q) >= 4 && strings.HasPrefix(id, q)
+}
+
+// matchDir reports whether the record was run in the directory (or its sub directories).
+func (rr *RunRecord) matchDir(dir string) bool {
+	if dir == "" {
+		return true
+	}
+	return isParentDir(dir, rr.Cwd)
+}
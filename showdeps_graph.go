@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// output formats of showdeps
+const (
+	FORMAT_MERMAID  = "mermaid"
+	FORMAT_DOT      = "dot"
+	FORMAT_PLANTUML = "plantuml"
+	FORMAT_JSON     = "json"
+	FORMAT_YAML     = "yaml"
+)
+
+var SHOWDEPS_FORMATS = []string{FORMAT_MERMAID, FORMAT_DOT, FORMAT_PLANTUML, FORMAT_JSON, FORMAT_YAML}
+
+// node classes of the dependency graph (same as the classDef of TMPL_MERMAID)
+const (
+	NODE_CLASS_ROOT         = "root"
+	NODE_CLASS_LATEST       = "latest"
+	NODE_CLASS_LATEST_IMAGE = "latest-image"
+	NODE_CLASS_OLD          = "old"
+)
+
+// fill colors of the node classes
+var NODE_CLASS_COLORS = map[string]string{
+	NODE_CLASS_ROOT:         "#8BA7D5",
+	NODE_CLASS_LATEST:       "#E38692",
+	NODE_CLASS_LATEST_IMAGE: "#F6D580",
+	NODE_CLASS_OLD:          "#81D674",
+}
+
+// build status of the nodes
+const (
+	NODE_STATUS_BUILT            = "built"            // built locally
+	NODE_STATUS_NOT_BUILT        = "not-built"        // has a build directory, but not built yet
+	NODE_STATUS_EXTERNAL_PRESENT = "external-present" // external base image pulled
+	NODE_STATUS_EXTERNAL_MISSING = "external-missing" // external base image not pulled
+)
+
+// class names of the build status in the Mermaid flowchart
+var NODE_STATUS_MERMAID_CLASSES = map[string]string{
+	NODE_STATUS_BUILT:            "built",
+	NODE_STATUS_NOT_BUILT:        "notbuilt",
+	NODE_STATUS_EXTERNAL_PRESENT: "extpresent",
+	NODE_STATUS_EXTERNAL_MISSING: "extmissing",
+}
+
+// styles of the classes in the Mermaid flowchart (classDef). The SVG image of the web page
+// is styled by the same definitions, so that it looks the same as the flowchart.
+var NODE_STYLES = []struct {
+	mermaid string // class name in the Mermaid flowchart
+	svg     string // class name in the SVG image (see GraphNode.classes)
+	style   string
+}{
+	{"root", NODE_CLASS_ROOT, "fill:" + NODE_CLASS_COLORS[NODE_CLASS_ROOT] + ",color:#000000"},
+	{"latest", NODE_CLASS_LATEST, "fill:" + NODE_CLASS_COLORS[NODE_CLASS_LATEST] + ",color:#000000"},
+	{"latestimg", NODE_CLASS_LATEST_IMAGE, "fill:" + NODE_CLASS_COLORS[NODE_CLASS_LATEST_IMAGE] + ",color:#000000"},
+	{"old", NODE_CLASS_OLD, "fill:" + NODE_CLASS_COLORS[NODE_CLASS_OLD] + ",color:#000000"},
+	{"built", "status-" + NODE_STATUS_BUILT, "stroke:#2E7D32,stroke-width:2px"},
+	{"notbuilt", "status-" + NODE_STATUS_NOT_BUILT, "stroke:#C62828,stroke-width:2px,stroke-dasharray:5 5"},
+	{"extpresent", "status-" + NODE_STATUS_EXTERNAL_PRESENT, "stroke:#2E7D32,stroke-width:2px"},
+	{"extmissing", "status-" + NODE_STATUS_EXTERNAL_MISSING, "stroke:#C62828,stroke-width:2px,stroke-dasharray:5 5"},
+}
+
+// mermaidClassDefs returns the "classDef" statements of NODE_STYLES.
+func mermaidClassDefs() []string {
+	var defs []string
+	for _, s := range NODE_STYLES {
+		defs = append(defs, fmt.Sprintf("classDef %s %s", s.mermaid, s.style))
+	}
+	return defs
+}
+
+// nodeStatus returns the build status of the image.
+// Images without a build directory (e.g. "ubuntu:22.04") are external.
+// The status is unknown ("") if the local images could not be queried (eimages is nil).
+func nodeStatus(di DockerImage, ibds ImageBuildDirs, eimages ExistImages) string {
+	if eimages == nil {
+		return ""
+	}
+	_, managed := ibds.mapNameTag[di.String()]
+	switch exist := eimages.checkExist(di); {
+	case managed && exist:
+		return NODE_STATUS_BUILT
+	case managed:
+		return NODE_STATUS_NOT_BUILT
+	case exist:
+		return NODE_STATUS_EXTERNAL_PRESENT
+	}
+	return NODE_STATUS_EXTERNAL_MISSING
+}
+
+// needsWork reports whether the image still has to be built or pulled.
+func needsWork(status string) bool {
+	return status == NODE_STATUS_NOT_BUILT || status == NODE_STATUS_EXTERNAL_MISSING
+}
+
+// filterMissingOnly keeps the dependencies of the images which still need work.
+// The images they depend on are kept as well, so the graph shows where to start.
+func filterMissingOnly(deps []Dependency, ibds ImageBuildDirs, eimages ExistImages) []Dependency {
+	var filtered []Dependency
+	for _, dep := range deps {
+		if needsWork(nodeStatus(dep.From, ibds, eimages)) {
+			filtered = append(filtered, dep)
+		}
+	}
+	return filtered
+}
+
+// mermaidStatusClasses returns the "class" statements assigning the build status to the nodes.
+func mermaidStatusClasses(deps []Dependency, ibds ImageBuildDirs, eimages ExistImages) []string {
+	if eimages == nil {
+		return nil
+	}
+	ids := make(map[string][]string)
+	seen := make(map[string]struct{})
+	for _, dep := range deps {
+		for _, di := range []DockerImage{dep.From, dep.To} {
+			if _, ok := seen[di.String()]; ok {
+				continue
+			}
+			seen[di.String()] = struct{}{}
+			status := nodeStatus(di, ibds, eimages)
+			ids[status] = append(ids[status], di.String())
+		}
+	}
+
+	var classes []string
+	for _, status := range []string{NODE_STATUS_BUILT, NODE_STATUS_NOT_BUILT, NODE_STATUS_EXTERNAL_PRESENT, NODE_STATUS_EXTERNAL_MISSING} {
+		if len(ids[status]) > 0 {
+			classes = append(classes, fmt.Sprintf("class %s %s", strings.Join(ids[status], ","), NODE_STATUS_MERMAID_CLASSES[status]))
+		}
+	}
+	return classes
+}
+
+// GraphNode represents an image in the dependency graph.
+type GraphNode struct {
+	ID        string `json:"id"` // name:tag
+	Name      string `json:"name"`
+	Tag       string `json:"tag"`
+	Class     string `json:"class"`
+	Built     bool   `json:"built"`
+	BuildDir  string `json:"build_dir,omitempty"`
+	ImageRoot string `json:"image_root,omitempty"`
+	Status    string `json:"status,omitempty"` // build status (NODE_STATUS_*), omitted if unknown
+
+	annotation string // image root annotated in the Mermaid flowchart (with several image roots)
+}
+
+// GraphEdge represents that the image From depends on the image To.
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// DepGraph is the dependency graph shown by showdeps.
+type DepGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+
+	mermaid string // the Mermaid flowchart printed by showdeps
+}
+
+// newDepGraph makes the graph from the dependencies computed by showdeps.
+// Nodes are listed in order of appearance.
+func newDepGraph(deps []Dependency, ibds ImageBuildDirs, eimages ExistImages) DepGraph {
+	var g DepGraph
+	index := make(map[string]int)
+	addNode := func(di DockerImage) {
+		if _, ok := index[di.String()]; ok {
+			return
+		}
+		node := GraphNode{
+			ID:        di.String(),
+			Name:      di.Name,
+			Tag:       di.Tag,
+			Class:     NODE_CLASS_OLD,
+			Built:     eimages.checkExist(di),
+			ImageRoot: ibds.RootOf(di.String()),
+			Status:    nodeStatus(di, ibds, eimages),
+
+			annotation: di.Root,
+		}
+		if i, ok := ibds.mapNameTag[di.String()]; ok && di.Tag != "latest" {
+			node.BuildDir = filepath.Join(ibds.ibds[i].Directory(), di.Tag)
+		}
+		index[di.String()] = len(g.Nodes)
+		g.Nodes = append(g.Nodes, node)
+	}
+
+	for _, dep := range deps {
+		addNode(dep.From)
+		addNode(dep.To)
+		g.Edges = append(g.Edges, GraphEdge{dep.From.String(), dep.To.String()})
+	}
+
+	// classes are decided in the same way as printNode
+	for _, dep := range deps {
+		to := &g.Nodes[index[dep.To.String()]]
+		if dep.From.Tag == "latest" && to.Class == NODE_CLASS_OLD {
+			to.Class = NODE_CLASS_LATEST_IMAGE
+		}
+		if dep.To.IsRoot {
+			to.Class = NODE_CLASS_ROOT
+		}
+	}
+	for i := range g.Nodes {
+		if g.Nodes[i].Tag == "latest" && g.Nodes[i].Class != NODE_CLASS_ROOT {
+			g.Nodes[i].Class = NODE_CLASS_LATEST
+		}
+	}
+	g.mermaid = mermaidFlowchart(deps, ibds, eimages, false)
+	return g
+}
+
+// label returns the text shown in the node.
+func (n GraphNode) label() string {
+	label := n.ID
+	if n.Class == NODE_CLASS_ROOT {
+		label += " [root]"
+	}
+	return label
+}
+
+// writeDepGraph writes the graph in the format (except mermaid, which is written by TMPL_MERMAID).
+func writeDepGraph(w io.Writer, g DepGraph, format string) error {
+	switch format {
+	case FORMAT_DOT:
+		return writeDOT(w, g)
+	case FORMAT_PLANTUML:
+		return writePlantUML(w, g)
+	case FORMAT_JSON:
+		b, err := json.MarshalIndent(g, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case FORMAT_YAML:
+		b, err := marshalYAML(g)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+	return fmt.Errorf("unknown format '%s' (one of %s)", format, strings.Join(SHOWDEPS_FORMATS, ", "))
+}
+
+func writeDOT(w io.Writer, g DepGraph) error {
+	var b strings.Builder
+	b.WriteString("digraph dependencies {\n")
+	b.WriteString("    rankdir=TB;\n")
+	b.WriteString("    node [shape=box, style=\"rounded,filled\", fontcolor=\"#000000\"];\n\n")
+	for _, n := range g.Nodes {
+		attrs := fmt.Sprintf("label=%q, class=%q, fillcolor=%q", n.label(), n.Class, NODE_CLASS_COLORS[n.Class])
+		if n.Class == NODE_CLASS_ROOT {
+			attrs += ", style=filled, peripheries=2"
+		}
+		if needsWork(n.Status) {
+			attrs += ", color=\"#C62828\", penwidth=2"
+		}
+		fmt.Fprintf(&b, "    %q [%s];\n", n.ID, attrs)
+	}
+	b.WriteString("\n")
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "    %q -> %q;\n", e.From, e.To)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writePlantUML(w io.Writer, g DepGraph) error {
+	var b strings.Builder
+	b.WriteString("@startuml\n")
+	b.WriteString("skinparam defaultTextAlignment center\n\n")
+	ids := make([]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		ids[i] = fmt.Sprintf("n%d", i)
+		fmt.Fprintf(&b, "rectangle %q <<%s>> as %s %s\n", n.label(), n.Class, ids[i], NODE_CLASS_COLORS[n.Class])
+	}
+	b.WriteString("\n")
+	for _, e := range g.Edges {
+		from := slices.IndexFunc(g.Nodes, func(n GraphNode) bool { return n.ID == e.From })
+		to := slices.IndexFunc(g.Nodes, func(n GraphNode) bool { return n.ID == e.To })
+		fmt.Fprintf(&b, "%s --> %s\n", ids[from], ids[to])
+	}
+	b.WriteString("@enduml\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+)
+
+// layout parameters of the SVG dependency graph (in pixels)
+const (
+	SVG_CHAR_WIDTH   = 7.5
+	SVG_NODE_PADDING = 24.0
+	SVG_NODE_HEIGHT  = 36.0
+	SVG_GAP_X        = 28.0
+	SVG_GAP_Y        = 64.0
+	SVG_MARGIN       = 20.0
+
+	SVG_SUBROUTINE_INSET = 6.0 // inset of the vertical lines of the root node
+)
+
+// svgNode is a node placed in the layered layout.
+type svgNode struct {
+	GraphNode
+	layer int
+	x, y  float64 // top left corner
+	w     float64
+}
+
+// layoutDepGraph places nodes in layers like a top-down flowchart:
+// an image is placed above the images it depends on.
+// Nodes in each layer are ordered by the barycenter of their neighbours to reduce crossings.
+func layoutDepGraph(g DepGraph) ([]svgNode, float64, float64) {
+	index := make(map[string]int, len(g.Nodes))
+	nodes := make([]svgNode, len(g.Nodes))
+	for i, n := range g.Nodes {
+		index[n.ID] = i
+		nodes[i] = svgNode{GraphNode: n, w: float64(len(n.svgLabel()))*SVG_CHAR_WIDTH + SVG_NODE_PADDING}
+	}
+
+	// longest path layering (the graph is a DAG, so it converges within len(nodes) rounds)
+	for range len(nodes) {
+		changed := false
+		for _, e := range g.Edges {
+			from, to := index[e.From], index[e.To]
+			if nodes[to].layer < nodes[from].layer+1 {
+				nodes[to].layer = nodes[from].layer + 1
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	var layers [][]int
+	for i, n := range nodes {
+		for len(layers) <= n.layer {
+			layers = append(layers, nil)
+		}
+		layers[n.layer] = append(layers[n.layer], i)
+	}
+
+	// barycenter ordering, sweeping down and then up
+	pos := make([]float64, len(nodes))
+	updatePos := func() {
+		for _, layer := range layers {
+			for j, i := range layer {
+				pos[i] = float64(j)
+			}
+		}
+	}
+	updatePos()
+	sweep := func(l int, neighbours func(i int) []int) {
+		bary := make(map[int]float64, len(layers[l]))
+		for _, i := range layers[l] {
+			ns := neighbours(i)
+			if len(ns) == 0 {
+				bary[i] = pos[i]
+				continue
+			}
+			sum := 0.0
+			for _, n := range ns {
+				sum += pos[n]
+			}
+			bary[i] = sum / float64(len(ns))
+		}
+		slices.SortStableFunc(layers[l], func(a, b int) int {
+			switch {
+			case bary[a] < bary[b]:
+				return -1
+			case bary[a] > bary[b]:
+				return 1
+			}
+			return 0
+		})
+		updatePos()
+	}
+	parents := func(i int) []int {
+		var ns []int
+		for _, e := range g.Edges {
+			if e.To == nodes[i].ID {
+				ns = append(ns, index[e.From])
+			}
+		}
+		return ns
+	}
+	children := func(i int) []int {
+		var ns []int
+		for _, e := range g.Edges {
+			if e.From == nodes[i].ID {
+				ns = append(ns, index[e.To])
+			}
+		}
+		return ns
+	}
+	for range 4 {
+		for l := 1; l < len(layers); l++ {
+			sweep(l, parents)
+		}
+		for l := len(layers) - 2; l >= 0; l-- {
+			sweep(l, children)
+		}
+	}
+
+	// coordinates: each layer is centered
+	width := 0.0
+	widths := make([]float64, len(layers))
+	for l, layer := range layers {
+		for _, i := range layer {
+			widths[l] += nodes[i].w
+		}
+		widths[l] += SVG_GAP_X * float64(max(len(layer)-1, 0))
+		width = max(width, widths[l])
+	}
+	for l, layer := range layers {
+		x := SVG_MARGIN + (width-widths[l])/2
+		for _, i := range layer {
+			nodes[i].x = x
+			nodes[i].y = SVG_MARGIN + float64(l)*(SVG_NODE_HEIGHT+SVG_GAP_Y)
+			x += nodes[i].w + SVG_GAP_X
+		}
+	}
+	height := float64(len(layers))*(SVG_NODE_HEIGHT+SVG_GAP_Y) - SVG_GAP_Y
+	return nodes, width + 2*SVG_MARGIN, max(height, 0) + 2*SVG_MARGIN
+}
+
+// renderSVG renders the dependency graph as a standalone SVG image.
+// Nodes have the class names of GraphNode and the "data-id" attribute (name:tag).
+func renderSVG(g DepGraph) string {
+	nodes, width, height := layoutDepGraph(g)
+	index := make(map[string]int, len(nodes))
+	for i, n := range nodes {
+		index[n.ID] = i
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" class="depgraph" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f">`+"\n", width, height, width, height)
+	b.WriteString(`<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto-start-reverse"><path d="M 0 0 L 10 5 L 0 10 z" fill="#333333"/></marker></defs>` + "\n")
+	b.WriteString("<style>\n")
+	b.WriteString(".depgraph text { font: 13px sans-serif; fill: #000000; dominant-baseline: central; text-anchor: middle; }\n")
+	b.WriteString(".depgraph .edge { stroke: #333333; stroke-width: 1.2; fill: none; }\n")
+	b.WriteString(".depgraph .node rect, .depgraph .node line { stroke: #333333; stroke-width: 1; }\n")
+	for _, s := range NODE_STYLES {
+		b.WriteString(svgNodeStyle(s.svg, s.style))
+	}
+	b.WriteString("</style>\n")
+
+	for _, e := range g.Edges {
+		from, to := nodes[index[e.From]], nodes[index[e.To]]
+		x1, y1 := from.x+from.w/2, from.y+SVG_NODE_HEIGHT
+		x2, y2 := to.x+to.w/2, to.y
+		my := (y1 + y2) / 2
+		fmt.Fprintf(&b, `<path class="edge" d="M %.1f %.1f C %.1f %.1f, %.1f %.1f, %.1f %.1f" marker-end="url(#arrow)"/>`+"\n",
+			x1, y1, x1, my, x2, my, x2, y2)
+	}
+	for _, n := range nodes {
+		// the shapes of the flowchart: the root is a subroutine ([[ ]]), and the others are rounded (( ))
+		rx := 8
+		shape := ""
+		if n.Class == NODE_CLASS_ROOT {
+			rx = 0
+			for _, x := range []float64{n.x + SVG_SUBROUTINE_INSET, n.x + n.w - SVG_SUBROUTINE_INSET} {
+				shape += fmt.Sprintf(`<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/>`, x, n.y, x, n.y+SVG_NODE_HEIGHT)
+			}
+		}
+		fmt.Fprintf(&b, `<g class="%s" data-id="%s"><title>%s</title><rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" rx="%d"/>%s<text x="%.1f" y="%.1f">%s</text></g>`+"\n",
+			html.EscapeString(strings.Join(n.classes(), " ")), html.EscapeString(n.ID), html.EscapeString(n.title()),
+			n.x, n.y, n.w, SVG_NODE_HEIGHT, rx, shape,
+			n.x+n.w/2, n.y+SVG_NODE_HEIGHT/2, html.EscapeString(n.svgLabel()))
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+// svgNodeStyle returns the CSS of the class of nodes from the style of classDef in the Mermaid flowchart
+// (e.g. "fill:#8BA7D5,color:#000000"). color is of the text, and the others are of the shape.
+func svgNodeStyle(class, style string) string {
+	var shape, text []string
+	for _, decl := range strings.Split(style, ",") {
+		prop, value, _ := strings.Cut(decl, ":")
+		if prop == "color" {
+			text = append(text, "fill: "+value+";")
+			continue
+		}
+		shape = append(shape, prop+": "+value+";")
+	}
+	css := fmt.Sprintf(".depgraph .node.%s rect { %s }\n", class, strings.Join(shape, " "))
+	if len(text) > 0 {
+		css += fmt.Sprintf(".depgraph .node.%s text { %s }\n", class, strings.Join(text, " "))
+	}
+	return css
+}
+
+// svgLabel returns the text of the node as printNode of the Mermaid flowchart.
+func (n GraphNode) svgLabel() string {
+	label := n.ID
+	if n.annotation != "" {
+		label = fmt.Sprintf("%s @%s", label, n.annotation)
+	}
+	if n.Class == NODE_CLASS_ROOT {
+		label += " [root]"
+	}
+	return label
+}
+
+// classes returns the class names of the node in the SVG image.
+func (n GraphNode) classes() []string {
+	classes := []string{"node", n.Class}
+	if n.Status != "" {
+		classes = append(classes, "status-"+n.Status)
+	}
+	return classes
+}
+
+// title returns the tooltip of the node.
+func (n GraphNode) title() string {
+	title := n.ID
+	if n.ImageRoot != "" {
+		title = fmt.Sprintf("%s @%s", title, n.ImageRoot)
+	}
+	if n.Status != "" {
+		title = fmt.Sprintf("%s (%s)", title, n.Status)
+	}
+	return title
+}
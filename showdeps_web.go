@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// assets of the web page are embedded, so the page works without internet access.
+//
+//go:embed web/showdeps.html web/showdeps.css web/showdeps.js
+//go:embed web/dashboard.html web/dashboard.css web/dashboard.js
+var webAssets embed.FS
+
+const WATCH_INTERVAL = time.Second
+
+type dataShowDepsPage struct {
+	Title     string
+	Generated string
+	CSS       template.CSS
+	JS        template.JS
+	SVG       template.HTML
+	Mermaid   string // the Mermaid flowchart printed by showdeps
+	Live      bool
+	Error     string // error of the last reload (the graph is the last good one)
+}
+
+// renderShowDepsPage renders the dependency graph as a self-contained HTML page.
+// If live is true, the page polls the server to reload the graph.
+// loadErr is the error of the last reload shown in the page.
+func renderShowDepsPage(g DepGraph, live bool, loadErr string) ([]byte, error) {
+	tmpl, err := template.ParseFS(webAssets, "web/showdeps.html")
+	if err != nil {
+		return nil, err
+	}
+	css, err := webAssets.ReadFile("web/showdeps.css")
+	if err != nil {
+		return nil, err
+	}
+	js, err := webAssets.ReadFile("web/showdeps.js")
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	err = tmpl.Execute(&b, dataShowDepsPage{
+		Title:     "gdocker showdeps",
+		Generated: "generated at " + time.Now().Format("2006-01-02 15:04:05"),
+		CSS:       template.CSS(css),
+		JS:        template.JS(js),
+		SVG:       template.HTML(renderSVG(g)),
+		Mermaid:   g.mermaid,
+		Live:      live,
+		Error:     loadErr,
+	})
+	return b.Bytes(), err
+}
+
+// writeShowDepsPage writes the standalone HTML page of the dependency graph.
+func writeShowDepsPage(file string, g DepGraph) error {
+	b, err := renderShowDepsPage(g, false, "")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0666)
+}
+
+// depGraphServer serves the dependency graph, and reloads it when the image roots are changed.
+type depGraphServer struct {
+	load  func() (DepGraph, error)
+	roots []ImageRoot
+
+	mu      sync.RWMutex
+	graph   DepGraph
+	err     error // error of the last load
+	version int
+}
+
+func newDepGraphServer(load func() (DepGraph, error), roots []ImageRoot) *depGraphServer {
+	s := &depGraphServer{load: load, roots: roots}
+	s.reload()
+	return s
+}
+
+func (s *depGraphServer) current() (DepGraph, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.graph, s.version
+}
+
+// lastError returns the error of the last load, or an empty string.
+func (s *depGraphServer) lastError() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.err == nil {
+		return ""
+	}
+	return s.err.Error()
+}
+
+// watch polls Dockerfiles and Makefiles in the image roots, and reloads the graph when they are changed.
+func (s *depGraphServer) watch(interval time.Duration) {
+	last := fingerprintImageRoots(s.roots)
+	for range time.Tick(interval) {
+		fp := fingerprintImageRoots(s.roots)
+		if fp == last {
+			continue
+		}
+		last = fp
+		slog.Info("image build directories are changed. reload the graph.")
+		s.reload()
+	}
+}
+
+// reload loads the graph again and notifies the pages. If the load fails
+// (e.g. a Dockerfile being edited makes a cycle), the last good graph is kept
+// and the error is shown in the pages.
+func (s *depGraphServer) reload() {
+	g, err := s.load()
+	if err != nil {
+		logDependencyError(err)
+	}
+	s.mu.Lock()
+	if err == nil {
+		s.graph = g
+	}
+	s.err = err
+	s.version++
+	s.mu.Unlock()
+}
+
+// handler returns the handler of the showdeps page.
+func (s *depGraphServer) handler() http.Handler {
+	mux := s.mux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		g, _ := s.current()
+		b, err := renderShowDepsPage(g, true, s.lastError())
+		if err != nil {
+			http.Error(w, "template execute error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(b)
+	})
+	return mux
+}
+
+// mux returns a ServeMux which serves the graph ("/graph.svg" and the Mermaid flowchart "/graph.mmd"),
+// its version ("/version") and the error of the last load ("/error", empty if none).
+func (s *depGraphServer) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /graph.svg", func(w http.ResponseWriter, r *http.Request) {
+		g, _ := s.current()
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(renderSVG(g)))
+	})
+	mux.HandleFunc("GET /graph.mmd", func(w http.ResponseWriter, r *http.Request) {
+		g, _ := s.current()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(g.mermaid))
+	})
+	mux.HandleFunc("GET /version", func(w http.ResponseWriter, r *http.Request) {
+		_, v := s.current()
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strconv.Itoa(v)))
+	})
+	mux.HandleFunc("GET /error", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(s.lastError()))
+	})
+	return mux
+}
+
+// serve starts watching the image roots and serves the handler at the address.
+func (s *depGraphServer) serve(addr string, handler http.Handler) error {
+	go s.watch(WATCH_INTERVAL)
+	slog.Warn(fmt.Sprintf("Server started url http://%s/", addr))
+	return http.ListenAndServe(addr, handler)
+}
+
+// fingerprintImageRoots returns a hash of paths, sizes and modification times
+// of Dockerfiles and Makefiles in the image roots.
+func fingerprintImageRoots(roots []ImageRoot) uint64 {
+	h := fnv.New64a()
+	for _, root := range roots {
+		filepath.WalkDir(root.Dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() && d.Name() == "archive" {
+				return filepath.SkipDir
+			}
+			if d.IsDir() || (d.Name() != "Dockerfile" && d.Name() != "Makefile") {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			fmt.Fprintf(h, "%s\t%d\t%d\n", path, info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+	}
+	return h.Sum64()
+}
@@ -2,25 +2,37 @@ package main
 
 import (
 	"fmt"
-	"log/slog"
-	"os"
+	"slices"
 	"strings"
 )
 
-type Node[T comparable] map[T]struct{}
-
 type Graph[T comparable] struct {
-	// 隣接しているNodeを表す。Node名のマップで、要素がNodeのマップ
-	// adjacencyの各要素がグラフ内のNodeを表し、各要素の長さが入次数をしめす。
-	adjacency map[T]Node[T]
+	// 隣接しているNodeを表す。Node名のマップで、要素は隣接Nodeのスライス
+	// 辺を追加した順序 (Dockerfileでの出現順) を保持し、走査順序を決定的にする。
+	adjacency map[T][]T
+	nodes     []T // Nodeを追加した順序
 }
 
 func NewGraph[T comparable]() *Graph[T] {
 	return &Graph[T]{
-		adjacency: make(map[T]Node[T]),
+		adjacency: make(map[T][]T),
 	}
 }
 
+// CycleError is returned when the graph has a cycle.
+// Path starts and ends with the same node (e.g. a -> b -> a).
+type CycleError[T comparable] struct {
+	Path []T
+}
+
+func (e *CycleError[T]) Error() string {
+	strPath := make([]string, len(e.Path))
+	for i, n := range e.Path {
+		strPath[i] = fmt.Sprintf("%v", n)
+	}
+	return fmt.Sprintf("cycle error: %s", strings.Join(strPath, " -> "))
+}
+
 func (g *Graph[T]) HasNode(node T) bool {
 	_, exists := g.adjacency[node]
 	return exists
@@ -28,7 +40,8 @@ func (g *Graph[T]) HasNode(node T) bool {
 
 func (g *Graph[T]) AddNode(node T) {
 	if !g.HasNode(node) {
-		g.adjacency[node] = make(Node[T])
+		g.adjacency[node] = nil
+		g.nodes = append(g.nodes, node)
 	}
 }
 
@@ -36,12 +49,14 @@ func (g *Graph[T]) AddNode(node T) {
 func (g *Graph[T]) AddEdge(from T, to T) {
 	g.AddNode(from)
 	g.AddNode(to)
-	g.adjacency[from][to] = struct{}{}
+	if !slices.Contains(g.adjacency[from], to) {
+		g.adjacency[from] = append(g.adjacency[from], to)
+	}
 }
 
 // トポロジカルソートを行う
-// 引数のノードを起点として全ての到達可能ノードをDFS
-// サイクルがあればエラーを返す
+// 引数のノードを起点として全ての到達可能ノードをDFS (辺を追加した順に走査する)
+// サイクルがあれば *CycleError を返す
 func (g *Graph[T]) TopSort(start T) ([]T, error) {
 	var result []T
 
@@ -50,31 +65,26 @@ func (g *Graph[T]) TopSort(start T) ([]T, error) {
 	}
 
 	visited := make(map[T]bool)
-	active := make(map[T]bool) // 現在の探索経路上にあるか
+	var stack []T // 現在の探索経路
 	var dfs func(T) error
 	dfs = func(curr T) error {
 		// すでに処理済みならスキップ
 		if visited[curr] {
 			return nil
 		}
-		if active[curr] {
-			// サイクル検出。activeの中からcurrが出現するまでのノードを抽出
-			cycle := g.extractCyclePath(active, curr)
-			strCycle := make([]string, len(cycle))
-			for i, c := range cycle {
-				strCycle[i] = fmt.Sprintf("%v", c)
-			}
-			slog.Error(fmt.Sprintf("Cycle error: %s\n", strings.Join(strCycle, " -> ")))
-			os.Exit(1)
+		if i := slices.Index(stack, curr); i >= 0 {
+			// サイクル検出。探索経路のcurrから先がサイクルになる
+			path := append(slices.Clone(stack[i:]), curr)
+			return &CycleError[T]{Path: path}
 		}
 
-		active[curr] = true
-		for nxt := range g.adjacency[curr] {
+		stack = append(stack, curr)
+		for _, nxt := range g.adjacency[curr] {
 			if err := dfs(nxt); err != nil {
 				return err
 			}
 		}
-		active[curr] = false
+		stack = stack[:len(stack)-1]
 		visited[curr] = true
 		result = append(result, curr)
 		return nil
@@ -87,27 +97,86 @@ func (g *Graph[T]) TopSort(start T) ([]T, error) {
 	return result, nil
 }
 
-func (g *Graph[T]) extractCyclePath(active map[T]bool, start T) []T {
-	var cycle []T
-	for k := range active {
-		cycle = append(cycle, k)
+// Cycles returns a cycle of each strongly connected component reachable from the nodes
+// (all nodes if none is given), in a stable order. It returns nil if there is no cycle.
+func (g *Graph[T]) Cycles(starts ...T) []*CycleError[T] {
+	if len(starts) == 0 {
+		starts = g.nodes
+	}
+
+	// Tarjan's algorithm
+	index := make(map[T]int)
+	lowlink := make(map[T]int)
+	onStack := make(map[T]bool)
+	var stack []T
+	var sccs [][]T
+	var strongconnect func(T)
+	strongconnect = func(v T) {
+		index[v] = len(index)
+		lowlink[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range g.adjacency[v] {
+			if _, ok := index[w]; !ok {
+				strongconnect(w)
+				lowlink[v] = min(lowlink[v], lowlink[w])
+			} else if onStack[w] {
+				lowlink[v] = min(lowlink[v], index[w])
+			}
+		}
+		if lowlink[v] == index[v] {
+			var scc []T
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				scc = append(scc, w)
+				if w == v {
+					break
+				}
+			}
+			sccs = append(sccs, scc)
+		}
+	}
+	for _, s := range starts {
+		if _, ok := index[s]; !ok && g.HasNode(s) {
+			strongconnect(s)
+		}
+	}
+
+	var cycles []*CycleError[T]
+	for _, scc := range sccs {
+		if len(scc) == 1 && !slices.Contains(g.adjacency[scc[0]], scc[0]) {
+			continue
+		}
+		// 最初に訪れたノードから、成分内の辺だけを辿って戻る経路を探す
+		first := slices.MinFunc(scc, func(a, b T) int { return index[a] - index[b] })
+		cycles = append(cycles, &CycleError[T]{Path: g.cyclePath(first, scc)})
 	}
-	cycle = append(cycle, start)
-	return cycle
+	slices.SortStableFunc(cycles, func(a, b *CycleError[T]) int { return index[a.Path[0]] - index[b.Path[0]] })
+	return cycles
 }
 
-//func main() {
-//	g := NewGraph[string]()
-//	g.AddNode("A")
-//	g.AddNode("B")
-//	g.AddEdge("A", "B")
-//	g.AddEdge("B", "C")
-//	g.AddEdge("C", "D")
-//
-//	order, err := g.TopSort("A")
-//	if err != nil {
-//		fmt.Println(err)
-//	} else {
-//		fmt.Println("Topological Order:", order)
-//	}
-//}
+// cyclePath returns a path from start back to start through the nodes of the component.
+func (g *Graph[T]) cyclePath(start T, component []T) []T {
+	visited := make(map[T]bool)
+	var path []T
+	var dfs func(T) bool
+	dfs = func(curr T) bool {
+		path = append(path, curr)
+		visited[curr] = true
+		for _, nxt := range g.adjacency[curr] {
+			if nxt == start {
+				path = append(path, start)
+				return true
+			}
+			if !visited[nxt] && slices.Contains(component, nxt) && dfs(nxt) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	dfs(start)
+	return path
+}
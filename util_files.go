@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func isDir(path string) bool {
@@ -28,6 +29,12 @@ func isFile(path string) bool {
 	}
 }
 
+// isParentDir reports whether dir is the same as or a parent directory of path.
+func isParentDir(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func relativeTo(path string, base string) string {
 	// Convert path to absolute path
 	absPath, err := filepath.Abs(path)
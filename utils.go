@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -127,3 +128,25 @@ func execCommand(dir, cmd string, args []string) {
 		os.Exit(1)
 	}
 }
+
+// Run a command and return its exit code instead of exiting on failure.
+func runCommand(dir, cmd string, args []string) int {
+	subcmd := exec.Command(cmd, args...)
+	subcmd.Dir = dir
+	subcmd.Stdout = os.Stdout
+	subcmd.Stderr = os.Stderr
+	return exitCode(subcmd.Run())
+}
+
+// Convert an error from exec.Cmd into an exit code.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	slog.Error(err.Error())
+	return 1
+}
@@ -79,6 +79,15 @@ if [ $ECHO -eq 1 ] ; then
 else
     :
 fi
+
+# already running as an unprivileged user (e.g. "gdocker run --hardened").
+# /etc may be read-only, so run the command without adding and switching the user.
+if [ "$(id -u)" -ne 0 ] ; then
+    export HOME=${HOME:-/tmp}
+    mkdir -p ${HOME}
+    exec "$@"
+fi
+
 useradd -u $USER_ID -o -m user
 groupmod -g $GROUP_ID -o user
 export HOME=/home/user
@@ -209,6 +218,19 @@ var (
 		Usage:    "resource",
 		Required: false,
 	}
+	FLAG_RECIPE = &cli.StringFlag{
+		Name:    "recipe",
+		Aliases: []string{"r"},
+		Usage:   "recipe (`NAME`) of the image directory",
+	}
+	FLAG_RECIPE_SET = &cli.StringSliceFlag{
+		Name:  "set",
+		Usage: "set a parameter of the recipe (`NAME=VALUE`)",
+	}
+	FLAG_RECIPE_LIST = &cli.BoolFlag{
+		Name:  "list",
+		Usage: "list the recipes and their parameters",
+	}
 	FLAG_ARCH = &cli.StringFlag{
 		Name:    "arch",
 		Aliases: []string{"a"},
@@ -231,8 +253,8 @@ var (
 	FLAG_CONFIG_DEFAULT = &cli.StringSliceFlag{
 		Name:        "config",
 		Usage:       "configuration file\n\t",
-		DefaultText: fmt.Sprintf("`%s`, `./gdocker_conf.json`", anonymizeConfigFile(getGlobalConfigFile(), false)),
-		Value:       []string{getGlobalConfigFile(), "gdocker_conf.json"},
+		DefaultText: fmt.Sprintf("`%s`, the nearest `gdocker_conf.json` from `.` to `$HOME`", anonymizeConfigFile(getGlobalConfigFile(), false)),
+		Value:       []string{getGlobalConfigFile(), findProjectConfigFile(getWd())},
 	}
 	FLAG_CONFIG_GLOBAL = &cli.StringSliceFlag{
 		Name:        "config",
@@ -240,6 +262,20 @@ var (
 		DefaultText: anonymizeConfigFile(getGlobalConfigFile(), false),
 		Value:       []string{getGlobalConfigFile()},
 	}
+	FLAG_LOCAL_RUN_LOG = &cli.BoolFlag{
+		Name:  "local-run-log",
+		Usage: "also record runs into './.gdocker-runs.jsonl'",
+		Value: false,
+	}
+	FLAG_LOCK_MODE = &cli.StringFlag{
+		Name:  "lock-mode",
+		Usage: "what run/wdrun do when an image does not match 'gdocker.lock' (`MODE`: refuse, warn or off)",
+	}
+	FLAG_HARDENED = &cli.BoolFlag{
+		Name:  "hardened",
+		Usage: "run containers offline and unprivileged",
+		Value: false,
+	}
 	FLAG_SHOW_ABSPATH = &cli.BoolFlag{
 		Name:  "show-abspath",
 		Usage: "show absolute path instead of annonymized path",
@@ -286,10 +322,53 @@ func getGlobalConfigFileDir() string {
 	return filepath.Join(dir, "gdocker")
 }
 
+const PROJECT_CONFIG_FILE = "gdocker_conf.json"
+
 func getGlobalConfigFile() string {
 	return filepath.Join(getGlobalConfigFileDir(), "gdocker_conf.json")
 }
 
+// getGlobalStateDir returns the directory to keep gdocker state files (e.g. run logs).
+// It follows $XDG_STATE_HOME and falls back to $HOME/.local/state.
+func getGlobalStateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "gdocker")
+	}
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	return filepath.Join(dir, ".local", "state", "gdocker")
+}
+
+// findProjectConfigFile searches the project configuration file from the directory
+// to its parents, the way git finds .git. The search stops at the home directory or
+// the filesystem root. If not found, it returns "gdocker_conf.json" (in the working directory).
+func findProjectConfigFile(dir string) string {
+	if file, ok := findFileUpward(dir, PROJECT_CONFIG_FILE); ok {
+		return file
+	}
+	return PROJECT_CONFIG_FILE
+}
+
+// findFileUpward searches the file named `name` from the directory up to the home (or root) directory.
+func findFileUpward(dir string, name string) (string, bool) {
+	home, _ := os.UserHomeDir()
+	for {
+		file := filepath.Join(dir, name)
+		if isFile(file) {
+			return file, true
+		}
+		parent := filepath.Dir(dir)
+		if dir == home || parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return "", false
+}
+
 func getDefaultDir() string {
 	dir, err := os.UserHomeDir()
 	if err != nil {
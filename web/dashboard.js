@@ -0,0 +1,163 @@
+// Dashboard: show details of the clicked image, queue build/clean jobs and stream their logs.
+(function () {
+  const status = document.getElementById("status");
+  const detail = document.getElementById("detail");
+  const jobList = document.getElementById("job-list");
+  const jobLog = document.getElementById("job-log");
+  let selected = null;
+  let version = null;
+  let logJob = null;
+
+  function el(tag, text, cls) {
+    const e = document.createElement(tag);
+    if (text !== undefined) e.textContent = text;
+    if (cls) e.className = cls;
+    return e;
+  }
+
+  function row(table, key, value) {
+    const tr = el("tr");
+    tr.appendChild(el("th", key));
+    tr.appendChild(el("td", value));
+    table.appendChild(tr);
+  }
+
+  function size(n) {
+    const units = ["B", "KB", "MB", "GB", "TB"];
+    let i = 0;
+    while (n >= 1000 && i < units.length - 1) {
+      n /= 1000;
+      i++;
+    }
+    return n.toFixed(i === 0 ? 0 : 1) + units[i];
+  }
+
+  function markSelected() {
+    document.querySelectorAll("#graph .node").forEach(function (n) {
+      n.classList.toggle("selected", n.dataset.id === selected);
+    });
+  }
+
+  async function showDetail(id) {
+    selected = id;
+    markSelected();
+    const res = await fetch("api/nodes/" + encodeURIComponent(id), { cache: "no-store" });
+    const d = await res.json();
+    detail.replaceChildren();
+    if (!res.ok) {
+      detail.appendChild(el("p", d.error, "failed"));
+      return;
+    }
+    detail.appendChild(el("h2", d.id));
+
+    const buttons = el("p");
+    ["build", "clean"].forEach(function (action) {
+      const b = el("button", action);
+      b.disabled = d.build_dir === undefined;
+      b.addEventListener("click", function () { queueJob(action, [d.id]); });
+      buttons.appendChild(b);
+      buttons.appendChild(document.createTextNode(" "));
+    });
+    detail.appendChild(buttons);
+
+    const table = el("table");
+    row(table, "status", d.status);
+    row(table, "class", d.class);
+    if (d.image_root) row(table, "root", d.image_root);
+    if (d.build_dir) row(table, "build dir", d.build_dir);
+    if (d.image_id) {
+      row(table, "image ID", d.image_id);
+      row(table, "size", size(d.size));
+      row(table, "created", new Date(d.created).toLocaleString());
+    }
+    if (d.parents.length) row(table, "depends on", d.parents.join(", "));
+    if (d.children.length) row(table, "used by", d.children.join(", "));
+    detail.appendChild(table);
+
+    if (d.labels && Object.keys(d.labels).length) {
+      detail.appendChild(el("h2", "Labels"));
+      const labels = el("table");
+      Object.keys(d.labels).sort().forEach(function (k) { row(labels, k, d.labels[k]); });
+      detail.appendChild(labels);
+    }
+    if (d.resources.length) {
+      detail.appendChild(el("h2", "Makefile resources"));
+      d.resources.forEach(function (r) {
+        detail.appendChild(el("pre", r.name + ":\n\t" + r.commands.join("\n\t")));
+      });
+    }
+    if (d.dockerfile) {
+      detail.appendChild(el("h2", "Dockerfile"));
+      detail.appendChild(el("pre", d.dockerfile));
+    }
+  }
+
+  async function queueJob(action, images) {
+    const res = await fetch("api/jobs", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ action: action, images: images }),
+    });
+    const j = await res.json();
+    if (!res.ok) {
+      status.textContent = j.error;
+      return;
+    }
+    await refreshJobs();
+    streamLog(j.id);
+  }
+
+  async function streamLog(id) {
+    logJob = id;
+    jobLog.textContent = "";
+    const res = await fetch("api/jobs/" + id + "/log", { cache: "no-store" });
+    const reader = res.body.getReader();
+    const decoder = new TextDecoder();
+    for (;;) {
+      const { done, value } = await reader.read();
+      if (done || logJob !== id) break;
+      jobLog.textContent += decoder.decode(value, { stream: true });
+      jobLog.scrollTop = jobLog.scrollHeight;
+    }
+  }
+
+  async function refreshJobs() {
+    const res = await fetch("api/jobs", { cache: "no-store" });
+    const jobs = await res.json();
+    jobList.replaceChildren();
+    jobs.slice().reverse().forEach(function (j) {
+      const tr = el("tr", undefined, j.state === "failed" ? "failed" : "");
+      tr.appendChild(el("td", "#" + j.id));
+      tr.appendChild(el("td", j.action));
+      tr.appendChild(el("td", j.images.join(" ")));
+      tr.appendChild(el("td", j.state));
+      tr.addEventListener("click", function () { streamLog(j.id); });
+      jobList.appendChild(tr);
+    });
+  }
+
+  document.getElementById("graph").addEventListener("click", function (ev) {
+    const node = ev.target.closest(".node");
+    if (node) showDetail(node.dataset.id);
+  });
+
+  async function poll() {
+    try {
+      const res = await fetch("version", { cache: "no-store" });
+      const v = (await res.text()).trim();
+      if (version !== null && v !== version) {
+        const svg = await fetch("graph.svg", { cache: "no-store" });
+        document.getElementById("graph").innerHTML = await svg.text();
+        markSelected();
+        if (selected !== null) showDetail(selected);
+        status.textContent = "updated at " + new Date().toLocaleTimeString();
+      }
+      version = v;
+      await refreshJobs();
+    } catch (e) {
+      status.textContent = "disconnected";
+    }
+    setTimeout(poll, 1000);
+  }
+  poll();
+})();
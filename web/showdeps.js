@@ -0,0 +1,26 @@
+// Poll the server and replace the graph when image build directories are changed.
+(function () {
+  let version = null;
+  const status = document.getElementById("status");
+  async function poll() {
+    try {
+      const res = await fetch("version", { cache: "no-store" });
+      const v = (await res.text()).trim();
+      if (version !== null && v !== version) {
+        // the graph is kept as the last good one if the reload failed
+        const err = (await (await fetch("error", { cache: "no-store" })).text()).trim();
+        const svg = await fetch("graph.svg", { cache: "no-store" });
+        document.getElementById("graph").innerHTML = await svg.text();
+        const mmd = await fetch("graph.mmd", { cache: "no-store" });
+        document.querySelector("#mermaid pre").textContent = await mmd.text();
+        status.classList.toggle("error", err !== "");
+        status.textContent = err !== "" ? "error: " + err : "updated at " + new Date().toLocaleTimeString();
+      }
+      version = v;
+    } catch (e) {
+      status.textContent = "disconnected";
+    }
+    setTimeout(poll, 1000);
+  }
+  poll();
+})();
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// yamlNode is a value decoded from JSON (or YAML), keeping the order of object keys.
+type yamlNode struct {
+	kind   byte // 'm' (mapping), 's' (sequence) or 'v' (scalar)
+	keys   []string
+	values []*yamlNode
+	scalar string // in YAML for marshalYAML, and in JSON for yamlToJSON
+}
+
+// marshalYAML converts a value to YAML through its JSON encoding,
+// so `json` tags and the order of struct fields are respected.
+// Only the block style is used, except for empty mappings and sequences.
+func marshalYAML(v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	n, err := decodeYAMLNode(dec)
+	if err != nil {
+		return nil, err
+	}
+	if n.kind == 'v' || len(n.values) == 0 {
+		return []byte(n.inline() + "\n"), nil
+	}
+	return []byte(strings.Join(n.lines(), "\n") + "\n"), nil
+}
+
+func decodeYAMLNode(dec *json.Decoder) (*yamlNode, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := t.(type) {
+	case json.Delim:
+		n := &yamlNode{kind: 'm'}
+		if t == '[' {
+			n.kind = 's'
+		}
+		for dec.More() {
+			if n.kind == 'm' {
+				k, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.keys = append(n.keys, k.(string))
+			}
+			v, err := decodeYAMLNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			n.values = append(n.values, v)
+		}
+		// consume the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case string:
+		return &yamlNode{kind: 'v', scalar: quoteYAML(t)}, nil
+	case nil:
+		return &yamlNode{kind: 'v', scalar: "null"}, nil
+	default:
+		return &yamlNode{kind: 'v', scalar: fmt.Sprint(t)}, nil
+	}
+}
+
+// inline returns the node in a single line (scalars and empty collections only).
+func (n *yamlNode) inline() string {
+	switch n.kind {
+	case 'm':
+		return "{}"
+	case 's':
+		return "[]"
+	}
+	return n.scalar
+}
+
+// lines returns the node in the block style.
+func (n *yamlNode) lines() []string {
+	var lines []string
+	for i, v := range n.values {
+		var prefix string
+		if n.kind == 'm' {
+			prefix = quoteYAML(n.keys[i]) + ":"
+		} else {
+			prefix = "-"
+		}
+		if v.kind == 'v' || len(v.values) == 0 {
+			lines = append(lines, prefix+" "+v.inline())
+			continue
+		}
+		sub := v.lines()
+		if n.kind == 's' && v.kind == 'm' {
+			// a mapping in a sequence starts on the same line as "-"
+			lines = append(lines, prefix+" "+sub[0])
+			sub = sub[1:]
+		} else {
+			lines = append(lines, prefix)
+		}
+		for _, line := range sub {
+			lines = append(lines, "  "+line)
+		}
+	}
+	return lines
+}
+
+var (
+	reYAMLPlain    = regexp.MustCompile(`^[A-Za-z0-9_./@+-][A-Za-z0-9_./@:+ -]*$`)
+	reYAMLNumber   = regexp.MustCompile(`^[-+]?(\.?[0-9]|0[xo])`)
+	yamlReservedWd = []string{"true", "false", "yes", "no", "on", "off", "null", "~", "y", "n"}
+)
+
+// quoteYAML returns the string as a plain scalar if possible, or a double-quoted one.
+func quoteYAML(s string) string {
+	plain := reYAMLPlain.MatchString(s) &&
+		!strings.Contains(s, ": ") &&
+		!strings.HasSuffix(s, ":") &&
+		!strings.HasSuffix(s, " ") &&
+		!strings.HasPrefix(s, "- ") &&
+		!reYAMLNumber.MatchString(s)
+	for _, w := range yamlReservedWd {
+		if strings.EqualFold(s, w) {
+			plain = false
+		}
+	}
+	if plain {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// yamlLine is a line of a YAML document without the indentation and the comment.
+type yamlLine struct {
+	num    int // line number (1-based)
+	indent int
+	text   string
+}
+
+// yamlDecoder decodes a subset of YAML: block mappings and sequences, flow collections
+// in a line, plain and quoted scalars, literal (|) and folded (>) block scalars, and comments.
+// Anchors, tags and multiple documents are not supported.
+type yamlDecoder struct {
+	raw   []string // lines as is (for block scalars)
+	lines []yamlLine
+	pos   int
+}
+
+// yamlToJSON converts a YAML document to JSON, keeping the order of mapping keys.
+// Plain scalars are typed as in the YAML core schema (null, booleans and numbers).
+func yamlToJSON(b []byte) ([]byte, error) {
+	text := strings.TrimSuffix(strings.ReplaceAll(string(b), "\r\n", "\n"), "\n")
+	dec := &yamlDecoder{raw: strings.Split(text, "\n")}
+	for i, line := range dec.raw {
+		trimmed := strings.TrimLeft(line, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		text := strings.TrimRight(stripYAMLComment(trimmed), " \t")
+		if text == "" || (text == "---" && len(dec.lines) == 0) {
+			continue
+		}
+		dec.lines = append(dec.lines, yamlLine{num: i + 1, indent: len(line) - len(trimmed), text: text})
+	}
+	if len(dec.lines) == 0 {
+		return []byte("null"), nil
+	}
+	n, err := dec.node(dec.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if dec.pos < len(dec.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", dec.lines[dec.pos].num)
+	}
+	var buf bytes.Buffer
+	n.appendJSON(&buf)
+	return buf.Bytes(), nil
+}
+
+// stripYAMLComment removes the comment ("#" at the beginning or after a space) outside quotes.
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" [{,:-", rune(s[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// node decodes the block node at the indentation.
+func (dec *yamlDecoder) node(indent int) (*yamlNode, error) {
+	line := dec.lines[dec.pos]
+	if line.indent != indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+	}
+	if line.text == "-" || strings.HasPrefix(line.text, "- ") {
+		return dec.sequence(indent)
+	}
+	if _, _, ok := cutYAMLKey(line.text); ok {
+		return dec.mapping(indent)
+	}
+	dec.pos++
+	return parseYAMLFlow(line.text, line.num)
+}
+
+func (dec *yamlDecoder) sequence(indent int) (*yamlNode, error) {
+	n := &yamlNode{kind: 's'}
+	for dec.pos < len(dec.lines) {
+		line := dec.lines[dec.pos]
+		item := line.text == "-" || strings.HasPrefix(line.text, "- ")
+		// a sequence at the indentation of its key ends at the next key
+		if line.indent < indent || (line.indent == indent && !item) {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: a sequence item is expected", line.num)
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if rest == "" {
+			dec.pos++
+			v, err := dec.child(indent)
+			if err != nil {
+				return nil, err
+			}
+			n.values = append(n.values, v)
+			continue
+		}
+		// the item starts on the same line as "-" (e.g. "- key: value")
+		dec.lines[dec.pos] = yamlLine{num: line.num, indent: line.indent + len(line.text) - len(rest), text: rest}
+		v, err := dec.node(dec.lines[dec.pos].indent)
+		if err != nil {
+			return nil, err
+		}
+		n.values = append(n.values, v)
+	}
+	return n, nil
+}
+
+func (dec *yamlDecoder) mapping(indent int) (*yamlNode, error) {
+	n := &yamlNode{kind: 'm'}
+	for dec.pos < len(dec.lines) {
+		line := dec.lines[dec.pos]
+		if line.indent < indent {
+			break
+		}
+		key, value, ok := cutYAMLKey(line.text)
+		if line.indent > indent || !ok {
+			return nil, fmt.Errorf("line %d: a mapping key is expected", line.num)
+		}
+		if slices.Contains(n.keys, key) {
+			return nil, fmt.Errorf("line %d: duplicated key '%s'", line.num, key)
+		}
+		dec.pos++
+		var v *yamlNode
+		var err error
+		switch {
+		case value == "":
+			v, err = dec.child(indent)
+		case value[0] == '|' || value[0] == '>':
+			v, err = dec.blockScalar(line, value)
+		default:
+			v, err = parseYAMLFlow(value, line.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		n.keys = append(n.keys, key)
+		n.values = append(n.values, v)
+	}
+	return n, nil
+}
+
+// child decodes the value on the following lines of a key or "-" at the indentation.
+// A sequence can be at the same indentation as its key.
+func (dec *yamlDecoder) child(indent int) (*yamlNode, error) {
+	if dec.pos >= len(dec.lines) {
+		return &yamlNode{kind: 'v', scalar: "null"}, nil
+	}
+	next := dec.lines[dec.pos]
+	switch {
+	case next.indent > indent:
+		return dec.node(next.indent)
+	case next.indent == indent && (next.text == "-" || strings.HasPrefix(next.text, "- ")) && dec.isMappingValue(indent):
+		return dec.sequence(indent)
+	}
+	return &yamlNode{kind: 'v', scalar: "null"}, nil
+}
+
+// isMappingValue reports whether the previous line is a key at the indentation.
+func (dec *yamlDecoder) isMappingValue(indent int) bool {
+	prev := dec.lines[dec.pos-1]
+	_, _, ok := cutYAMLKey(prev.text)
+	return ok && prev.indent == indent
+}
+
+// blockScalar decodes a literal (|) or folded (>) block scalar following the line.
+func (dec *yamlDecoder) blockScalar(line yamlLine, header string) (*yamlNode, error) {
+	if len(header) > 2 || (len(header) == 2 && header[1] != '-' && header[1] != '+') {
+		return nil, fmt.Errorf("line %d: unsupported block scalar header '%s'", line.num, header)
+	}
+	// take raw lines (comments are the content) until a less indented line
+	var body []string
+	indent := -1
+	i := line.num
+	for ; i < len(dec.raw); i++ {
+		raw := dec.raw[i]
+		trimmed := strings.TrimLeft(raw, " ")
+		if trimmed == "" {
+			body = append(body, "")
+			continue
+		}
+		ind := len(raw) - len(trimmed)
+		if indent < 0 {
+			indent = ind
+		}
+		if ind < indent || ind <= line.indent {
+			break
+		}
+		body = append(body, raw[indent:])
+	}
+	for dec.pos < len(dec.lines) && dec.lines[dec.pos].num <= i {
+		dec.pos++
+	}
+
+	// chomping: strip (-), keep (+), or clip (a single final newline)
+	keep := len(body)
+	for keep > 0 && body[keep-1] == "" {
+		keep--
+	}
+	trailing := len(body) - keep
+	body = body[:keep]
+	var s string
+	if header[0] == '|' {
+		s = strings.Join(body, "\n")
+	} else {
+		var b strings.Builder
+		for j, l := range body {
+			switch {
+			case j == 0:
+			case l == "" || body[j-1] == "":
+				b.WriteString("\n")
+			default:
+				b.WriteString(" ")
+			}
+			b.WriteString(l)
+		}
+		s = strings.ReplaceAll(b.String(), "\n\n", "\n")
+	}
+	switch {
+	case len(body) == 0:
+	case strings.HasSuffix(header, "-"):
+	case strings.HasSuffix(header, "+"):
+		s += strings.Repeat("\n", trailing+1)
+	default:
+		s += "\n"
+	}
+	b, _ := json.Marshal(s)
+	return &yamlNode{kind: 'v', scalar: string(b)}, nil
+}
+
+// cutYAMLKey splits "key: value" (or "key:") outside quotes and flow collections.
+func cutYAMLKey(s string) (string, string, bool) {
+	if s == "" || strings.ContainsRune("[{", rune(s[0])) {
+		return "", "", false
+	}
+	end := 0
+	if s[0] == '"' || s[0] == '\'' {
+		end = quotedYAMLEnd(s)
+		if end < 0 {
+			return "", "", false
+		}
+	}
+	i := strings.Index(s[end:], ":")
+	for i >= 0 {
+		j := end + i
+		if j+1 == len(s) || s[j+1] == ' ' {
+			key := strings.TrimSpace(s[:j])
+			if key[0] == '"' || key[0] == '\'' {
+				v, err := unquoteYAML(key)
+				if err != nil {
+					return "", "", false
+				}
+				key = v
+			}
+			return key, strings.TrimSpace(s[j+1:]), true
+		}
+		end = j + 1
+		i = strings.Index(s[end:], ":")
+	}
+	return "", "", false
+}
+
+// quotedYAMLEnd returns the index after the closing quote of the quoted scalar at the beginning.
+func quotedYAMLEnd(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func unquoteYAML(s string) (string, error) {
+	if s[0] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	var v string
+	err := json.Unmarshal([]byte(s), &v)
+	return v, err
+}
+
+var reYAMLNumberValue = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// parseYAMLFlow parses a scalar or a flow collection (e.g. [a, b], {k: v}) in a line.
+func parseYAMLFlow(s string, num int) (*yamlNode, error) {
+	n, rest, err := parseYAMLFlowNode(s, false)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", num, err)
+	}
+	if strings.TrimSpace(rest) != "" {
+		return nil, fmt.Errorf("line %d: unexpected '%s'", num, strings.TrimSpace(rest))
+	}
+	return n, nil
+}
+
+// parseYAMLFlowNode parses a node at the beginning of s and returns the rest.
+// In a flow collection, plain scalars end at "," and closing brackets.
+func parseYAMLFlowNode(s string, inFlow bool) (*yamlNode, string, error) {
+	s = strings.TrimLeft(s, " ")
+	if s == "" {
+		return &yamlNode{kind: 'v', scalar: "null"}, "", nil
+	}
+	switch s[0] {
+	case '[', '{':
+		n := &yamlNode{kind: 's'}
+		closing := "]"
+		if s[0] == '{' {
+			n.kind, closing = 'm', "}"
+		}
+		s = strings.TrimLeft(s[1:], " ")
+		for !strings.HasPrefix(s, closing) {
+			if n.kind == 'm' {
+				k, rest, err := parseYAMLFlowNode(s, true)
+				if err != nil {
+					return nil, "", err
+				}
+				rest = strings.TrimLeft(rest, " ")
+				if !strings.HasPrefix(rest, ":") {
+					return nil, "", fmt.Errorf("':' is expected in a flow mapping")
+				}
+				var key string
+				json.Unmarshal([]byte(k.scalar), &key)
+				if k.kind != 'v' || key == "" {
+					key = strings.Trim(k.scalar, `"`)
+				}
+				n.keys = append(n.keys, key)
+				s = rest[1:]
+			}
+			v, rest, err := parseYAMLFlowNode(s, true)
+			if err != nil {
+				return nil, "", err
+			}
+			n.values = append(n.values, v)
+			s = strings.TrimLeft(rest, " ")
+			if strings.HasPrefix(s, ",") {
+				s = strings.TrimLeft(s[1:], " ")
+			} else if !strings.HasPrefix(s, closing) {
+				return nil, "", fmt.Errorf("'%s' is expected", closing)
+			}
+		}
+		return n, s[1:], nil
+	case '"', '\'':
+		end := quotedYAMLEnd(s)
+		if end < 0 {
+			return nil, "", fmt.Errorf("unterminated quoted scalar")
+		}
+		v, err := unquoteYAML(s[:end])
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid quoted scalar %s", s[:end])
+		}
+		b, _ := json.Marshal(v)
+		return &yamlNode{kind: 'v', scalar: string(b)}, s[end:], nil
+	}
+
+	end := len(s)
+	if inFlow {
+		if i := strings.IndexAny(s, ",]}"); i >= 0 {
+			end = i
+		}
+		if i := strings.Index(s, ": "); i >= 0 && i < end {
+			end = i
+		}
+		if strings.HasSuffix(s[:end], ":") {
+			end--
+		}
+	}
+	plain := strings.TrimSpace(s[:end])
+	n := &yamlNode{kind: 'v'}
+	switch {
+	case plain == "" || plain == "~" || plain == "null":
+		n.scalar = "null"
+	case plain == "true" || plain == "false":
+		n.scalar = plain
+	case reYAMLNumberValue.MatchString(plain):
+		n.scalar = plain
+	default:
+		b, _ := json.Marshal(plain)
+		n.scalar = string(b)
+	}
+	return n, s[end:], nil
+}
+
+// appendJSON writes the decoded node (scalars are JSON literals) as JSON.
+func (n *yamlNode) appendJSON(buf *bytes.Buffer) {
+	switch n.kind {
+	case 'm':
+		buf.WriteString("{")
+		for i, k := range n.keys {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			b, _ := json.Marshal(k)
+			buf.Write(b)
+			buf.WriteString(":")
+			n.values[i].appendJSON(buf)
+		}
+		buf.WriteString("}")
+	case 's':
+		buf.WriteString("[")
+		for i, v := range n.values {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			v.appendJSON(buf)
+		}
+		buf.WriteString("]")
+	default:
+		buf.WriteString(n.scalar)
+	}
+}